@@ -2,9 +2,15 @@ package main
 
 import (
 	"log"
+	"time"
 
+	"github.com/hessayon/ya_practicum_go/internal/analytics"
+	"github.com/hessayon/ya_practicum_go/internal/codegen"
 	"github.com/hessayon/ya_practicum_go/internal/config"
+	"github.com/hessayon/ya_practicum_go/internal/deleting"
+	"github.com/hessayon/ya_practicum_go/internal/health"
 	"github.com/hessayon/ya_practicum_go/internal/logger"
+	"github.com/hessayon/ya_practicum_go/internal/policy"
 	"github.com/hessayon/ya_practicum_go/internal/router"
 	"github.com/hessayon/ya_practicum_go/internal/storage"
 	"github.com/hessayon/ya_practicum_go/internal/app"
@@ -24,20 +30,79 @@ func main() {
 		log.Fatalf("Error in NewServiceLogger: %s", err.Error())
 	}
 	var urlStorage storage.URLStorage
-	if config.Config.DBDsn != "" {
-		urlStorage, err = storage.NewDBURLStorage(config.Config.DBDsn)
+	storageKind := config.Config.StorageKind()
+	switch storageKind {
+	case "postgres":
+		urlStorage, err = storage.NewDBURLStorage(config.Config.DBDsn, storage.DBOptions{
+			BatchChunkSize: config.Config.DBBatchChunkSize,
+		})
 		if err != nil {
 			log.Fatalf("Error in NewDBURLStorage: %s", err.Error())
 		}
-	} else {
-		urlStorage, err = storage.NewURLStorage(config.Config.Filename)
+	case "kv":
+		urlStorage, err = storage.NewKVURLStorage(config.Config.Filename)
+		if err != nil {
+			log.Fatalf("Error in NewKVURLStorage: %s", err.Error())
+		}
+	case "file":
+		urlStorage, err = storage.NewFileURLStorage(config.Config.Filename, storage.FileOptions{
+			SyncPolicy:      config.Config.FileSync,
+			SyncInterval:    config.Config.FileSyncInterval,
+			CompactInterval: config.Config.CompactInterval,
+		})
+		if err != nil {
+			log.Fatalf("Error in NewFileURLStorage: %s", err.Error())
+		}
+	default:
+		urlStorage, err = storage.NewURLStorage("")
 		if err != nil {
 			log.Fatalf("Error in NewURLStorage: %s", err.Error())
 		}
 	}
+	urlStorage = storage.NewInstrumentedStorage(urlStorage, storageKind)
+	if config.Config.CacheSize > 0 {
+		urlStorage = storage.NewCachedStorage(urlStorage, storage.NewLRUCache(config.Config.CacheSize), config.Config.CacheTTL)
+	}
 
-	serviceRouter := router.NewServiceRouter(logger.Log, urlStorage)
+	urlDeleter := deleting.NewURLDeleter(urlStorage, 4, config.Config.DeleteQueueSize, 100, time.Second)
 
-	application := app.NewAppInstance(serviceRouter, urlStorage, logger.Log, config.Config)
-	log.Fatal(application.Run())
+	codeGenerator, err := codegen.New(config.Config.CodeGenerator, config.Config.CodeLength, urlStorage)
+	if err != nil {
+		log.Fatalf("Error in codegen.New: %s", err.Error())
+	}
+
+	var clicksSink analytics.Sink
+	// таблицу clicks создают миграции хранилища в Postgres, поэтому DBSink работает только рядом с ним
+	if storageKind == "postgres" {
+		clicksSink, err = analytics.NewDBSink(config.Config.DBDsn)
+		if err != nil {
+			log.Fatalf("Error in NewDBSink: %s", err.Error())
+		}
+	} else if config.Config.AnalyticsFilename != "" {
+		clicksSink, err = analytics.NewFileSink(config.Config.AnalyticsFilename)
+		if err != nil {
+			log.Fatalf("Error in NewFileSink: %s", err.Error())
+		}
+	} else {
+		clicksSink = analytics.NewMemorySink()
+	}
+	clickRecorder := analytics.NewRecorder(clicksSink, []byte(config.Config.SecretKey), 1024, 100, time.Second)
+
+	var policyEngine *policy.Engine
+	if config.Config.PolicyFile != "" {
+		policyEngine, err = policy.Load(config.Config.PolicyFile, config.Config.PolicyReloadInterval)
+		if err != nil {
+			log.Fatalf("Error in policy.Load: %s", err.Error())
+		}
+	}
+
+	healthChecker := health.New(2 * time.Second)
+	healthChecker.Register("storage", urlStorage)
+
+	serviceRouter := router.NewServiceRouter(logger.Log, urlStorage, urlDeleter, codeGenerator, clickRecorder, healthChecker, policyEngine)
+
+	application := app.NewAppInstance(serviceRouter, urlStorage, urlDeleter, clickRecorder, policyEngine, logger.Log, config.Config)
+	if err := application.Run(); err != nil {
+		log.Fatalf("Error in application.Run: %s", err.Error())
+	}
 }
@@ -0,0 +1,235 @@
+package analytics
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hessayon/ya_practicum_go/internal/logger"
+	"github.com/hessayon/ya_practicum_go/internal/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	UAClassBot     = "bot"
+	UAClassMobile  = "mobile"
+	UAClassDesktop = "desktop"
+	UAClassOther   = "other"
+
+	// TopReferrersLimit — сколько источников переходов отдаётся в статистике
+	TopReferrersLimit = 10
+
+	// dropReportInterval — не чаще этого в лог пишется число отброшенных событий
+	dropReportInterval = time.Minute
+)
+
+// ClickEvent — один переход по сокращённой ссылке
+type ClickEvent struct {
+	ShortURL  string    `json:"short_url"`
+	ClickedAt time.Time `json:"clicked_at"`
+	Referrer  string    `json:"referrer"`
+	IPHash    string    `json:"ip_hash"`
+	UAClass   string    `json:"ua_class"`
+}
+
+type DayClicks struct {
+	Date   string `json:"date"`
+	Clicks int    `json:"clicks"`
+}
+
+type ReferrerClicks struct {
+	Referrer string `json:"referrer"`
+	Clicks   int    `json:"clicks"`
+}
+
+type Stats struct {
+	TotalClicks  int              `json:"total_clicks"`
+	ClicksPerDay []DayClicks      `json:"clicks_per_day"`
+	TopReferrers []ReferrerClicks `json:"top_referrers"`
+}
+
+// Sink — место, куда сбрасываются накопленные события и откуда читается статистика
+type Sink interface {
+	WriteClicks(ctx context.Context, events []ClickEvent) error
+	GetStats(ctx context.Context, shortURL string) (*Stats, error)
+	// DeleteClicks удаляет статистику кодов, освобождённых хранилищем: иначе новая ссылка
+	// с тем же кодом унаследовала бы переходы старой
+	DeleteClicks(ctx context.Context, shortURLs []string) error
+	Close() error
+}
+
+// ClassifyUserAgent грубо относит клиента к одному из классов по заголовку User-Agent
+func ClassifyUserAgent(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case ua == "":
+		return UAClassOther
+	case strings.Contains(ua, "bot"), strings.Contains(ua, "crawler"), strings.Contains(ua, "spider"),
+		strings.Contains(ua, "curl"), strings.Contains(ua, "wget"):
+		return UAClassBot
+	case strings.Contains(ua, "mobile"), strings.Contains(ua, "android"), strings.Contains(ua, "iphone"):
+		return UAClassMobile
+	case strings.Contains(ua, "windows"), strings.Contains(ua, "macintosh"), strings.Contains(ua, "x11"),
+		strings.Contains(ua, "linux"):
+		return UAClassDesktop
+	default:
+		return UAClassOther
+	}
+}
+
+//--------------------------------------------------------------------
+
+// Recorder копит события в памяти и асинхронно сбрасывает их в Sink батчами.
+// Если буфер переполнен, событие отбрасывается, чтобы не задерживать редирект;
+// отброшенные события считаются и периодически попадают в лог одной строкой.
+type Recorder struct {
+	sink          Sink
+	salt          []byte
+	events        chan ClickEvent
+	batchSize     int
+	flushInterval time.Duration
+	mu            sync.RWMutex
+	closed        bool
+	done          chan struct{}
+	dropped       atomic.Uint64
+	// reported — сколько отброшенных событий уже попало в лог
+	reported   uint64
+	reportedAt time.Time
+}
+
+func NewRecorder(sink Sink, salt []byte, bufferSize int, batchSize int, flushInterval time.Duration) *Recorder {
+	rec := &Recorder{
+		sink:          sink,
+		salt:          salt,
+		events:        make(chan ClickEvent, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		done:          make(chan struct{}),
+	}
+	go rec.flusher()
+	return rec
+}
+
+// RecordClick строит событие по запросу и ставит его в очередь без блокировки.
+// clientIP — адрес клиента с учётом доверенных прокси: за прокси RemoteAddr у всех запросов один
+func (rec *Recorder) RecordClick(r *http.Request, shortURL string, clientIP string) {
+	rec.Record(ClickEvent{
+		ShortURL:  shortURL,
+		ClickedAt: time.Now().UTC(),
+		Referrer:  referrerOrigin(r.Referer()),
+		IPHash:    rec.hashIP(clientIP),
+		UAClass:   ClassifyUserAgent(r.UserAgent()),
+	})
+}
+
+func (rec *Recorder) Record(event ClickEvent) {
+	rec.mu.RLock()
+	defer rec.mu.RUnlock()
+	if rec.closed {
+		return
+	}
+	select {
+	case rec.events <- event:
+	default:
+		rec.dropped.Add(1)
+		metrics.ClickEventsDropped.Inc()
+	}
+}
+
+// Dropped возвращает число событий, отброшенных из-за переполнения буфера
+func (rec *Recorder) Dropped() uint64 {
+	return rec.dropped.Load()
+}
+
+// reportDropped пишет в лог, сколько событий отброшено с прошлого отчёта, но не чаще
+// dropReportInterval; force нужен при закрытии, чтобы не потерять последний отчёт
+func (rec *Recorder) reportDropped(now time.Time, force bool) {
+	if !force && now.Sub(rec.reportedAt) < dropReportInterval {
+		return
+	}
+	dropped := rec.dropped.Load()
+	if dropped == rec.reported {
+		return
+	}
+	logger.Log.Warn("click events are dropped: buffer is full", zap.Uint64("dropped", dropped-rec.reported))
+	rec.reported, rec.reportedAt = dropped, now
+}
+
+func (rec *Recorder) GetStats(ctx context.Context, shortURL string) (*Stats, error) {
+	return rec.sink.GetStats(ctx, shortURL)
+}
+
+func (rec *Recorder) DeleteClicks(ctx context.Context, shortURLs []string) error {
+	return rec.sink.DeleteClicks(ctx, shortURLs)
+}
+
+// Close дожидается записи всех принятых событий и закрывает Sink
+func (rec *Recorder) Close() error {
+	rec.mu.Lock()
+	if rec.closed {
+		rec.mu.Unlock()
+		return nil
+	}
+	rec.closed = true
+	close(rec.events)
+	rec.mu.Unlock()
+	<-rec.done
+	return rec.sink.Close()
+}
+
+// referrerOrigin оставляет от Referer только схему и хост: путь и параметры
+// могут содержать личные данные посетителя
+func referrerOrigin(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host)
+}
+
+// hashIP хранит только солёный хэш адреса клиента, сам адрес не сохраняется
+func (rec *Recorder) hashIP(ip string) string {
+	h := hmac.New(sha256.New, rec.salt)
+	h.Write([]byte(ip))
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func (rec *Recorder) flusher() {
+	defer close(rec.done)
+	ticker := time.NewTicker(rec.flushInterval)
+	defer ticker.Stop()
+	batch := make([]ClickEvent, 0, rec.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := rec.sink.WriteClicks(context.Background(), batch); err != nil {
+			logger.Log.Error("Error in sink.WriteClicks()", zap.Int("batch_size", len(batch)), zap.String("error", err.Error()))
+		}
+		batch = make([]ClickEvent, 0, rec.batchSize)
+	}
+	for {
+		select {
+		case event, ok := <-rec.events:
+			if !ok {
+				flush()
+				rec.reportDropped(time.Now(), true)
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) >= rec.batchSize {
+				flush()
+			}
+		case now := <-ticker.C:
+			flush()
+			rec.reportDropped(now, false)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package analytics
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyUserAgent(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{userAgent: "", want: UAClassOther},
+		{userAgent: "Googlebot/2.1 (+http://www.google.com/bot.html)", want: UAClassBot},
+		{userAgent: "curl/8.4.0", want: UAClassBot},
+		{userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148", want: UAClassMobile},
+		{userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0", want: UAClassDesktop},
+	}
+	for _, test := range tests {
+		t.Run(test.want, func(t *testing.T) {
+			assert.Equal(t, test.want, ClassifyUserAgent(test.userAgent))
+		})
+	}
+}
+
+func TestRecorderFlushesToSink(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "clicks.json")
+	sink, err := NewFileSink(filename)
+	require.NoError(t, err)
+	rec := NewRecorder(sink, []byte("secret"), 16, 2, time.Hour)
+
+	// от источника остаются только схема и хост, путь с параметрами не сохраняется
+	for _, referrer := range []string{"https://ya.ru/search?text=secret", "https://YA.ru/", "https://google.com/mail/inbox", ""} {
+		r := httptest.NewRequest("GET", "/EwHXdJfB", nil)
+		r.Header.Set("Referer", referrer)
+		rec.RecordClick(r, "EwHXdJfB", "203.0.113.5")
+	}
+	require.NoError(t, rec.Close())
+
+	// статистика восстанавливается из файла после перезапуска
+	sink, err = NewFileSink(filename)
+	require.NoError(t, err)
+	defer sink.Close()
+	stats, err := sink.GetStats(context.Background(), "EwHXdJfB")
+	require.NoError(t, err)
+	assert.Equal(t, 4, stats.TotalClicks)
+	require.Len(t, stats.ClicksPerDay, 1)
+	assert.Equal(t, 4, stats.ClicksPerDay[0].Clicks)
+	assert.Equal(t, []ReferrerClicks{
+		{Referrer: "https://ya.ru", Clicks: 2},
+		{Referrer: "https://google.com", Clicks: 1},
+	}, stats.TopReferrers)
+
+	stats, err = sink.GetStats(context.Background(), "unknown")
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.TotalClicks)
+}
+
+func TestFileSinkTornTail(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "clicks.json")
+	const goodLine = `{"short_url":"a","clicked_at":"2026-01-02T03:04:05Z","referrer":"","ip_hash":"x","ua_class":"other"}` + "\n"
+	// запись оборвалась на середине строки
+	require.NoError(t, os.WriteFile(filename, []byte(goodLine+`{"short_url":"a","clic`), 0o600))
+
+	sink, err := NewFileSink(filename)
+	require.NoError(t, err)
+	content, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, goodLine, string(content))
+	require.NoError(t, sink.WriteClicks(context.Background(), []ClickEvent{{ShortURL: "a", ClickedAt: time.Now()}}))
+	require.NoError(t, sink.Close())
+
+	// новое событие не склеилось с оборванной строкой и пережило перезапуск
+	sink, err = NewFileSink(filename)
+	require.NoError(t, err)
+	defer sink.Close()
+	stats, err := sink.GetStats(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.TotalClicks)
+}
+
+func TestFileSinkDeleteClicks(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "clicks.json")
+	sink, err := NewFileSink(filename)
+	require.NoError(t, err)
+	require.NoError(t, sink.WriteClicks(ctx, []ClickEvent{{ShortURL: "a", ClickedAt: time.Now()}, {ShortURL: "b", ClickedAt: time.Now()}}))
+	require.NoError(t, sink.DeleteClicks(ctx, []string{"a"}))
+	// код освободился и достался новой ссылке
+	require.NoError(t, sink.WriteClicks(ctx, []ClickEvent{{ShortURL: "a", ClickedAt: time.Now()}}))
+	check := func(sink *FileSink) {
+		stats, err := sink.GetStats(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, 1, stats.TotalClicks)
+		stats, err = sink.GetStats(ctx, "b")
+		require.NoError(t, err)
+		assert.Equal(t, 1, stats.TotalClicks)
+	}
+	check(sink)
+	require.NoError(t, sink.Close())
+
+	// удаление переживает перезапуск
+	sink, err = NewFileSink(filename)
+	require.NoError(t, err)
+	defer sink.Close()
+	check(sink)
+}
+
+// blockingSink не даёт сбросить батч, пока тест не отпустит release
+type blockingSink struct {
+	Sink
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSink) WriteClicks(ctx context.Context, events []ClickEvent) error {
+	s.writing <- struct{}{}
+	<-s.release
+	return s.Sink.WriteClicks(ctx, events)
+}
+
+func TestRecorderCountsDroppedEvents(t *testing.T) {
+	sink := &blockingSink{Sink: NewMemorySink(), writing: make(chan struct{}, 1), release: make(chan struct{})}
+	rec := NewRecorder(sink, []byte("secret"), 1, 1, time.Hour)
+
+	rec.Record(ClickEvent{ShortURL: "a"})
+	<-sink.writing
+	// первое событие уже сбрасывается, второе ждёт в буфере, остальные отбрасываются
+	for i := 0; i < 4; i++ {
+		rec.Record(ClickEvent{ShortURL: "a"})
+	}
+	assert.Equal(t, uint64(3), rec.Dropped())
+
+	close(sink.release)
+	require.NoError(t, rec.Close())
+	stats, err := sink.GetStats(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.TotalClicks)
+}
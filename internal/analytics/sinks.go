@@ -0,0 +1,301 @@
+package analytics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+const dayLayout = "2006-01-02"
+
+type linkStats struct {
+	total     int
+	perDay    map[string]int
+	referrers map[string]int
+}
+
+// MemorySink хранит только агрегаты по ссылкам, сами события не сохраняются
+type MemorySink struct {
+	mu    sync.RWMutex
+	links map[string]*linkStats
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{links: make(map[string]*linkStats)}
+}
+
+func (sink *MemorySink) WriteClicks(ctx context.Context, events []ClickEvent) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	for _, event := range events {
+		sink.add(event)
+	}
+	return nil
+}
+
+func (sink *MemorySink) add(event ClickEvent) {
+	stats, ok := sink.links[event.ShortURL]
+	if !ok {
+		stats = &linkStats{perDay: make(map[string]int), referrers: make(map[string]int)}
+		sink.links[event.ShortURL] = stats
+	}
+	stats.total++
+	stats.perDay[event.ClickedAt.UTC().Format(dayLayout)]++
+	if event.Referrer != "" {
+		stats.referrers[event.Referrer]++
+	}
+}
+
+func (sink *MemorySink) GetStats(ctx context.Context, shortURL string) (*Stats, error) {
+	sink.mu.RLock()
+	defer sink.mu.RUnlock()
+	result := &Stats{ClicksPerDay: []DayClicks{}, TopReferrers: []ReferrerClicks{}}
+	stats, ok := sink.links[shortURL]
+	if !ok {
+		return result, nil
+	}
+	result.TotalClicks = stats.total
+	for day, clicks := range stats.perDay {
+		result.ClicksPerDay = append(result.ClicksPerDay, DayClicks{Date: day, Clicks: clicks})
+	}
+	sort.Slice(result.ClicksPerDay, func(i, j int) bool {
+		return result.ClicksPerDay[i].Date < result.ClicksPerDay[j].Date
+	})
+	for referrer, clicks := range stats.referrers {
+		result.TopReferrers = append(result.TopReferrers, ReferrerClicks{Referrer: referrer, Clicks: clicks})
+	}
+	sort.Slice(result.TopReferrers, func(i, j int) bool {
+		if result.TopReferrers[i].Clicks != result.TopReferrers[j].Clicks {
+			return result.TopReferrers[i].Clicks > result.TopReferrers[j].Clicks
+		}
+		return result.TopReferrers[i].Referrer < result.TopReferrers[j].Referrer
+	})
+	if len(result.TopReferrers) > TopReferrersLimit {
+		result.TopReferrers = result.TopReferrers[:TopReferrersLimit]
+	}
+	return result, nil
+}
+
+func (sink *MemorySink) DeleteClicks(ctx context.Context, shortURLs []string) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	for _, shortURL := range shortURLs {
+		delete(sink.links, shortURL)
+	}
+	return nil
+}
+
+func (sink *MemorySink) Close() error {
+	return nil
+}
+
+//--------------------------------------------------------------------
+
+// FileSink дописывает события в JSON-lines файл, а статистику отдаёт из агрегатов в памяти,
+// восстановленных из файла при старте
+type FileSink struct {
+	*MemorySink
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// purgeRecord — строка файла, после которой статистика кода начинается заново
+type purgeRecord struct {
+	PurgedShortURL string `json:"purged_short_url"`
+}
+
+// fileRecord читает строку файла любого вида: событие перехода или purgeRecord
+type fileRecord struct {
+	ClickEvent
+	purgeRecord
+}
+
+// NewFileSink загружает события из файла. Нечитаемые строки пропускаются, чтобы аналитика
+// не мешала старту сервиса, а недописанный хвост после сбоя обрезается: иначе следующее
+// событие дописалось бы в конец оборванной строки и тоже потерялось
+func NewFileSink(filename string) (*FileSink, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	sink := &FileSink{
+		MemorySink: NewMemorySink(),
+		file:       file,
+		encoder:    json.NewEncoder(file),
+	}
+	if err := sink.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return sink, nil
+}
+
+// load восстанавливает агрегаты из файла и обрезает его после последней целой строки
+func (sink *FileSink) load() error {
+	reader := bufio.NewReader(sink.file)
+	var offset, validEnd int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if len(line) == 0 || line[len(line)-1] != '\n' {
+			// строка без перевода строки могла остаться только от прерванной записи
+			break
+		}
+		offset += int64(len(line))
+		var record fileRecord
+		if err := json.Unmarshal(bytes.TrimSpace(line), &record); err != nil {
+			continue
+		}
+		validEnd = offset
+		if record.PurgedShortURL != "" {
+			delete(sink.links, record.PurgedShortURL)
+			continue
+		}
+		sink.add(record.ClickEvent)
+	}
+
+	info, err := sink.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == validEnd {
+		return nil
+	}
+	log.Printf("Truncating torn tail of analytics file %s: %d bytes", sink.file.Name(), info.Size()-validEnd)
+	if err := sink.file.Truncate(validEnd); err != nil {
+		return err
+	}
+	return sink.file.Sync()
+}
+
+func (sink *FileSink) WriteClicks(ctx context.Context, events []ClickEvent) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	for _, event := range events {
+		if err := sink.encoder.Encode(event); err != nil {
+			return err
+		}
+		sink.add(event)
+	}
+	return nil
+}
+
+// DeleteClicks дописывает отметки об удалении: сами события остаются в файле,
+// но при загрузке статистика кода после отметки начинается заново
+func (sink *FileSink) DeleteClicks(ctx context.Context, shortURLs []string) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	for _, shortURL := range shortURLs {
+		if err := sink.encoder.Encode(purgeRecord{PurgedShortURL: shortURL}); err != nil {
+			return err
+		}
+		delete(sink.links, shortURL)
+	}
+	return nil
+}
+
+func (sink *FileSink) Close() error {
+	return sink.file.Close()
+}
+
+//--------------------------------------------------------------------
+
+// DBSink пишет события в таблицу clicks (см. storage/migrations/0007)
+type DBSink struct {
+	DB *sql.DB
+}
+
+func NewDBSink(dsn string) (*DBSink, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &DBSink{DB: db}, nil
+}
+
+func (sink *DBSink) WriteClicks(ctx context.Context, events []ClickEvent) error {
+	query := "INSERT INTO clicks (short_url, clicked_at, referrer, ip_hash, ua_class) VALUES ($1, $2, $3, $4, $5);"
+	tx, err := sink.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, event := range events {
+		_, err := stmt.ExecContext(ctx, event.ShortURL, event.ClickedAt, event.Referrer, event.IPHash, event.UAClass)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (sink *DBSink) GetStats(ctx context.Context, shortURL string) (*Stats, error) {
+	result := &Stats{ClicksPerDay: []DayClicks{}, TopReferrers: []ReferrerClicks{}}
+	row := sink.DB.QueryRowContext(ctx, "SELECT count(*) FROM clicks WHERE short_url = $1", shortURL)
+	if err := row.Scan(&result.TotalClicks); err != nil {
+		return nil, err
+	}
+
+	rows, err := sink.DB.QueryContext(ctx, `
+	SELECT to_char(clicked_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day, count(*) FROM clicks
+	WHERE short_url = $1 GROUP BY day ORDER BY day`, shortURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day DayClicks
+		if err := rows.Scan(&day.Date, &day.Clicks); err != nil {
+			return nil, err
+		}
+		result.ClicksPerDay = append(result.ClicksPerDay, day)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	refRows, err := sink.DB.QueryContext(ctx, `
+	SELECT referrer, count(*) AS clicks FROM clicks
+	WHERE short_url = $1 AND referrer <> '' GROUP BY referrer ORDER BY clicks DESC, referrer LIMIT $2`, shortURL, TopReferrersLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer refRows.Close()
+	for refRows.Next() {
+		var referrer ReferrerClicks
+		if err := refRows.Scan(&referrer.Referrer, &referrer.Clicks); err != nil {
+			return nil, err
+		}
+		result.TopReferrers = append(result.TopReferrers, referrer)
+	}
+	return result, refRows.Err()
+}
+
+func (sink *DBSink) DeleteClicks(ctx context.Context, shortURLs []string) error {
+	if len(shortURLs) == 0 {
+		return nil
+	}
+	_, err := sink.DB.ExecContext(ctx, "DELETE FROM clicks WHERE short_url = ANY($1::varchar[])", shortURLs)
+	return err
+}
+
+func (sink *DBSink) Close() error {
+	return sink.DB.Close()
+}
@@ -1,11 +1,20 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"net"
 	"net/http"
-	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 	"github.com/go-chi/chi/v5"
+	"github.com/hessayon/ya_practicum_go/internal/analytics"
 	"github.com/hessayon/ya_practicum_go/internal/config"
+	"github.com/hessayon/ya_practicum_go/internal/deleting"
 	"github.com/hessayon/ya_practicum_go/internal/logger"
+	"github.com/hessayon/ya_practicum_go/internal/metrics"
+	"github.com/hessayon/ya_practicum_go/internal/policy"
 	"github.com/hessayon/ya_practicum_go/internal/storage"
 	"go.uber.org/zap"
 )
@@ -13,24 +22,148 @@ import (
 
 type App struct {
 	Router *chi.Mux
+	Server *http.Server
+	// RedirectServer перенаправляет HTTP-запросы на HTTPS, если это включено в конфигурации
+	RedirectServer *http.Server
+	// AdminServer отдаёт метрики на отдельном адресе, если он задан в конфигурации
+	AdminServer *http.Server
 	Storage storage.URLStorage
+	Deleter *deleting.URLDeleter
+	Recorder *analytics.Recorder
+	// Policy перечитывает файл политики в фоне, nil — политика не задана
+	Policy *policy.Engine
 	SrvcConfig *config.ServiceConfig
 	Logger *zap.Logger
 }
 
-func NewAppInstance(r *chi.Mux, s storage.URLStorage, l *zap.Logger, c *config.ServiceConfig) *App {
+func NewAppInstance(r *chi.Mux, s storage.URLStorage, d *deleting.URLDeleter, rec *analytics.Recorder, p *policy.Engine, l *zap.Logger, c *config.ServiceConfig) *App {
+	var adminServer *http.Server
+	if c.MetricsAddr != "" {
+		adminRouter := chi.NewRouter()
+		adminRouter.Get("/metrics", metrics.Handler())
+		adminServer = &http.Server{
+			Addr:    c.MetricsAddr,
+			Handler: adminRouter,
+		}
+	}
 	return &App{
 		Router: r,
+		Server: &http.Server{
+			Addr:    c.ListenAddr(),
+			Handler: r,
+		},
+		AdminServer: adminServer,
 		Storage: s,
+		Deleter: d,
+		Recorder: rec,
+		Policy: p,
 		SrvcConfig: c,
 		Logger: l,
 	}
 }
 
 
+// Run обслуживает запросы до получения SIGINT, SIGTERM или SIGQUIT,
+// после чего дожидается текущих запросов и по очереди останавливает компоненты сервиса.
 func (app *App) Run() error {
-	defer app.Storage.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+	return app.run(ctx)
+}
+
+func (app *App) run(ctx context.Context) error {
+	if app.SrvcConfig.EnableHTTPS {
+		if err := app.configureTLS(); err != nil {
+			app.closeComponents()
+			return err
+		}
+	}
+
+	listener, err := app.listen()
+	if err != nil {
+		app.closeComponents()
+		return err
+	}
 
-	logger.Log.Info("Start URL Shortener service", zap.String("host", app.SrvcConfig.Host), zap.Int("port", app.SrvcConfig.Port))
-	return http.ListenAndServe(fmt.Sprintf("%s:%d", app.SrvcConfig.Host, app.SrvcConfig.Port), app.Router)
-}
\ No newline at end of file
+	reaperCtx, stopReaper := context.WithCancel(context.Background())
+	reaperDone := app.startReaper(reaperCtx, app.SrvcConfig.ReaperInterval)
+
+	serverErr := make(chan error, 3)
+	go func() {
+		logger.Log.Info("Start URL Shortener service", zap.String("addr", listener.Addr().String()), zap.Bool("https", app.SrvcConfig.EnableHTTPS))
+		if app.SrvcConfig.EnableHTTPS {
+			// сертификат уже лежит в TLSConfig
+			serverErr <- app.Server.ServeTLS(listener, "", "")
+			return
+		}
+		serverErr <- app.Server.Serve(listener)
+	}()
+	if app.RedirectServer != nil {
+		go func() {
+			logger.Log.Info("Start HTTP to HTTPS redirect", zap.String("addr", app.RedirectServer.Addr))
+			serverErr <- app.RedirectServer.ListenAndServe()
+		}()
+	}
+	if app.AdminServer != nil {
+		go func() {
+			logger.Log.Info("Start admin server", zap.String("addr", app.AdminServer.Addr))
+			serverErr <- app.AdminServer.ListenAndServe()
+		}()
+	}
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		logger.Log.Info("Shutdown signal is received, stopping URL Shortener service")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), app.SrvcConfig.ShutdownTimeout)
+		defer cancel()
+		// новые соединения больше не принимаются, текущие запросы дообслуживаются
+		if err := app.Server.Shutdown(shutdownCtx); err != nil {
+			logger.Log.Error("Error in Server.Shutdown()", zap.String("error", err.Error()))
+			runErr = err
+			// запросы, не успевшие завершиться за таймаут, обрываются, чтобы они
+			// не обращались к хранилищу после его закрытия
+			app.Server.Close()
+		}
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			runErr = err
+		}
+		app.Server.Close()
+	}
+	if app.RedirectServer != nil {
+		app.RedirectServer.Close()
+	}
+	if app.AdminServer != nil {
+		app.AdminServer.Close()
+	}
+
+	// фоновые задачи останавливаются после HTTP-сервера, но до закрытия хранилища:
+	// удаление и аналитика должны дописать накопленные батчи
+	stopReaper()
+	<-reaperDone
+	app.closeComponents()
+	logger.Log.Info("URL Shortener service is stopped")
+	return runErr
+}
+
+// listen открывает TCP-порт или unix-сокет; файл сокета, оставшийся от прошлого запуска, удаляется.
+// Сервер сам удаляет файл сокета при закрытии слушателя
+func (app *App) listen() (net.Listener, error) {
+	if app.SrvcConfig.UnixSocket != "" {
+		if err := os.Remove(app.SrvcConfig.UnixSocket); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return net.Listen("unix", app.SrvcConfig.UnixSocket)
+	}
+	return net.Listen("tcp", app.Server.Addr)
+}
+
+func (app *App) closeComponents() {
+	app.Deleter.Close()
+	if err := app.Recorder.Close(); err != nil {
+		logger.Log.Error("Error in Recorder.Close()", zap.String("error", err.Error()))
+	}
+	app.Policy.Close()
+	app.Storage.Close()
+}
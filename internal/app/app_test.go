@@ -0,0 +1,215 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hessayon/ya_practicum_go/internal/analytics"
+	"github.com/hessayon/ya_practicum_go/internal/config"
+	"github.com/hessayon/ya_practicum_go/internal/deleting"
+	"github.com/hessayon/ya_practicum_go/internal/policy"
+	"github.com/hessayon/ya_practicum_go/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// shutdownLog запоминает, в каком порядке компоненты пишут данные и закрываются
+type shutdownLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (log *shutdownLog) add(event string) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	log.events = append(log.events, event)
+}
+
+func (log *shutdownLog) get() []string {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	return append([]string(nil), log.events...)
+}
+
+type recordingStorage struct {
+	storage.URLStorage
+	log *shutdownLog
+	// onClose вызывается перед закрытием хранилища
+	onClose func()
+}
+
+func (s *recordingStorage) DeleteURLs(ctx context.Context, tasks []storage.DeleteTask) error {
+	s.log.add("delete")
+	return s.URLStorage.DeleteURLs(ctx, tasks)
+}
+
+func (s *recordingStorage) Close() {
+	if s.onClose != nil {
+		s.onClose()
+	}
+	s.log.add("storage closed")
+	s.URLStorage.Close()
+}
+
+type recordingSink struct {
+	*analytics.MemorySink
+	log *shutdownLog
+}
+
+func (sink *recordingSink) WriteClicks(ctx context.Context, events []analytics.ClickEvent) error {
+	sink.log.add("clicks")
+	return sink.MemorySink.WriteClicks(ctx, events)
+}
+
+func (sink *recordingSink) Close() error {
+	sink.log.add("sink closed")
+	return sink.MemorySink.Close()
+}
+
+// newTestApp собирает сервис на unix-сокете. Удаление и аналитика сбрасываются только при закрытии,
+// а обработчик /slow ждёт release, чтобы запрос оставался незавершённым во время остановки
+func newTestApp(t *testing.T, log *shutdownLog, shutdownTimeout time.Duration, started chan<- struct{}, release <-chan struct{}) *App {
+	dir := t.TempDir()
+	cfg := config.NewDefaultServiceConfig()
+	cfg.UnixSocket = filepath.Join(dir, "app.sock")
+	cfg.ShutdownTimeout = shutdownTimeout
+
+	memory, err := storage.NewURLStorage("")
+	require.NoError(t, err)
+	s := &recordingStorage{URLStorage: memory, log: log}
+	d := deleting.NewURLDeleter(s, 1, 10, 100, time.Hour)
+	rec := analytics.NewRecorder(&recordingSink{MemorySink: analytics.NewMemorySink(), log: log}, []byte("secret"), 16, 100, time.Hour)
+	policyFile := filepath.Join(dir, "policy.json")
+	require.NoError(t, os.WriteFile(policyFile, []byte("{}"), 0o600))
+	p, err := policy.Load(policyFile, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-release:
+			w.Write([]byte("ok"))
+		case <-r.Context().Done():
+		}
+	})
+	return NewAppInstance(r, s, d, rec, p, zap.NewNop(), cfg)
+}
+
+func unixClient(socket string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}}
+}
+
+// startSlowRequest запускает сервис и запрос к /slow и ждёт, пока обработчик начнёт работу
+func startSlowRequest(t *testing.T, app *App, ctx context.Context, started <-chan struct{}) (<-chan error, <-chan *http.Response) {
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- app.run(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("unix", app.SrvcConfig.UnixSocket)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		res, err := unixClient(app.SrvcConfig.UnixSocket).Get("http://shortener/slow")
+		if err != nil {
+			res = nil
+		}
+		responses <- res
+	}()
+	<-started
+	return runErr, responses
+}
+
+func TestAppShutdownFlushesBeforeStorageClose(t *testing.T) {
+	log := &shutdownLog{}
+	started, release := make(chan struct{}), make(chan struct{})
+	app := newTestApp(t, log, 5*time.Second, started, release)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr, responses := startSlowRequest(t, app, ctx, started)
+
+	// пока запрос обслуживается, в очереди есть удаление и переход
+	require.NoError(t, app.Deleter.Delete("u1", []string{"a1"}))
+	app.Recorder.Record(analytics.ClickEvent{ShortURL: "a1", ClickedAt: time.Now()})
+	cancel()
+
+	// новые соединения уже не принимаются, а компоненты ждут текущий запрос
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("unix", app.SrvcConfig.UnixSocket)
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(t, log.get())
+
+	// политика к закрытию хранилища уже не перечитывает файл
+	var policyErr error
+	app.Storage.(*recordingStorage).onClose = func() {
+		policyFile := filepath.Join(filepath.Dir(app.SrvcConfig.UnixSocket), "policy.json")
+		policyErr = os.WriteFile(policyFile, []byte(`{"deny": ["blocked.example"]}`), 0o600)
+		time.Sleep(100 * time.Millisecond)
+		if policyErr == nil {
+			policyErr = app.Policy.Check("https://blocked.example/")
+		}
+	}
+	close(release)
+	res := <-responses
+	require.NotNil(t, res)
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "ok", string(body))
+	require.NoError(t, <-runErr)
+	assert.NoError(t, policyErr)
+
+	// удаление и аналитика дописаны до закрытия хранилища
+	assert.Equal(t, []string{"delete", "clicks", "sink closed", "storage closed"}, log.get())
+}
+
+func TestAppShutdownTimeout(t *testing.T) {
+	log := &shutdownLog{}
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	const shutdownTimeout = 100 * time.Millisecond
+	app := newTestApp(t, log, shutdownTimeout, started, release)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr, responses := startSlowRequest(t, app, ctx, started)
+
+	cancel()
+	stoppedAt := time.Now()
+	// запрос не завершается сам, поэтому остановка обрывает его по таймауту
+	select {
+	case err := <-runErr:
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(5 * time.Second):
+		t.Fatal("run() did not stop after ShutdownTimeout")
+	}
+	elapsed := time.Since(stoppedAt)
+	assert.GreaterOrEqual(t, elapsed, shutdownTimeout)
+	assert.Less(t, elapsed, 2*time.Second)
+	assert.Nil(t, <-responses)
+	assert.Equal(t, []string{"sink closed", "storage closed"}, log.get())
+}
@@ -0,0 +1,41 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// startReaper периодически превращает истёкшие ссылки в надгробия и удаляет надгробия
+// старше ExpiredRetention вместе со статистикой их переходов, ведь их коды снова свободны.
+// Возвращаемый канал закрывается, когда горутина завершилась после отмены ctx.
+func (app *App) startReaper(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				purged, freed, err := app.Storage.PurgeExpired(ctx, app.SrvcConfig.ExpiredRetention)
+				if len(freed) > 0 {
+					if err := app.Recorder.DeleteClicks(ctx, freed); err != nil {
+						app.Logger.Error("Error in Recorder.DeleteClicks()", zap.Int("count", len(freed)), zap.String("error", err.Error()))
+					}
+				}
+				if err != nil {
+					app.Logger.Error("Error in PurgeExpired()", zap.String("error", err.Error()))
+					continue
+				}
+				if purged > 0 {
+					app.Logger.Info("expired urls are purged", zap.Int("count", purged))
+				}
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,45 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/hessayon/ya_practicum_go/internal/analytics"
+	"github.com/hessayon/ya_practicum_go/internal/config"
+	"github.com/hessayon/ya_practicum_go/internal/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestReaperDeletesClicksOfFreedCodes(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	s := mocks.NewMockURLStorage(ctrl)
+	gomock.InOrder(
+		s.EXPECT().PurgeExpired(gomock.Any(), time.Hour).Return(1, []string{"a1"}, nil),
+		s.EXPECT().PurgeExpired(gomock.Any(), time.Hour).Return(0, nil, nil).AnyTimes(),
+	)
+	sink := analytics.NewMemorySink()
+	require.NoError(t, sink.WriteClicks(ctx, []analytics.ClickEvent{{ShortURL: "a1", ClickedAt: time.Now()}, {ShortURL: "b2", ClickedAt: time.Now()}}))
+	cfg := config.NewDefaultServiceConfig()
+	cfg.ExpiredRetention = time.Hour
+	app := &App{Storage: s, Recorder: analytics.NewRecorder(sink, []byte("secret"), 16, 1, time.Second), SrvcConfig: cfg, Logger: zap.NewNop()}
+	defer app.Recorder.Close()
+
+	reaperCtx, stop := context.WithCancel(ctx)
+	done := app.startReaper(reaperCtx, 10*time.Millisecond)
+	// освобождённый код уходит вместе со статистикой, статистика других ссылок остаётся
+	assert.Eventually(t, func() bool {
+		stats, err := app.Recorder.GetStats(ctx, "a1")
+		return err == nil && stats.TotalClicks == 0
+	}, time.Second, 10*time.Millisecond)
+	stop()
+	<-done
+	stats, err := app.Recorder.GetStats(ctx, "b2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.TotalClicks)
+}
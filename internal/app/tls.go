@@ -0,0 +1,52 @@
+package app
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/hessayon/ya_practicum_go/internal/certs"
+)
+
+// configureTLS готовит сервер к HTTPS: сертификат из файлов или самоподписанный,
+// HTTP/2 по настройке и, если задан адрес, сервер перенаправления с HTTP на HTTPS
+func (app *App) configureTLS() error {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if app.SrvcConfig.Host != "" {
+		hosts = append(hosts, app.SrvcConfig.Host)
+	}
+	if baseURL, err := url.Parse(app.SrvcConfig.BaseAddr); err == nil && baseURL.Hostname() != "" {
+		hosts = append(hosts, baseURL.Hostname())
+	}
+	tlsConfig, err := certs.NewTLSConfig(app.SrvcConfig.TLSCertFile, app.SrvcConfig.TLSKeyFile, hosts)
+	if err != nil {
+		return err
+	}
+	app.Server.TLSConfig = tlsConfig
+	if !app.SrvcConfig.EnableHTTP2 {
+		// непустая карта TLSNextProto отключает автоматическую настройку HTTP/2
+		app.Server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	if app.SrvcConfig.HTTPRedirectAddr != "" {
+		app.RedirectServer = &http.Server{
+			Addr:    app.SrvcConfig.HTTPRedirectAddr,
+			Handler: http.HandlerFunc(app.redirectToHTTPS),
+		}
+	}
+	return nil
+}
+
+func (app *App) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+	// за unix-сокетом стоит прокси, внешний порт нам неизвестен
+	if app.SrvcConfig.UnixSocket == "" && app.SrvcConfig.Port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(app.SrvcConfig.Port))
+	}
+	target := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+	http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+type contextKey struct{}
+
+// CookieName — имя куки, в которой хранится подписанный ID пользователя
+const CookieName = "user_id"
+
+var ErrInvalidToken = errors.New("invalid auth token")
+
+func NewUserID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func sign(userID string, key []byte) string {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(userID))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// BuildToken возвращает значение куки вида <userID>.<hmac-sha256(userID)>
+func BuildToken(userID string, key []byte) string {
+	return userID + "." + sign(userID, key)
+}
+
+// ParseToken проверяет подпись и возвращает ID пользователя
+func ParseToken(token string, key []byte) (string, error) {
+	userID, signature, found := strings.Cut(token, ".")
+	if !found || userID == "" {
+		return "", ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(signature), []byte(sign(userID, key))) {
+		return "", ErrInvalidToken
+	}
+	return userID, nil
+}
+
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, userID)
+}
+
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(contextKey{}).(string)
+	return userID, ok && userID != ""
+}
@@ -0,0 +1,28 @@
+package certs
+
+import (
+	"crypto/x509"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateSelfSigned(t *testing.T) {
+	cert, err := GenerateSelfSigned([]string{"localhost", "127.0.0.1", ""})
+	require.NoError(t, err)
+	require.Len(t, cert.Certificate, 1)
+
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"localhost"}, parsed.DNSNames)
+	require.Len(t, parsed.IPAddresses, 1)
+	assert.True(t, parsed.IPAddresses[0].Equal(net.ParseIP("127.0.0.1")))
+	assert.NoError(t, parsed.VerifyHostname("localhost"))
+}
+
+func TestNewTLSConfigWithMissingFiles(t *testing.T) {
+	_, err := NewTLSConfig("missing.crt", "missing.key", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,61 @@
+package codegen
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	MinAliasLength = 3
+	MaxAliasLength = 64
+	aliasCharset   = charset + "-_"
+)
+
+var ErrInvalidAlias = errors.New("invalid alias")
+
+// reservedAliases совпадают с путями сервиса и не могут быть кодами ссылок. Роутер дополняет
+// список своими путями через ReserveAliases; здесь перечислены пути, которые могут быть
+// выключены настройками, чтобы коды не занимались, пока путь отключён
+var (
+	reservedMu      sync.RWMutex
+	reservedAliases = map[string]bool{
+		"ping":    true,
+		"api":     true,
+		"healthz": true,
+		"readyz":  true,
+		"metrics": true,
+	}
+)
+
+// ReserveAliases запрещает использовать коды, совпадающие с первыми сегментами путей сервиса
+func ReserveAliases(aliases ...string) {
+	reservedMu.Lock()
+	defer reservedMu.Unlock()
+	for _, alias := range aliases {
+		reservedAliases[strings.ToLower(alias)] = true
+	}
+}
+
+func isReserved(alias string) bool {
+	reservedMu.RLock()
+	defer reservedMu.RUnlock()
+	return reservedAliases[strings.ToLower(alias)]
+}
+
+// ValidateAlias проверяет пользовательский код ссылки; ошибка содержит причину отказа
+func ValidateAlias(alias string) error {
+	if len(alias) < MinAliasLength || len(alias) > MaxAliasLength {
+		return fmt.Errorf("%w: length must be from %d to %d characters", ErrInvalidAlias, MinAliasLength, MaxAliasLength)
+	}
+	for _, c := range alias {
+		if !strings.ContainsRune(aliasCharset, c) {
+			return fmt.Errorf("%w: character %q is not allowed", ErrInvalidAlias, c)
+		}
+	}
+	if isReserved(alias) {
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidAlias, alias)
+	}
+	return nil
+}
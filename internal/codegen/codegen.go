@@ -0,0 +1,243 @@
+package codegen
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/hessayon/ya_practicum_go/internal/storage"
+)
+
+const (
+	charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	// MaxAttempts — сколько раз генератор пробует получить свободный код
+	MaxAttempts = 10
+	// MaxLength ограничивает длину кода: хэш-генератору хватает энтропии sha256
+	MaxLength = 32
+	// sequentialPrefixLength — длина префикса экземпляра в последовательных кодах
+	sequentialPrefixLength = 2
+	// MinSequentialLength — префикс экземпляра и хотя бы 62^4 (около 14 млн) значений счётчика
+	MinSequentialLength = sequentialPrefixLength + 4
+
+	KindRandom     = "random"
+	KindSequential = "sequential"
+	KindHash       = "hash"
+)
+
+var ErrNoFreeCode = errors.New("no free short code found")
+
+// Generator выдаёт код сокращённой ссылки, ещё не занятый в хранилище
+type Generator interface {
+	Generate(ctx context.Context, originalURL string) (string, error)
+}
+
+func New(kind string, length int, s storage.URLStorage) (Generator, error) {
+	if length <= 0 || length > MaxLength {
+		return nil, fmt.Errorf("wrong short code length %d", length)
+	}
+	switch kind {
+	case KindRandom:
+		return NewRandomGenerator(length, s), nil
+	case KindSequential:
+		if length < MinSequentialLength {
+			return nil, fmt.Errorf("sequential short codes need at least %d characters, got %d", MinSequentialLength, length)
+		}
+		// случайные префикс и начало счётчика разводят реплики и перезапуски по разным
+		// диапазонам кодов, так что они не перебирают уже выданные коды друг друга
+		prefix, err := randomCode(sequentialPrefixLength)
+		if err != nil {
+			return nil, err
+		}
+		start, err := rand.Int(rand.Reader, new(big.Int).SetUint64(sequentialRange(length-sequentialPrefixLength)))
+		if err != nil {
+			return nil, err
+		}
+		return NewSequentialGenerator(prefix, start.Uint64(), length, s), nil
+	case KindHash:
+		return NewHashGenerator(length, s), nil
+	default:
+		return nil, fmt.Errorf("unknown short code generator %q", kind)
+	}
+}
+
+// randomCode возвращает криптографически случайную строку из charset
+func randomCode(length int) (string, error) {
+	max := big.NewInt(int64(len(charset)))
+	code := make([]byte, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = charset[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// encodeBase62 кодирует число в base62 и дополняет результат слева до length символов;
+// длинные числа не обрезаются
+func encodeBase62(n uint64, length int) string {
+	code := make([]byte, 0, length)
+	for n > 0 {
+		code = append(code, charset[n%uint64(len(charset))])
+		n /= uint64(len(charset))
+	}
+	for len(code) < length {
+		code = append(code, charset[0])
+	}
+	for i, j := 0, len(code)-1; i < j; i, j = i+1, j-1 {
+		code[i], code[j] = code[j], code[i]
+	}
+	return string(code)
+}
+
+// isFree проверяет, что код ещё не занят; удалённые и истёкшие ссылки код не освобождают,
+// а зарезервированные пути сервиса заняты всегда
+func isFree(ctx context.Context, s storage.URLStorage, code string) (bool, error) {
+	if isReserved(code) {
+		return false, nil
+	}
+	_, err := s.GetOriginalURL(ctx, code)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return true, nil
+	case err == nil, errors.Is(err, storage.ErrDeleted), errors.Is(err, storage.ErrExpired):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+//--------------------------------------------------------------------
+
+// RandomGenerator выдаёт криптографически случайные коды фиксированной длины
+type RandomGenerator struct {
+	length  int
+	storage storage.URLStorage
+}
+
+func NewRandomGenerator(length int, s storage.URLStorage) *RandomGenerator {
+	return &RandomGenerator{length: length, storage: s}
+}
+
+func (g *RandomGenerator) Generate(ctx context.Context, _ string) (string, error) {
+	for attempt := 0; attempt < MaxAttempts; attempt++ {
+		code, err := randomCode(g.length)
+		if err != nil {
+			return "", err
+		}
+		free, err := isFree(ctx, g.storage, code)
+		if err != nil {
+			return "", err
+		}
+		if free {
+			return code, nil
+		}
+	}
+	return "", ErrNoFreeCode
+}
+
+//--------------------------------------------------------------------
+
+// SequentialGenerator выдаёт коды вида <префикс><счётчик в base62>. Счётчик растёт монотонно
+// и по модулю помещается в оставшиеся символы, поэтому код всегда ровно нужной длины.
+// Занятые значения (например, после перезапуска) пропускаются.
+type SequentialGenerator struct {
+	counter atomic.Uint64
+	prefix  string
+	length  int
+	// counterRange — число значений счётчика, помещающихся в код после префикса
+	counterRange uint64
+	storage      storage.URLStorage
+}
+
+// sequentialRange — сколько чисел записывается в base62 не длиннее length символов
+func sequentialRange(length int) uint64 {
+	n := uint64(1)
+	for i := 0; i < length; i++ {
+		if n > math.MaxUint64/uint64(len(charset)) {
+			return math.MaxUint64
+		}
+		n *= uint64(len(charset))
+	}
+	return n
+}
+
+func NewSequentialGenerator(prefix string, start uint64, length int, s storage.URLStorage) *SequentialGenerator {
+	g := &SequentialGenerator{prefix: prefix, length: length, counterRange: sequentialRange(length - len(prefix)), storage: s}
+	g.counter.Store(start)
+	return g
+}
+
+func (g *SequentialGenerator) Generate(ctx context.Context, _ string) (string, error) {
+	for attempt := 0; attempt < MaxAttempts; attempt++ {
+		n := g.counter.Add(1) % g.counterRange
+		code := g.prefix + encodeBase62(n, g.length-len(g.prefix))
+		free, err := isFree(ctx, g.storage, code)
+		if err != nil {
+			return "", err
+		}
+		if free {
+			return code, nil
+		}
+	}
+	return "", ErrNoFreeCode
+}
+
+//--------------------------------------------------------------------
+
+// HashGenerator выводит код из sha256 оригинального URL, поэтому один и тот же URL
+// всегда получает один и тот же код. При коллизии к URL добавляется номер попытки.
+type HashGenerator struct {
+	length  int
+	storage storage.URLStorage
+}
+
+func NewHashGenerator(length int, s storage.URLStorage) *HashGenerator {
+	return &HashGenerator{length: length, storage: s}
+}
+
+func (g *HashGenerator) code(originalURL string, attempt int) string {
+	input := originalURL
+	if attempt > 0 {
+		input += "#" + strconv.Itoa(attempt)
+	}
+	sum := sha256.Sum256([]byte(input))
+	n := new(big.Int).SetBytes(sum[:])
+	base := big.NewInt(int64(len(charset)))
+	mod := new(big.Int)
+	code := make([]byte, g.length)
+	for i := range code {
+		n.DivMod(n, base, mod)
+		code[i] = charset[mod.Int64()]
+	}
+	return string(code)
+}
+
+func (g *HashGenerator) Generate(ctx context.Context, originalURL string) (string, error) {
+	for attempt := 0; attempt < MaxAttempts; attempt++ {
+		code := g.code(originalURL, attempt)
+		if isReserved(code) {
+			continue
+		}
+		storedURL, err := g.storage.GetOriginalURL(ctx, code)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			return code, nil
+		case err == nil && storedURL == originalURL:
+			// код уже принадлежит этому URL, конфликт обработает хранилище
+			return code, nil
+		case err == nil, errors.Is(err, storage.ErrDeleted), errors.Is(err, storage.ErrExpired):
+			continue
+		default:
+			return "", err
+		}
+	}
+	return "", ErrNoFreeCode
+}
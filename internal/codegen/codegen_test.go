@@ -0,0 +1,167 @@
+package codegen
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/hessayon/ya_practicum_go/internal/mocks"
+	"github.com/hessayon/ya_practicum_go/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeBase62(t *testing.T) {
+	tests := []struct {
+		name   string
+		n      uint64
+		length int
+		want   string
+	}{
+		{name: "zero is padded", n: 0, length: 4, want: "aaaa"},
+		{name: "single digit", n: 61, length: 1, want: "9"},
+		{name: "two digits", n: 62, length: 2, want: "ba"},
+		{name: "longer than length", n: 62 * 62, length: 2, want: "baa"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, encodeBase62(test.n, test.length))
+		})
+	}
+}
+
+func TestRandomGeneratorRetriesOnCollision(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockURLStorage(ctrl)
+	gomock.InOrder(
+		m.EXPECT().GetOriginalURL(gomock.Any(), gomock.Any()).Return("https://practicum.yandex.ru/", nil),
+		m.EXPECT().GetOriginalURL(gomock.Any(), gomock.Any()).Return("", storage.ErrDeleted),
+		m.EXPECT().GetOriginalURL(gomock.Any(), gomock.Any()).Return("", storage.ErrNotFound),
+	)
+	code, err := NewRandomGenerator(8, m).Generate(context.Background(), "https://ya.ru/")
+	require.NoError(t, err)
+	assert.Len(t, code, 8)
+}
+
+func TestRandomGeneratorStorageError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockURLStorage(ctrl)
+	storageErr := errors.New("connection refused")
+	m.EXPECT().GetOriginalURL(gomock.Any(), gomock.Any()).Return("", storageErr)
+	_, err := NewRandomGenerator(8, m).Generate(context.Background(), "https://ya.ru/")
+	assert.ErrorIs(t, err, storageErr)
+}
+
+func TestRandomGeneratorNoFreeCode(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockURLStorage(ctrl)
+	m.EXPECT().GetOriginalURL(gomock.Any(), gomock.Any()).Return("https://practicum.yandex.ru/", nil).Times(MaxAttempts)
+	_, err := NewRandomGenerator(8, m).Generate(context.Background(), "https://ya.ru/")
+	assert.ErrorIs(t, err, ErrNoFreeCode)
+}
+
+func TestSequentialGeneratorSkipsTakenCodes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockURLStorage(ctrl)
+	m.EXPECT().GetOriginalURL(gomock.Any(), "Xaaab").Return("https://practicum.yandex.ru/", nil)
+	m.EXPECT().GetOriginalURL(gomock.Any(), "Xaaac").Return("", storage.ErrNotFound)
+	m.EXPECT().GetOriginalURL(gomock.Any(), "Xaaad").Return("", storage.ErrNotFound)
+	g := NewSequentialGenerator("X", 0, 5, m)
+	code, err := g.Generate(context.Background(), "https://ya.ru/")
+	require.NoError(t, err)
+	assert.Equal(t, "Xaaac", code)
+	code, err = g.Generate(context.Background(), "https://ya.ru/")
+	require.NoError(t, err)
+	assert.Equal(t, "Xaaad", code)
+}
+
+func TestSequentialGeneratorKeepsLength(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockURLStorage(ctrl)
+	m.EXPECT().GetOriginalURL(gomock.Any(), gomock.Any()).Return("", storage.ErrNotFound).AnyTimes()
+
+	// счётчик у верхней границы диапазона переходит через ноль, не удлиняя код
+	g := NewSequentialGenerator("XY", sequentialRange(MinSequentialLength-2)-1, MinSequentialLength, m)
+	code, err := g.Generate(context.Background(), "https://ya.ru/")
+	require.NoError(t, err)
+	assert.Equal(t, "XYaaaa", code)
+
+	// при запуске счётчик берёт случайное начало, а не время, и код не длиннее заданного
+	generator, err := New(KindSequential, MinSequentialLength, m)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		code, err := generator.Generate(context.Background(), "https://ya.ru/")
+		require.NoError(t, err)
+		assert.Len(t, code, MinSequentialLength)
+	}
+	_, err = New(KindSequential, MinSequentialLength-1, m)
+	assert.Error(t, err)
+}
+
+func TestHashGenerator(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockURLStorage(ctrl)
+	m.EXPECT().GetOriginalURL(gomock.Any(), gomock.Any()).Return("", storage.ErrNotFound).Times(2)
+	g := NewHashGenerator(8, m)
+	first, err := g.Generate(context.Background(), "https://ya.ru/")
+	require.NoError(t, err)
+	second, err := g.Generate(context.Background(), "https://ya.ru/")
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.Len(t, first, 8)
+
+	// код занят другим URL — берётся следующий вариант хэша
+	m.EXPECT().GetOriginalURL(gomock.Any(), first).Return("https://practicum.yandex.ru/", nil)
+	m.EXPECT().GetOriginalURL(gomock.Any(), gomock.Not(first)).Return("", storage.ErrNotFound)
+	third, err := g.Generate(context.Background(), "https://ya.ru/")
+	require.NoError(t, err)
+	assert.NotEqual(t, first, third)
+}
+
+func TestNew(t *testing.T) {
+	for _, kind := range []string{KindRandom, KindSequential, KindHash} {
+		_, err := New(kind, 8, nil)
+		assert.NoError(t, err, kind)
+	}
+	_, err := New("uuid", 8, nil)
+	assert.Error(t, err)
+	_, err = New(KindRandom, 0, nil)
+	assert.Error(t, err)
+}
+
+func TestValidateAlias(t *testing.T) {
+	tests := []struct {
+		alias   string
+		wantErr bool
+	}{
+		{alias: "spring-sale", wantErr: false},
+		{alias: "Spring_Sale_2024", wantErr: false},
+		{alias: "ab", wantErr: true},
+		{alias: strings.Repeat("a", MaxAliasLength+1), wantErr: true},
+		{alias: "spring sale", wantErr: true},
+		{alias: "весна", wantErr: true},
+		{alias: "ping", wantErr: true},
+		{alias: "API", wantErr: true},
+		{alias: "healthz", wantErr: true},
+		{alias: "readyz", wantErr: true},
+		{alias: "metrics", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.alias, func(t *testing.T) {
+			err := ValidateAlias(test.alias)
+			if test.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidAlias)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -11,18 +11,21 @@ import (
 // сжимать передаваемые данные и выставлять правильные HTTP-заголовки
 type compressWriter struct {
 	w  http.ResponseWriter
-	zw *gzip.Writer
+	// zw создаётся, только если ответ решено сжимать
+	zw          *gzip.Writer
+	wroteHeader bool
 }
 
-
+// IsGzipContentType сравнивает только тип, параметры вроде charset не учитываются
 func IsGzipContentType(contentType string) bool{
-	return contentType == "application/json" || contentType == "text/html"
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return mediaType == "application/json" || mediaType == "text/html"
 }
 
 func NewCompressWriter(w http.ResponseWriter) *compressWriter {
 	return &compressWriter{
-		w:  w,
-		zw: gzip.NewWriter(w),
+		w: w,
 	}
 }
 
@@ -31,21 +34,34 @@ func (compressWr *compressWriter) Header() http.Header {
 }
 
 func (compressWr *compressWriter) Write(p []byte) (int, error) {
-	if IsGzipContentType(compressWr.Header().Get("Content-Type")) {
+	if !compressWr.wroteHeader {
+		compressWr.WriteHeader(http.StatusOK)
+	}
+	if compressWr.zw != nil {
 		return compressWr.zw.Write(p)
 	}
 	return compressWr.w.Write(p)
 }
 
+// WriteHeader решает, сжимать ли ответ, по его Content-Type: к этому моменту он уже выставлен
 func (compressWr *compressWriter) WriteHeader(statusCode int) {
+	if compressWr.wroteHeader {
+		return
+	}
+	compressWr.wroteHeader = true
 	if statusCode < 400  && IsGzipContentType(compressWr.Header().Get("Content-Type")){
 		compressWr.w.Header().Set("Content-Encoding", "gzip")
+		compressWr.w.Header().Del("Content-Length")
+		compressWr.zw = gzip.NewWriter(compressWr.w)
 	}
 	compressWr.w.WriteHeader(statusCode)
 }
 
 
 func (compressWr *compressWriter) Close() error {
+	if compressWr.zw == nil {
+		return nil
+	}
 	return compressWr.zw.Close()
 }
 
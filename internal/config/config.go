@@ -1,71 +1,412 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/hessayon/ya_practicum_go/internal/codegen"
+	"github.com/hessayon/ya_practicum_go/internal/ratelimit"
 )
 
 type ServiceConfig struct {
-	Host     string
-	Port     int
+	// ServerAddress — адрес из настроек: host:port, [ipv6]:port или unix:/path.sock.
+	// Host и Port либо UnixSocket вычисляются из него при проверке
+	ServerAddress string
+	Host          string
+	Port          int
+	UnixSocket    string
+	// BaseAddr — абсолютный URL, к которому дописываются короткие коды; хранится без завершающего слеша
 	BaseAddr string
+	// Storage — тип хранилища ссылок: memory, file, kv или postgres; пустой — выбор по DBDsn и Filename
+	Storage  string
 	Filename string
-	DBDsn    string
+	// FileSync — политика fsync журнала файлового хранилища: always, interval или never
+	FileSync         string
+	FileSyncInterval time.Duration
+	// CompactInterval — период сжатия журнала файлового хранилища; 0 отключает сжатие
+	CompactInterval time.Duration
+	DBDsn           string
+	// DBBatchChunkSize — сколько строк батча вставляется в Postgres одним запросом; 1 — по одной
+	DBBatchChunkSize int
+	// BatchMaxSize — наибольшее число ссылок в одном запросе на батч; 0 — без ограничения
+	BatchMaxSize int
+	// ExpiredRetention — сколько хранятся надгробия истёкших ссылок, прежде чем их коды освободятся
+	ExpiredRetention time.Duration
+	// DeleteQueueSize — сколько запросов на удаление может ждать записи в хранилище
+	DeleteQueueSize int
+	SecretKey    string
+	// CodeGenerator — способ получения кода ссылки: random, sequential или hash
+	CodeGenerator string
+	CodeLength    int
+	// ReaperInterval — период удаления ссылок с истёкшим сроком жизни
+	ReaperInterval time.Duration
+	// AnalyticsFilename — файл событий переходов; при хранилище в Postgres события пишутся в его БД,
+	// иначе без файла статистика живёт в памяти
+	AnalyticsFilename string
+	// ShutdownTimeout — сколько ждать завершения текущих запросов при остановке
+	ShutdownTimeout time.Duration
+	// EnableHTTPS включает TLS; без TLSCertFile/TLSKeyFile выпускается самоподписанный сертификат
+	EnableHTTPS      bool
+	TLSCertFile      string
+	TLSKeyFile       string
+	EnableHTTP2      bool
+	HTTPRedirectAddr string
+	// CacheSize — число ссылок в LRU-кэше перед хранилищем; 0 отключает кэш
+	CacheSize int
+	CacheTTL  time.Duration
+	// MetricsAddr — отдельный адрес для GET /metrics; пустой — метрики отдаются на основном адресе
+	MetricsAddr string
+	// RateLimitCreate и RateLimitBatch — лимиты вида "60/1m" на создание ссылок по одной и батчем;
+	// пустые — без ограничения. CreateLimit и BatchLimit вычисляются из них при проверке
+	RateLimitCreate string
+	RateLimitBatch  string
+	CreateLimit     ratelimit.Limit
+	BatchLimit      ratelimit.Limit
+	// RateLimitQR — лимит на отрисовку QR-кодов, она заметно дороже редиректа
+	RateLimitQR string
+	QRLimit     ratelimit.Limit
+	// RateLimitBy — по чему считаются лимиты: ip или user. Куку пользователя сервер выдаёт
+	// любому клиенту, и, перебирая набранные куки, лимит по пользователю можно обойти,
+	// поэтому при user лимит по IP действует тоже, а лимит пользователя лишь сужает его
+	RateLimitBy string
+	// TrustedProxies — адреса и подсети через запятую, которым доверяется X-Forwarded-For
+	TrustedProxies       string
+	TrustedProxyPrefixes []netip.Prefix
+	// PolicyFile — файл со списками разрешённых и запрещённых доменов; пустой — ограничений нет.
+	// Файл перечитывается раз в PolicyReloadInterval, если изменился; 0 — не перечитывается
+	PolicyFile           string
+	PolicyReloadInterval time.Duration
 }
 
 var Config *ServiceConfig
 
+const unixSocketPrefix = "unix:"
+
+// NewServiceConfig собирает конфигурацию из аргументов командной строки и окружения процесса
 func NewServiceConfig() (*ServiceConfig, error) {
+	return Load(os.Args[1:], os.LookupEnv)
+}
 
-	var serviceAddr, baseAddr, filename, dbDSN string
-	flag.StringVar(&serviceAddr, "a", ":8080", "address and port to run server")
-	flag.StringVar(&baseAddr, "b", "http://localhost:8080", "base address of result shortened URL")
-	flag.StringVar(&filename, "f", "", "filename of url storage")
-	flag.StringVar(&dbDSN, "d", "", "database connection string")
-	flag.Parse()
-	if envServiceAddr := os.Getenv("SERVER_ADDRESS"); envServiceAddr != "" {
-		serviceAddr = envServiceAddr
-	}
-	splittedAddr := strings.Split(serviceAddr, ":")
-	if len(splittedAddr) != 2 {
-		return nil, errors.New("wrong format for flag -a")
-	}
-	host := splittedAddr[0]
-	port, err := strconv.Atoi(splittedAddr[1])
-	if err != nil {
+// Load собирает конфигурацию с приоритетом: флаги > переменные окружения > файл конфигурации > значения по умолчанию.
+// Путь к файлу (JSON или YAML) задаётся флагом -c/-config или переменной CONFIG.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*ServiceConfig, error) {
+	fs := flag.NewFlagSet("shortener", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var configFile string
+	fs.StringVar(&configFile, "c", "", "path to JSON or YAML config file")
+	fs.StringVar(&configFile, "config", "", "path to JSON or YAML config file")
+	// значения флагов применяются последними, поэтому при разборе только запоминаются
+	flagValues := make(map[*setting]string)
+	for _, s := range settings {
+		s := s
+		record := func(value string) error {
+			flagValues[s] = value
+			return nil
+		}
+		if s.isBool {
+			fs.BoolFunc(s.flag, s.usage, record)
+		} else {
+			fs.Func(s.flag, s.usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
 		return nil, err
 	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	// ошибки всех источников собираются вместе, чтобы при запуске увидеть их сразу
+	var errs []error
+	cfg := defaultServiceConfig()
+	if configFile == "" {
+		configFile, _ = lookupEnv("CONFIG")
+	}
+	if configFile != "" {
+		errs = append(errs, applyFile(cfg, configFile))
+	}
+	for _, s := range settings {
+		if value, ok := lookupEnv(s.env); ok && value != "" {
+			errs = append(errs, s.apply(cfg, value, "env "+s.env))
+		}
+	}
+	for _, s := range settings {
+		if value, ok := flagValues[s]; ok {
+			errs = append(errs, s.apply(cfg, value, "flag -"+s.flag))
+		}
+	}
+	errs = append(errs, cfg.validate())
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	if cfg.SecretKey == "" {
+		// ключ не задан — генерируем случайный, куки будут валидны до перезапуска сервиса
+		key, err := newRandomKey()
+		if err != nil {
+			return nil, err
+		}
+		cfg.SecretKey = key
+	}
+	return cfg, nil
+}
+
+// validate проверяет итоговую конфигурацию и нормализует адреса.
+// Возвращает все найденные ошибки сразу, каждая называет ключ настройки
+func (cfg *ServiceConfig) validate() error {
+	var errs []error
+	if err := cfg.parseServerAddress(); err != nil {
+		errs = append(errs, err)
+	}
+	if baseAddr, err := normalizeBaseAddr(cfg.BaseAddr); err != nil {
+		errs = append(errs, err)
+	} else {
+		cfg.BaseAddr = baseAddr
+	}
+	switch cfg.Storage {
+	case "", "memory":
+	case "file", "kv":
+		if cfg.Filename == "" {
+			errs = append(errs, fmt.Errorf("file_storage_path: must be set for %s storage", cfg.Storage))
+		}
+	case "postgres":
+		if cfg.DBDsn == "" {
+			errs = append(errs, errors.New("database_dsn: must be set for postgres storage"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage: unknown storage %q, expected memory, file, kv or postgres", cfg.Storage))
+	}
+	switch cfg.FileSync {
+	case "always", "never":
+	case "interval":
+		if cfg.FileSyncInterval <= 0 {
+			errs = append(errs, fmt.Errorf("file_sync_interval: must be positive, got %s", cfg.FileSyncInterval))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("file_sync: unknown policy %q, expected always, interval or never", cfg.FileSync))
+	}
+	if cfg.CompactInterval < 0 {
+		errs = append(errs, fmt.Errorf("compact_interval: must not be negative, got %s", cfg.CompactInterval))
+	}
+	switch cfg.CodeGenerator {
+	case "random", "sequential", "hash":
+	default:
+		errs = append(errs, fmt.Errorf("code_generator: unknown generator %q, expected random, sequential or hash", cfg.CodeGenerator))
+	}
+	if cfg.CodeLength <= 0 {
+		errs = append(errs, fmt.Errorf("code_length: must be positive, got %d", cfg.CodeLength))
+	} else if cfg.CodeGenerator == "sequential" && cfg.CodeLength < codegen.MinSequentialLength {
+		errs = append(errs, fmt.Errorf("code_length: must be at least %d for sequential generator, got %d", codegen.MinSequentialLength, cfg.CodeLength))
+	}
+	if cfg.ReaperInterval <= 0 {
+		errs = append(errs, fmt.Errorf("reaper_interval: must be positive, got %s", cfg.ReaperInterval))
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("shutdown_timeout: must be positive, got %s", cfg.ShutdownTimeout))
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tls_cert_file, tls_key_file: certificate and key files must be set together"))
+	}
+	if cfg.HTTPRedirectAddr != "" {
+		if _, _, err := splitHostPort(cfg.HTTPRedirectAddr); err != nil {
+			errs = append(errs, fmt.Errorf("http_redirect_address: %w", err))
+		}
+	}
+	// у Postgres не больше 65535 параметров на запрос, на строку батча их уходит 4
+	if cfg.DBBatchChunkSize < 1 || cfg.DBBatchChunkSize > 65535/4 {
+		errs = append(errs, fmt.Errorf("db_batch_chunk_size: must be from 1 to %d, got %d", 65535/4, cfg.DBBatchChunkSize))
+	}
+	if cfg.BatchMaxSize < 0 {
+		errs = append(errs, fmt.Errorf("batch_max_size: must not be negative, got %d", cfg.BatchMaxSize))
+	}
+	if cfg.ExpiredRetention <= 0 {
+		errs = append(errs, fmt.Errorf("expired_retention: must be positive, got %s", cfg.ExpiredRetention))
+	}
+	if cfg.DeleteQueueSize <= 0 {
+		errs = append(errs, fmt.Errorf("delete_queue_size: must be positive, got %d", cfg.DeleteQueueSize))
+	}
+	if cfg.CacheSize < 0 {
+		errs = append(errs, fmt.Errorf("cache_size: must not be negative, got %d", cfg.CacheSize))
+	}
+	if cfg.CacheSize > 0 && cfg.CacheTTL <= 0 {
+		errs = append(errs, fmt.Errorf("cache_ttl: must be positive, got %s", cfg.CacheTTL))
+	}
+	if cfg.MetricsAddr != "" {
+		if _, _, err := splitHostPort(cfg.MetricsAddr); err != nil {
+			errs = append(errs, fmt.Errorf("metrics_address: %w", err))
+		}
+	}
+	var err error
+	if cfg.CreateLimit, err = ratelimit.ParseLimit(cfg.RateLimitCreate); err != nil {
+		errs = append(errs, fmt.Errorf("rate_limit_create: %w", err))
+	}
+	if cfg.BatchLimit, err = ratelimit.ParseLimit(cfg.RateLimitBatch); err != nil {
+		errs = append(errs, fmt.Errorf("rate_limit_batch: %w", err))
+	}
+	if cfg.QRLimit, err = ratelimit.ParseLimit(cfg.RateLimitQR); err != nil {
+		errs = append(errs, fmt.Errorf("rate_limit_qr: %w", err))
+	}
+	if cfg.RateLimitBy != "ip" && cfg.RateLimitBy != "user" {
+		errs = append(errs, fmt.Errorf("rate_limit_by: unknown key %q, expected ip or user", cfg.RateLimitBy))
+	}
+	if cfg.TrustedProxyPrefixes, err = parseTrustedProxies(cfg.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("trusted_proxies: %w", err))
+	}
+	if cfg.PolicyReloadInterval < 0 {
+		errs = append(errs, fmt.Errorf("policy_reload_interval: must not be negative, got %s", cfg.PolicyReloadInterval))
+	}
+	return errors.Join(errs...)
+}
 
-	if envBaseAddr := os.Getenv("BASE_URL"); envBaseAddr != "" {
-		baseAddr = envBaseAddr
+// parseTrustedProxies разбирает список адресов и подсетей через запятую
+func parseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
 	}
+	return prefixes, nil
+}
 
-	if envFilename := os.Getenv("FILE_STORAGE_PATH"); envFilename != "" {
-		filename = envFilename
+// parseServerAddress раскладывает ServerAddress на Host и Port либо на путь unix-сокета
+func (cfg *ServiceConfig) parseServerAddress() error {
+	cfg.Host, cfg.Port, cfg.UnixSocket = "", 0, ""
+	if path, ok := strings.CutPrefix(cfg.ServerAddress, unixSocketPrefix); ok {
+		if path == "" {
+			return fmt.Errorf("server_address: empty unix socket path in %q", cfg.ServerAddress)
+		}
+		cfg.UnixSocket = path
+		return nil
 	}
+	host, port, err := splitHostPort(cfg.ServerAddress)
+	if err != nil {
+		return fmt.Errorf("server_address: %w", err)
+	}
+	cfg.Host = host
+	cfg.Port = port
+	return nil
+}
+
+// StorageKind возвращает тип хранилища; если он не задан явно, используется БД при заданном DBDsn,
+// затем файл при заданном Filename, иначе память
+func (cfg *ServiceConfig) StorageKind() string {
+	switch {
+	case cfg.Storage != "":
+		return cfg.Storage
+	case cfg.DBDsn != "":
+		return "postgres"
+	case cfg.Filename != "":
+		return "file"
+	default:
+		return "memory"
+	}
+}
 
-	if envDBDSN := os.Getenv("DATABASE_DSN"); envDBDSN != "" {
-		dbDSN = envDBDSN
+// ListenAddr возвращает адрес в виде, пригодном для net.Listen
+func (cfg *ServiceConfig) ListenAddr() string {
+	if cfg.UnixSocket != "" {
+		return cfg.UnixSocket
 	}
+	return net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+}
 
-	return &ServiceConfig{
-		Host:     host,
-		Port:     port,
-		BaseAddr: baseAddr,
-		Filename: filename,
-		DBDsn:    dbDSN,
-	}, nil
+func splitHostPort(addr string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, fmt.Errorf("wrong address %q, expected host:port, [ipv6]:port or unix:/path.sock: %w", addr, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("wrong port in %q, expected number from 0 to 65535", addr)
+	}
+	return host, int(port), nil
+}
 
+// normalizeBaseAddr проверяет, что адрес — абсолютный http(s) URL, и убирает завершающие слеши,
+// сохраняя префикс пути: http://host/s/ превращается в http://host/s
+func normalizeBaseAddr(baseAddr string) (string, error) {
+	u, err := url.Parse(baseAddr)
+	if err != nil {
+		return "", fmt.Errorf("base_url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("base_url: %q must be an absolute URL with http or https scheme", baseAddr)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("base_url: %q has no host", baseAddr)
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", fmt.Errorf("base_url: %q must not contain user info, query or fragment", baseAddr)
+	}
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u.String(), nil
 }
 
-func NewDefaultServiceConfig() *ServiceConfig {
+func defaultServiceConfig() *ServiceConfig {
 	return &ServiceConfig{
-		Host:     "",
-		Port:     8080,
-		BaseAddr: "http://localhost:8080",
-		Filename: "",
+		ServerAddress:        ":8080",
+		Host:                 "",
+		Port:                 8080,
+		BaseAddr:             "http://localhost:8080",
+		Filename:             "",
+		FileSync:             "interval",
+		FileSyncInterval:     time.Second,
+		CompactInterval:      time.Hour,
+		DBBatchChunkSize:     1000,
+		BatchMaxSize:         10000,
+		DeleteQueueSize:      1000,
+		ExpiredRetention:     365 * 24 * time.Hour,
+		CodeGenerator:        "random",
+		CodeLength:           8,
+		ReaperInterval:       time.Minute,
+		ShutdownTimeout:      10 * time.Second,
+		CacheTTL:             5 * time.Minute,
+		EnableHTTP2:          true,
+		RateLimitQR:          "60/1m",
+		QRLimit:              ratelimit.Limit{Requests: 60, Period: time.Minute},
+		RateLimitBy:          "ip",
+		PolicyReloadInterval: 5 * time.Second,
+	}
+}
+
+func NewDefaultServiceConfig() *ServiceConfig {
+	cfg := defaultServiceConfig()
+	cfg.SecretKey = "secret"
+	return cfg
+}
+
+func newRandomKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
+	return hex.EncodeToString(b), nil
 }
@@ -0,0 +1,256 @@
+package config
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hessayon/ya_practicum_go/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func envFrom(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	}
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	jsonFile := writeConfigFile(t, "config.json", `{
+		"server_address": "localhost:8081",
+		"base_url": "http://file",
+		"file_storage_path": "/tmp/file.json",
+		"code_length": 6,
+		"enable_http2": false
+	}`)
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want func(t *testing.T, cfg *ServiceConfig)
+	}{
+		{
+			name: "defaults",
+			want: func(t *testing.T, cfg *ServiceConfig) {
+				assert.Equal(t, 8080, cfg.Port)
+				assert.Equal(t, "http://localhost:8080", cfg.BaseAddr)
+				assert.Equal(t, 8, cfg.CodeLength)
+				assert.True(t, cfg.EnableHTTP2)
+				assert.NotEmpty(t, cfg.SecretKey)
+			},
+		},
+		{
+			name: "file overrides defaults",
+			args: []string{"-c", jsonFile},
+			want: func(t *testing.T, cfg *ServiceConfig) {
+				assert.Equal(t, "localhost", cfg.Host)
+				assert.Equal(t, 8081, cfg.Port)
+				assert.Equal(t, "http://file", cfg.BaseAddr)
+				assert.Equal(t, 6, cfg.CodeLength)
+				assert.False(t, cfg.EnableHTTP2)
+			},
+		},
+		{
+			name: "env overrides file",
+			env:  map[string]string{"CONFIG": jsonFile, "BASE_URL": "http://env", "CODE_LENGTH": "7"},
+			want: func(t *testing.T, cfg *ServiceConfig) {
+				assert.Equal(t, "http://env", cfg.BaseAddr)
+				assert.Equal(t, 7, cfg.CodeLength)
+				assert.Equal(t, "/tmp/file.json", cfg.Filename)
+			},
+		},
+		{
+			name: "flags override env",
+			args: []string{"-config", jsonFile, "-b", "http://flag", "-http2=true", "-shutdown-timeout", "3s"},
+			env:  map[string]string{"BASE_URL": "http://env", "SHUTDOWN_TIMEOUT": "5s"},
+			want: func(t *testing.T, cfg *ServiceConfig) {
+				assert.Equal(t, "http://flag", cfg.BaseAddr)
+				assert.True(t, cfg.EnableHTTP2)
+				assert.Equal(t, 3*time.Second, cfg.ShutdownTimeout)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := Load(test.args, envFrom(test.env))
+			require.NoError(t, err)
+			test.want(t, cfg)
+		})
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	yamlFile := writeConfigFile(t, "config.yaml", "base_url: http://yaml\nreaper_interval: 30s\nenable_https: true\n")
+	cfg, err := Load([]string{"-c", yamlFile}, envFrom(nil))
+	require.NoError(t, err)
+	assert.Equal(t, "http://yaml", cfg.BaseAddr)
+	assert.Equal(t, 30*time.Second, cfg.ReaperInterval)
+	assert.True(t, cfg.EnableHTTPS)
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		args    []string
+		env     map[string]string
+		wantErr string
+	}{
+		{
+			name:    "unknown key in file",
+			file:    "config.json",
+			content: `{"base_addr": "http://file"}`,
+			wantErr: `unknown key "base_addr"`,
+		},
+		{
+			name:    "bad value in file",
+			file:    "config.yml",
+			content: "code_length: many\n",
+			wantErr: "for code_length",
+		},
+		{
+			name:    "unsupported format",
+			file:    "config.toml",
+			content: "",
+			wantErr: "unsupported format",
+		},
+		{
+			name:    "bad env value",
+			env:     map[string]string{"SHUTDOWN_TIMEOUT": "soon"},
+			wantErr: "env SHUTDOWN_TIMEOUT: invalid value \"soon\" for shutdown_timeout",
+		},
+		{
+			name:    "bad flag value",
+			args:    []string{"-code-len", "x"},
+			wantErr: "flag -code-len: invalid value \"x\" for code_length",
+		},
+		{
+			name:    "invalid address",
+			args:    []string{"-a", "localhost"},
+			wantErr: "server_address",
+		},
+		{
+			name:    "unknown generator",
+			env:     map[string]string{"CODE_GENERATOR": "magic"},
+			wantErr: "code_generator",
+		},
+		{
+			name:    "kv storage without file",
+			args:    []string{"-storage", "kv"},
+			wantErr: "file_storage_path: must be set for kv storage",
+		},
+		{
+			name:    "unknown storage",
+			env:     map[string]string{"STORAGE": "redis"},
+			wantErr: "storage: unknown storage",
+		},
+		{
+			name:    "tls key without cert",
+			args:    []string{"-tls-key", "key.pem"},
+			wantErr: "tls_cert_file",
+		},
+		{
+			name:    "sequential code too short",
+			args:    []string{"-code-gen", "sequential", "-code-len", "5"},
+			wantErr: "code_length: must be at least 6 for sequential generator",
+		},
+		{
+			name:    "db batch chunk too large",
+			args:    []string{"-db-batch-chunk-size", "20000"},
+			wantErr: "db_batch_chunk_size: must be from 1 to 16383",
+		},
+		{
+			name:    "bad rate limit",
+			env:     map[string]string{"RATE_LIMIT_CREATE": "60"},
+			wantErr: "rate_limit_create",
+		},
+		{
+			name:    "bad trusted proxy",
+			args:    []string{"-trusted-proxies", "10.0.0.0/8,proxy.local"},
+			wantErr: "trusted_proxies",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := test.args
+			if test.file != "" {
+				args = append([]string{"-c", writeConfigFile(t, test.file, test.content)}, args...)
+			}
+			_, err := Load(args, envFrom(test.env))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.wantErr)
+		})
+	}
+}
+
+func TestLoadAddresses(t *testing.T) {
+	tests := []struct {
+		name           string
+		args           []string
+		wantListen     string
+		wantUnixSocket string
+		wantBaseAddr   string
+	}{
+		{
+			name:         "host and port",
+			args:         []string{"-a", "localhost:8081"},
+			wantListen:   "localhost:8081",
+			wantBaseAddr: "http://localhost:8080",
+		},
+		{
+			name:         "ipv6",
+			args:         []string{"-a", "[::1]:8081", "-b", "http://[::1]:8081/"},
+			wantListen:   "[::1]:8081",
+			wantBaseAddr: "http://[::1]:8081",
+		},
+		{
+			name:           "unix socket",
+			args:           []string{"-a", "unix:/tmp/shortener.sock", "-b", "https://Short.Example.com/s//"},
+			wantListen:     "/tmp/shortener.sock",
+			wantUnixSocket: "/tmp/shortener.sock",
+			wantBaseAddr:   "https://short.example.com/s",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := Load(test.args, envFrom(nil))
+			require.NoError(t, err)
+			assert.Equal(t, test.wantListen, cfg.ListenAddr())
+			assert.Equal(t, test.wantUnixSocket, cfg.UnixSocket)
+			assert.Equal(t, test.wantBaseAddr, cfg.BaseAddr)
+		})
+	}
+}
+
+func TestLoadCollectsErrors(t *testing.T) {
+	_, err := Load([]string{"-a", "localhost:http", "-b", "localhost:8080", "-code-len", "0"},
+		envFrom(map[string]string{"REAPER_INTERVAL": "often"}))
+	require.Error(t, err)
+	for _, key := range []string{"reaper_interval", "server_address", "base_url", "code_length"} {
+		assert.Contains(t, err.Error(), key)
+	}
+}
+
+func TestLoadRateLimits(t *testing.T) {
+	cfg, err := Load([]string{"-rate-limit-create", "60/1m", "-trusted-proxies", "10.0.0.0/8, ::ffff:192.168.1.1"},
+		envFrom(map[string]string{"RATE_LIMIT_BATCH": "5/s", "RATE_LIMIT_BY": "user", "RATE_LIMIT_QR": "10/1m"}))
+	require.NoError(t, err)
+	assert.Equal(t, ratelimit.Limit{Requests: 60, Period: time.Minute}, cfg.CreateLimit)
+	assert.Equal(t, ratelimit.Limit{Requests: 5, Period: time.Second}, cfg.BatchLimit)
+	assert.Equal(t, ratelimit.Limit{Requests: 10, Period: time.Minute}, cfg.QRLimit)
+	assert.Equal(t, "user", cfg.RateLimitBy)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.168.1.1/32")}, cfg.TrustedProxyPrefixes)
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// setting описывает одну настройку сервиса во всех источниках сразу
+type setting struct {
+	key    string // ключ в файле конфигурации
+	env    string
+	flag   string
+	usage  string
+	isBool bool
+	set    func(cfg *ServiceConfig, value string) error
+}
+
+func stringSetting(key, env, flagName, usage string, field func(cfg *ServiceConfig) *string) *setting {
+	return &setting{key: key, env: env, flag: flagName, usage: usage, set: func(cfg *ServiceConfig, value string) error {
+		*field(cfg) = value
+		return nil
+	}}
+}
+
+func intSetting(key, env, flagName, usage string, field func(cfg *ServiceConfig) *int) *setting {
+	return &setting{key: key, env: env, flag: flagName, usage: usage, set: func(cfg *ServiceConfig, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = n
+		return nil
+	}}
+}
+
+func durationSetting(key, env, flagName, usage string, field func(cfg *ServiceConfig) *time.Duration) *setting {
+	return &setting{key: key, env: env, flag: flagName, usage: usage, set: func(cfg *ServiceConfig, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = d
+		return nil
+	}}
+}
+
+func boolSetting(key, env, flagName, usage string, field func(cfg *ServiceConfig) *bool) *setting {
+	return &setting{key: key, env: env, flag: flagName, usage: usage, isBool: true, set: func(cfg *ServiceConfig, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = b
+		return nil
+	}}
+}
+
+func (s *setting) apply(cfg *ServiceConfig, value string, source string) error {
+	if err := s.set(cfg, value); err != nil {
+		return fmt.Errorf("%s: invalid value %q for %s: %w", source, value, s.key, err)
+	}
+	return nil
+}
+
+var settings = []*setting{
+	stringSetting("server_address", "SERVER_ADDRESS", "a", "address and port to run server",
+		func(cfg *ServiceConfig) *string { return &cfg.ServerAddress }),
+	stringSetting("base_url", "BASE_URL", "b", "base address of result shortened URL",
+		func(cfg *ServiceConfig) *string { return &cfg.BaseAddr }),
+	stringSetting("storage", "STORAGE", "storage", "url storage: memory, file, kv or postgres (chosen by -d and -f if empty)",
+		func(cfg *ServiceConfig) *string { return &cfg.Storage }),
+	stringSetting("file_storage_path", "FILE_STORAGE_PATH", "f", "filename of url storage",
+		func(cfg *ServiceConfig) *string { return &cfg.Filename }),
+	stringSetting("file_sync", "FILE_SYNC", "file-sync", "fsync policy of file storage: always, interval or never",
+		func(cfg *ServiceConfig) *string { return &cfg.FileSync }),
+	durationSetting("file_sync_interval", "FILE_SYNC_INTERVAL", "file-sync-interval", "fsync interval of file storage for interval policy",
+		func(cfg *ServiceConfig) *time.Duration { return &cfg.FileSyncInterval }),
+	durationSetting("compact_interval", "COMPACT_INTERVAL", "compact-interval", "interval of file storage compaction (0 disables compaction)",
+		func(cfg *ServiceConfig) *time.Duration { return &cfg.CompactInterval }),
+	stringSetting("database_dsn", "DATABASE_DSN", "d", "database connection string",
+		func(cfg *ServiceConfig) *string { return &cfg.DBDsn }),
+	intSetting("db_batch_chunk_size", "DB_BATCH_CHUNK_SIZE", "db-batch-chunk-size", "number of rows inserted into database by one statement (1 inserts rows one by one)",
+		func(cfg *ServiceConfig) *int { return &cfg.DBBatchChunkSize }),
+	intSetting("batch_max_size", "BATCH_MAX_SIZE", "batch-max-size", "maximum number of urls in one batch request (0 disables limit)",
+		func(cfg *ServiceConfig) *int { return &cfg.BatchMaxSize }),
+	intSetting("delete_queue_size", "DELETE_QUEUE_SIZE", "delete-queue-size", "number of delete requests waiting for storage before new ones are rejected",
+		func(cfg *ServiceConfig) *int { return &cfg.DeleteQueueSize }),
+	stringSetting("secret_key", "SECRET_KEY", "k", "secret key for signing auth cookies",
+		func(cfg *ServiceConfig) *string { return &cfg.SecretKey }),
+	stringSetting("code_generator", "CODE_GENERATOR", "code-gen", "short code generator: random, sequential or hash",
+		func(cfg *ServiceConfig) *string { return &cfg.CodeGenerator }),
+	intSetting("code_length", "CODE_LENGTH", "code-len", "length of generated short codes",
+		func(cfg *ServiceConfig) *int { return &cfg.CodeLength }),
+	stringSetting("analytics_file_path", "ANALYTICS_FILE_PATH", "analytics-file", "filename of click analytics storage",
+		func(cfg *ServiceConfig) *string { return &cfg.AnalyticsFilename }),
+	durationSetting("reaper_interval", "REAPER_INTERVAL", "reaper-interval", "interval of purging expired urls",
+		func(cfg *ServiceConfig) *time.Duration { return &cfg.ReaperInterval }),
+	durationSetting("expired_retention", "EXPIRED_RETENTION", "expired-retention", "how long codes of expired urls stay reserved before they are purged",
+		func(cfg *ServiceConfig) *time.Duration { return &cfg.ExpiredRetention }),
+	durationSetting("shutdown_timeout", "SHUTDOWN_TIMEOUT", "shutdown-timeout", "timeout of graceful shutdown",
+		func(cfg *ServiceConfig) *time.Duration { return &cfg.ShutdownTimeout }),
+	boolSetting("enable_https", "ENABLE_HTTPS", "s", "enable HTTPS",
+		func(cfg *ServiceConfig) *bool { return &cfg.EnableHTTPS }),
+	stringSetting("tls_cert_file", "TLS_CERT_FILE", "tls-cert", "TLS certificate file (self-signed certificate is generated if empty)",
+		func(cfg *ServiceConfig) *string { return &cfg.TLSCertFile }),
+	stringSetting("tls_key_file", "TLS_KEY_FILE", "tls-key", "TLS private key file",
+		func(cfg *ServiceConfig) *string { return &cfg.TLSKeyFile }),
+	boolSetting("enable_http2", "ENABLE_HTTP2", "http2", "enable HTTP/2 over TLS",
+		func(cfg *ServiceConfig) *bool { return &cfg.EnableHTTP2 }),
+	stringSetting("http_redirect_address", "HTTP_REDIRECT_ADDRESS", "http-redirect-addr", "address of plain HTTP listener redirecting to HTTPS",
+		func(cfg *ServiceConfig) *string { return &cfg.HTTPRedirectAddr }),
+	intSetting("cache_size", "CACHE_SIZE", "cache-size", "number of short urls kept in LRU cache (0 disables cache)",
+		func(cfg *ServiceConfig) *int { return &cfg.CacheSize }),
+	durationSetting("cache_ttl", "CACHE_TTL", "cache-ttl", "time to live of cached short urls",
+		func(cfg *ServiceConfig) *time.Duration { return &cfg.CacheTTL }),
+	stringSetting("metrics_address", "METRICS_ADDRESS", "metrics-addr", "address of admin listener serving metrics (main listener if empty)",
+		func(cfg *ServiceConfig) *string { return &cfg.MetricsAddr }),
+	stringSetting("rate_limit_create", "RATE_LIMIT_CREATE", "rate-limit-create", "rate limit of creating short urls one by one, e.g. 60/1m (no limit if empty)",
+		func(cfg *ServiceConfig) *string { return &cfg.RateLimitCreate }),
+	stringSetting("rate_limit_batch", "RATE_LIMIT_BATCH", "rate-limit-batch", "rate limit of batch requests, e.g. 10/1m (no limit if empty)",
+		func(cfg *ServiceConfig) *string { return &cfg.RateLimitBatch }),
+	stringSetting("rate_limit_qr", "RATE_LIMIT_QR", "rate-limit-qr", "rate limit of rendering qr codes, e.g. 60/1m (no limit if empty)",
+		func(cfg *ServiceConfig) *string { return &cfg.RateLimitQR }),
+	stringSetting("rate_limit_by", "RATE_LIMIT_BY", "rate-limit-by", "key of rate limits: ip or user (the ip limit applies to users too, since cookies are issued to anyone)",
+		func(cfg *ServiceConfig) *string { return &cfg.RateLimitBy }),
+	stringSetting("trusted_proxies", "TRUSTED_PROXIES", "trusted-proxies", "comma-separated addresses and subnets of proxies trusted to set X-Forwarded-For",
+		func(cfg *ServiceConfig) *string { return &cfg.TrustedProxies }),
+	stringSetting("policy_file", "POLICY_FILE", "policy-file", "JSON or YAML file with allowed and denied domains and blocked urls",
+		func(cfg *ServiceConfig) *string { return &cfg.PolicyFile }),
+	durationSetting("policy_reload_interval", "POLICY_RELOAD_INTERVAL", "policy-reload-interval", "how often policy file is checked for changes (0 disables reloading)",
+		func(cfg *ServiceConfig) *time.Duration { return &cfg.PolicyReloadInterval }),
+}
+
+// applyFile читает JSON или YAML файл (формат определяется по расширению) и применяет его ключи
+func applyFile(cfg *ServiceConfig, filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	values := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		err = json.Unmarshal(data, &values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("config file %s: unsupported format, expected .json, .yaml or .yml", filename)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", filename, err)
+	}
+
+	byKey := make(map[string]*setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	// порядок ключей фиксирован, чтобы ошибки выводились всегда в одном порядке
+	sort.Strings(keys)
+	var errs []error
+	for _, key := range keys {
+		s, ok := byKey[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("config file %s: unknown key %q", filename, key))
+			continue
+		}
+		value, err := scalarString(values[key])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("config file %s: invalid value for %s: %w", filename, key, err))
+			continue
+		}
+		errs = append(errs, s.apply(cfg, value, "config file "+filename))
+	}
+	return errors.Join(errs...)
+}
+
+func scalarString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("expected a scalar value, got %T", value)
+	}
+}
@@ -0,0 +1,145 @@
+package deleting
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hessayon/ya_practicum_go/internal/logger"
+	"github.com/hessayon/ya_practicum_go/internal/storage"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrClosed = errors.New("url deleter is closed")
+	// ErrQueueFull — очередь заполнена, потому что хранилище не успевает; запрос стоит повторить позже
+	ErrQueueFull = errors.New("url deleter queue is full")
+)
+
+type deleteJob struct {
+	userID    string
+	shortURLs []string
+}
+
+// URLDeleter асинхронно удаляет ссылки пользователей: запросы разбираются пулом воркеров,
+// их результаты сводятся в один канал (fan-in) и сбрасываются в хранилище батчами.
+type URLDeleter struct {
+	storage       storage.URLStorage
+	jobs          chan deleteJob
+	batchSize     int
+	flushInterval time.Duration
+	mu            sync.RWMutex
+	closed        bool
+	wg            sync.WaitGroup
+}
+
+// NewURLDeleter создаёт удалятор; queueSize — сколько запросов может ждать обработки,
+// сверх этого Delete сразу возвращает ErrQueueFull
+func NewURLDeleter(s storage.URLStorage, workersNum int, queueSize int, batchSize int, flushInterval time.Duration) *URLDeleter {
+	d := &URLDeleter{
+		storage:       s,
+		jobs:          make(chan deleteJob, queueSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+	}
+	outs := make([]chan storage.DeleteTask, 0, workersNum)
+	for i := 0; i < workersNum; i++ {
+		outs = append(outs, d.worker())
+	}
+	d.wg.Add(1)
+	go d.flusher(fanIn(outs...))
+	return d
+}
+
+// Delete ставит ссылки пользователя в очередь на удаление и не ждёт записи в хранилище.
+// Если очередь заполнена, запрос не ждёт места в ней, а сразу получает ErrQueueFull
+func (d *URLDeleter) Delete(userID string, shortURLs []string) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return ErrClosed
+	}
+	select {
+	case d.jobs <- deleteJob{userID: userID, shortURLs: shortURLs}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close перестаёт принимать задачи и дожидается, пока все принятые задачи будут записаны
+func (d *URLDeleter) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	close(d.jobs)
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+func (d *URLDeleter) worker() chan storage.DeleteTask {
+	out := make(chan storage.DeleteTask)
+	go func() {
+		defer close(out)
+		for job := range d.jobs {
+			for _, shortURL := range job.shortURLs {
+				out <- storage.DeleteTask{UserID: job.userID, ShortURL: shortURL}
+			}
+		}
+	}()
+	return out
+}
+
+func fanIn(chs ...chan storage.DeleteTask) chan storage.DeleteTask {
+	finalCh := make(chan storage.DeleteTask)
+	var wg sync.WaitGroup
+	for _, ch := range chs {
+		wg.Add(1)
+		go func(ch chan storage.DeleteTask) {
+			defer wg.Done()
+			for task := range ch {
+				finalCh <- task
+			}
+		}(ch)
+	}
+	go func() {
+		wg.Wait()
+		close(finalCh)
+	}()
+	return finalCh
+}
+
+func (d *URLDeleter) flusher(tasksCh chan storage.DeleteTask) {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.flushInterval)
+	defer ticker.Stop()
+	batch := make([]storage.DeleteTask, 0, d.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := d.storage.DeleteURLs(context.Background(), batch); err != nil {
+			logger.Log.Error("Error in s.DeleteURLs()", zap.Int("batch_size", len(batch)), zap.String("error", err.Error()))
+		}
+		batch = make([]storage.DeleteTask, 0, d.batchSize)
+	}
+	for {
+		select {
+		case task, ok := <-tasksCh:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, task)
+			if len(batch) >= d.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
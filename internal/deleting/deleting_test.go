@@ -0,0 +1,66 @@
+package deleting
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/hessayon/ya_practicum_go/internal/mocks"
+	"github.com/hessayon/ya_practicum_go/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLDeleter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var mu sync.Mutex
+	deleted := make([]storage.DeleteTask, 0)
+	m := mocks.NewMockURLStorage(ctrl)
+	m.EXPECT().DeleteURLs(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, tasks []storage.DeleteTask) error {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.LessOrEqual(t, len(tasks), 2)
+		deleted = append(deleted, tasks...)
+		return nil
+	}).MinTimes(3)
+
+	d := NewURLDeleter(m, 3, 3, 2, time.Hour)
+	assert.NoError(t, d.Delete("user1", []string{"a", "b", "c"}))
+	assert.NoError(t, d.Delete("user2", []string{"d", "e"}))
+	d.Close()
+
+	assert.ElementsMatch(t, []storage.DeleteTask{
+		{UserID: "user1", ShortURL: "a"},
+		{UserID: "user1", ShortURL: "b"},
+		{UserID: "user1", ShortURL: "c"},
+		{UserID: "user2", ShortURL: "d"},
+		{UserID: "user2", ShortURL: "e"},
+	}, deleted)
+	assert.ErrorIs(t, d.Delete("user1", []string{"f"}), ErrClosed)
+}
+
+func TestURLDeleterQueueFull(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// хранилище зависает, пока тест его не отпустит
+	release := make(chan struct{})
+	m := mocks.NewMockURLStorage(ctrl)
+	m.EXPECT().DeleteURLs(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ []storage.DeleteTask) error {
+		<-release
+		return nil
+	}).AnyTimes()
+
+	d := NewURLDeleter(m, 1, 1, 1, time.Hour)
+	// задачи застревают в воркере, fan-in и flusher, после этого заполняется очередь
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = d.Delete("user1", []string{"a"})
+	}
+	assert.ErrorIs(t, err, ErrQueueFull)
+	close(release)
+	d.Close()
+}
@@ -1,25 +1,40 @@
 package handlers
 
 import (
-	"database/sql"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/hessayon/ya_practicum_go/internal/analytics"
+	"github.com/hessayon/ya_practicum_go/internal/auth"
+	"github.com/hessayon/ya_practicum_go/internal/codegen"
 	"github.com/hessayon/ya_practicum_go/internal/config"
+	"github.com/hessayon/ya_practicum_go/internal/deleting"
+	"github.com/hessayon/ya_practicum_go/internal/health"
 	"github.com/hessayon/ya_practicum_go/internal/logger"
+	"github.com/hessayon/ya_practicum_go/internal/metrics"
+	"github.com/hessayon/ya_practicum_go/internal/middleware"
+	"github.com/hessayon/ya_practicum_go/internal/policy"
+	"github.com/hessayon/ya_practicum_go/internal/qrcode"
 	"github.com/hessayon/ya_practicum_go/internal/storage"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/hessayon/ya_practicum_go/internal/urlnorm"
 	"go.uber.org/zap"
 )
 
 type requestBody struct {
-	URL string `json:"url"`
+	URL       string     `json:"url"`
+	Alias     string     `json:"alias,omitempty"`
+	ExpiresIn int64      `json:"expires_in,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 type responseBody struct {
@@ -27,27 +42,111 @@ type responseBody struct {
 }
 
 type requestBatchBody struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	CorrelationID string     `json:"correlation_id"`
+	OriginalURL   string     `json:"original_url"`
+	Alias         string     `json:"alias,omitempty"`
+	ExpiresIn     int64      `json:"expires_in,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
 }
 
+// статусы записей в ответе на батч
+const (
+	batchStatusCreated = "created"
+	batchStatusExists  = "exists"
+	batchStatusInvalid = "invalid"
+	batchStatusBlocked = "blocked"
+)
+
+// maxDeleteURLs — сколько кодов можно удалить одним запросом, чтобы один запрос не занял всю очередь удаления
+const maxDeleteURLs = 1000
+
+// maxExpiration ограничивает срок жизни ссылки, чтобы expires_in не переполнял time.Duration
+const maxExpiration = 10 * 365 * 24 * time.Hour
+
 type responseBatchBody struct {
 	CorrelationID string `json:"correlation_id"`
-	ShortURL      string `json:"short_url"`
+	ShortURL      string `json:"short_url,omitempty"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+}
+
+type responseStats struct {
+	ShortURL string `json:"short_url"`
+	*analytics.Stats
+}
+
+type responseUserURL struct {
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+}
+
+// saveWithGeneratedCode подбирает свободный код и сохраняет ссылку,
+// повторяя попытку, если код успели занять между проверкой и записью
+func saveWithGeneratedCode(ctx context.Context, s storage.URLStorage, g codegen.Generator, urlData *storage.URLData) error {
+	for attempt := 0; attempt < codegen.MaxAttempts; attempt++ {
+		shortURL, err := g.Generate(ctx, urlData.OriginalURL)
+		if err != nil {
+			return err
+		}
+		urlData.ShortURL = shortURL
+		err = s.Save(ctx, urlData)
+		if !errors.Is(err, storage.ErrShortURLTaken) {
+			return err
+		}
+	}
+	return codegen.ErrNoFreeCode
+}
+
+// parseExpiration вычисляет момент истечения ссылки из expires_in (в секундах) или expires_at
+func parseExpiration(expiresIn int64, expiresAt *time.Time, now time.Time) (*time.Time, error) {
+	switch {
+	case expiresIn != 0 && expiresAt != nil:
+		return nil, errors.New("only one of expires_in and expires_at can be set")
+	case expiresIn < 0:
+		return nil, errors.New("expires_in must be positive")
+	case expiresIn > int64(maxExpiration/time.Second):
+		return nil, fmt.Errorf("expires_in must not exceed %d seconds", int64(maxExpiration/time.Second))
+	case expiresIn > 0:
+		expiration := now.Add(time.Duration(expiresIn) * time.Second)
+		return &expiration, nil
+	case expiresAt != nil && !expiresAt.After(now):
+		return nil, errors.New("expires_at must be in the future")
+	case expiresAt != nil && expiresAt.After(now.Add(maxExpiration)):
+		return nil, fmt.Errorf("expires_at must not be more than %d seconds ahead", int64(maxExpiration/time.Second))
+	}
+	return expiresAt, nil
+}
+
+// storageErrorStatus сопоставляет ошибку хранилища HTTP-статусу ответа
+func storageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrConflict), errors.Is(err, storage.ErrShortURLTaken):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrDeleted), errors.Is(err, storage.ErrExpired):
+		return http.StatusGone
+	default:
+		return http.StatusServiceUnavailable
+	}
 }
 
-func getShortURL(url string) string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	const urlLength = 8
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	shortURL := make([]byte, urlLength)
-	for i := range shortURL {
-		shortURL[i] = charset[r.Intn(len(charset))]
+// writeLookupError отвечает на ошибку поиска ссылки по коду: 404, 410 или недоступность хранилища
+func writeLookupError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		http.Error(w, "shortened url not found", http.StatusNotFound)
+	case errors.Is(err, storage.ErrDeleted):
+		http.Error(w, "shortened url is deleted", http.StatusGone)
+	case errors.Is(err, storage.ErrExpired):
+		http.Error(w, "shortened url is expired", http.StatusGone)
+	default:
+		logger.Log.Error("Error in s.GetOriginalURL()", zap.String("error", err.Error()))
+		http.Error(w, "storage is unavailable", storageErrorStatus(err))
 	}
-	return string(shortURL)
 }
 
-func CreateShortURL(s storage.URLStorage) http.HandlerFunc {
+func CreateShortURL(s storage.URLStorage, g codegen.Generator, p *policy.Engine) http.HandlerFunc {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		body, err := io.ReadAll(r.Body)
@@ -55,49 +154,72 @@ func CreateShortURL(s storage.URLStorage) http.HandlerFunc {
 			http.Error(w, "error in reading of request's body", http.StatusBadRequest)
 			return
 		}
-		urlToShort := string(body)
-		shortenedURL := getShortURL(urlToShort)
+		urlToShort, err := urlnorm.Normalize(string(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := p.Check(urlToShort); err != nil {
+			metrics.PolicyBlocks.Inc("create")
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
 
-		err = s.Save(&storage.URLData{
-			UUID:        r.RequestURI,
-			ShortURL:    shortenedURL,
+		userID, _ := auth.UserIDFromContext(r.Context())
+		urlData := &storage.URLData{
 			OriginalURL: urlToShort,
-		})
+			UserID:      userID,
+		}
+		err = saveWithGeneratedCode(r.Context(), s, g, urlData)
+		shortenedURL := urlData.ShortURL
 		statusCode := http.StatusCreated
 		if err != nil {
-			if errors.Is(err, storage.ErrConflict) {
-				statusCode = http.StatusConflict
-				var found bool
-				shortenedURL, found = s.GetShortURL(urlToShort)
-				if !found {
-					http.Error(w, "shortened url not found", http.StatusBadRequest)
-					return
-				}
-			} else {
+			if !errors.Is(err, storage.ErrConflict) {
 				logger.Log.Error("Error in s.Save()", zap.String("error", err.Error()))
+				http.Error(w, "storage is unavailable", storageErrorStatus(err))
+				return
+			}
+			statusCode = http.StatusConflict
+			shortenedURL, err = s.GetShortURL(r.Context(), urlToShort)
+			if err != nil {
+				logger.Log.Error("Error in s.GetShortURL()", zap.String("error", err.Error()))
+				http.Error(w, "shortened url not found", storageErrorStatus(err))
+				return
 			}
 		}
 
+		if statusCode == http.StatusCreated {
+			metrics.LinksCreated.Inc()
+		}
 		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(statusCode)
 		w.Write([]byte(fmt.Sprintf("%s/%s", config.Config.BaseAddr, shortenedURL)))
 	})
 }
 
-func DecodeShortURL(s storage.URLStorage) http.HandlerFunc {
+// DecodeShortURL перенаправляет на исходную ссылку; если ссылка попала под политику уже после
+// создания, вместо перенаправления показывается страница с предупреждением
+func DecodeShortURL(s storage.URLStorage, rec *analytics.Recorder, p *policy.Engine) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		shortenedURL := chi.URLParam(r, "id")
-		originalURL, found := s.GetOriginalURL(shortenedURL)
-		if !found {
-			http.Error(w, "shortened url not found", http.StatusBadRequest)
+		originalURL, err := s.GetOriginalURL(r.Context(), shortenedURL)
+		if err != nil {
+			writeLookupError(w, err)
 			return
 		}
+		if err := p.Check(originalURL); err != nil {
+			metrics.PolicyBlocks.Inc("redirect")
+			renderPage(w, "blocked.html", http.StatusOK, blockedPage{ShortURL: shortenedURL, OriginalURL: originalURL})
+			return
+		}
+		rec.RecordClick(r, shortenedURL, middleware.ClientIP(r, config.Config.TrustedProxyPrefixes))
+		metrics.Redirects.Inc()
 		w.Header().Set("Location", originalURL)
 		w.WriteHeader(http.StatusTemporaryRedirect)
 	})
 }
 
-func CreateShortURLJSON(s storage.URLStorage) http.HandlerFunc {
+func CreateShortURLJSON(s storage.URLStorage, g codegen.Generator, p *policy.Engine) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var reqBody requestBody
 		err := json.NewDecoder(r.Body).Decode(&reqBody)
@@ -106,28 +228,66 @@ func CreateShortURLJSON(s storage.URLStorage) http.HandlerFunc {
 			return
 		}
 
-		shortenedURL := getShortURL(reqBody.URL)
+		reqBody.URL, err = urlnorm.Normalize(reqBody.URL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := p.Check(reqBody.URL); err != nil {
+			metrics.PolicyBlocks.Inc("create")
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		if reqBody.Alias != "" {
+			if err := codegen.ValidateAlias(reqBody.Alias); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 
-		err = s.Save(&storage.URLData{
-			UUID:        r.RequestURI,
-			ShortURL:    shortenedURL,
+		expiresAt, err := parseExpiration(reqBody.ExpiresIn, reqBody.ExpiresAt, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		userID, _ := auth.UserIDFromContext(r.Context())
+		urlData := &storage.URLData{
+			ShortURL:    reqBody.Alias,
 			OriginalURL: reqBody.URL,
-		})
+			UserID:      userID,
+			ExpiresAt:   expiresAt,
+		}
+		if reqBody.Alias != "" {
+			err = s.Save(r.Context(), urlData)
+		} else {
+			err = saveWithGeneratedCode(r.Context(), s, g, urlData)
+		}
+		shortenedURL := urlData.ShortURL
 		statusCode := http.StatusCreated
 		if err != nil {
-			if errors.Is(err, storage.ErrConflict) {
-				statusCode = http.StatusConflict
-				var found bool
-				shortenedURL, found = s.GetShortURL(reqBody.URL)
-				if !found {
-					http.Error(w, "shortened url not found", http.StatusBadRequest)
-					return
-				}
-			} else {
+			if errors.Is(err, storage.ErrShortURLTaken) {
+				http.Error(w, "alias is already taken", http.StatusConflict)
+				return
+			}
+			if !errors.Is(err, storage.ErrConflict) {
 				logger.Log.Error("Error in s.Save()", zap.String("error", err.Error()))
+				http.Error(w, "storage is unavailable", storageErrorStatus(err))
+				return
+			}
+			statusCode = http.StatusConflict
+			shortenedURL, err = s.GetShortURL(r.Context(), reqBody.URL)
+			if err != nil {
+				logger.Log.Error("Error in s.GetShortURL()", zap.String("error", err.Error()))
+				http.Error(w, "shortened url not found", storageErrorStatus(err))
+				return
 			}
 		}
 
+		if statusCode == http.StatusCreated {
+			metrics.LinksCreated.Inc()
+		}
 		respBody := responseBody{
 			ShortenURL: fmt.Sprintf("%s/%s", config.Config.BaseAddr, shortenedURL),
 		}
@@ -142,22 +302,49 @@ func CreateShortURLJSON(s storage.URLStorage) http.HandlerFunc {
 	})
 }
 
-func Ping(w http.ResponseWriter, r *http.Request) {
-	db, err := sql.Open("pgx", config.Config.DBDsn)
-	if err != nil {
-		logger.Log.Error("error in db.Open()", zap.String("db_dsn", config.Config.DBDsn), zap.String("error", err.Error()))
-		http.Error(w, "db is not connected", http.StatusInternalServerError)
-	}
-	defer db.Close()
-	err = db.Ping()
-	if err != nil {
-		logger.Log.Error("error in db.Ping()", zap.String("db_dsn", config.Config.DBDsn), zap.String("error", err.Error()))
-		http.Error(w, "db is not connected", http.StatusInternalServerError)
-	}
+// Ping оставлен для обратной совместимости: 200, если все зависимости доступны, иначе 500
+func Ping(h *health.Health) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := h.Run(r.Context())
+		if !report.IsUp() {
+			logger.Log.Error("dependencies are not available", zap.Any("checks", report.Checks))
+			http.Error(w, "db is not connected", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// Liveness отвечает, что процесс жив и обслуживает запросы; зависимости не проверяются
+func Liveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"up"}`))
+}
+
+// Readiness проверяет зависимости и возвращает отчёт по каждой; 503, если хотя бы одна недоступна
+func Readiness(h *health.Health) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := h.Run(r.Context())
+		statusCode := http.StatusOK
+		if !report.IsUp() {
+			statusCode = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			logger.Log.Error("error in encoding response body", zap.String("error", err.Error()))
+			return
+		}
+	})
 }
 
-func CreateShortURLBatch(s storage.URLStorage) http.HandlerFunc {
+// CreateShortURLBatch сокращает ссылки батчем и сообщает результат по каждой записи:
+// created — сохранена, exists — такая ссылка уже есть (в ответе её код), invalid — запись
+// отклонена, blocked — ссылка запрещена политикой; причина в поле error. Ответ 201, если создана
+// хотя бы одна ссылка, 409, если все допустимые ссылки уже были, 422, если остальные записи
+// запрещены политикой, и 400, если все записи отклонены
+func CreateShortURLBatch(s storage.URLStorage, g codegen.Generator, p *policy.Engine) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var reqBody []requestBatchBody
 		err := json.NewDecoder(r.Body).Decode(&reqBody)
@@ -169,29 +356,426 @@ func CreateShortURLBatch(s storage.URLStorage) http.HandlerFunc {
 			w.WriteHeader(http.StatusCreated)
 			return
 		}
-		urlsData := make([]*storage.URLData, 0, len(reqBody))
-		responseData := make([]responseBatchBody, 0, len(reqBody))
-		for _, data := range reqBody {
-			shortenedURL := getShortURL(data.OriginalURL)
-			urlsData = append(urlsData, &storage.URLData{
-				UUID:        r.RequestURI,
-				ShortURL:    shortenedURL,
+		if config.Config.BatchMaxSize > 0 && len(reqBody) > config.Config.BatchMaxSize {
+			http.Error(w, fmt.Sprintf("batch is too large: at most %d urls are allowed", config.Config.BatchMaxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		now := time.Now()
+		userID, _ := auth.UserIDFromContext(r.Context())
+		results := make([]responseBatchBody, len(reqBody))
+		urlsData := make([]*storage.URLData, len(reqBody))
+		pending := make([]int, 0, len(reqBody))
+		// повторы одной и той же ссылки внутри батча получают код первой из них,
+		// если не просят другой псевдоним или срок жизни
+		firstByURL := make(map[string]int)
+		duplicates := make(map[int]int)
+		aliases := make(map[string]bool)
+		for i := range reqBody {
+			data := &reqBody[i]
+			results[i].CorrelationID = data.CorrelationID
+			expiresAt, err := validateBatchItem(data, aliases, now)
+			if err != nil {
+				results[i].Status, results[i].Error = batchStatusInvalid, err.Error()
+				continue
+			}
+			if err := p.Check(data.OriginalURL); err != nil {
+				metrics.PolicyBlocks.Inc("create")
+				results[i].Status, results[i].Error = batchStatusBlocked, err.Error()
+				continue
+			}
+			if first, ok := firstByURL[data.OriginalURL]; ok {
+				if err := checkBatchDuplicate(data, &reqBody[first], urlsData[first], expiresAt); err != nil {
+					results[i].Status, results[i].Error = batchStatusInvalid, err.Error()
+					continue
+				}
+				duplicates[i] = first
+				continue
+			}
+			firstByURL[data.OriginalURL] = i
+			urlsData[i] = &storage.URLData{
+				ShortURL:    data.Alias,
 				OriginalURL: data.OriginalURL,
-			})
-			responseData = append(responseData, responseBatchBody{
-				CorrelationID: data.CorrelationID, ShortURL: fmt.Sprintf("%s/%s", config.Config.BaseAddr, shortenedURL),
-			})
+				UserID:      userID,
+				ExpiresAt:   expiresAt,
+			}
+			if data.Alias == "" {
+				urlsData[i].ShortURL, err = g.Generate(r.Context(), data.OriginalURL)
+				if err != nil {
+					logger.Log.Error("Error in g.Generate()", zap.String("error", err.Error()))
+					http.Error(w, "error in generating of short url", storageErrorStatus(err))
+					return
+				}
+			}
+			pending = append(pending, i)
 		}
-		err = s.SaveBatch(urlsData)
+
+		err = saveBatchItems(r.Context(), s, g, reqBody, urlsData, pending, results)
 		if err != nil {
 			logger.Log.Error("Error in s.SaveBatch()", zap.String("error", err.Error()))
+			http.Error(w, "error in saving of batch", storageErrorStatus(err))
+			return
+		}
+		for i, first := range duplicates {
+			results[i].ShortURL = results[first].ShortURL
+			results[i].Status = batchStatusExists
+			if results[first].Status == batchStatusInvalid {
+				results[i].Status, results[i].Error = batchStatusInvalid, results[first].Error
+			}
+		}
+
+		created, exist, blocked := 0, 0, 0
+		for _, result := range results {
+			switch result.Status {
+			case batchStatusCreated:
+				created++
+			case batchStatusExists:
+				exist++
+			case batchStatusBlocked:
+				blocked++
+			}
+		}
+		statusCode := http.StatusCreated
+		switch {
+		case created > 0:
+			metrics.LinksCreated.Add(float64(created))
+		case exist > 0:
+			statusCode = http.StatusConflict
+		case blocked > 0:
+			statusCode = http.StatusUnprocessableEntity
+		default:
+			statusCode = http.StatusBadRequest
 		}
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		if err := json.NewEncoder(w).Encode(responseData); err != nil {
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(results); err != nil {
 			logger.Log.Error("error in encoding response body")
-			http.Error(w, "service internal error", http.StatusBadRequest)
 			return
 		}
 	})
 }
+
+// validateBatchItem проверяет запись батча, нормализует в ней ссылку и возвращает срок жизни ссылки
+func validateBatchItem(data *requestBatchBody, aliases map[string]bool, now time.Time) (*time.Time, error) {
+	var err error
+	data.OriginalURL, err = urlnorm.Normalize(data.OriginalURL)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, err := parseExpiration(data.ExpiresIn, data.ExpiresAt, now)
+	if err != nil {
+		return nil, err
+	}
+	if data.Alias == "" {
+		return expiresAt, nil
+	}
+	if err := codegen.ValidateAlias(data.Alias); err != nil {
+		return nil, err
+	}
+	if aliases[data.Alias] {
+		return nil, fmt.Errorf("duplicate alias %q", data.Alias)
+	}
+	aliases[data.Alias] = true
+	return expiresAt, nil
+}
+
+// checkBatchDuplicate проверяет, что повтор ссылки в батче согласен на код первой записи:
+// свой псевдоним или другой срок жизни он получить не может
+func checkBatchDuplicate(data, first *requestBatchBody, firstData *storage.URLData, expiresAt *time.Time) error {
+	if data.Alias != "" && data.Alias != first.Alias {
+		return fmt.Errorf("original_url repeats correlation_id %q with a different alias", first.CorrelationID)
+	}
+	sameExpiration := expiresAt == nil && firstData.ExpiresAt == nil ||
+		expiresAt != nil && firstData.ExpiresAt != nil && expiresAt.Equal(*firstData.ExpiresAt)
+	if !sameExpiration {
+		return fmt.Errorf("original_url repeats correlation_id %q with a different expiration", first.CorrelationID)
+	}
+	return nil
+}
+
+// saveBatchItems сохраняет записи pending одним батчем. Батч сохраняется целиком или никак,
+// поэтому записи, из-за которых он не сохранился, получают свой результат и исключаются,
+// а остальные сохраняются повторно; занятый сгенерированный код подбирается заново.
+// Хранилища сообщают конфликты всех записей сразу, так что обычно хватает одного повтора
+func saveBatchItems(ctx context.Context, s storage.URLStorage, g codegen.Generator, reqBody []requestBatchBody, urlsData []*storage.URLData, pending []int, results []responseBatchBody) error {
+	attempts := make(map[int]int)
+	for len(pending) > 0 {
+		batch := make([]*storage.URLData, 0, len(pending))
+		for _, i := range pending {
+			batch = append(batch, urlsData[i])
+		}
+		err := s.SaveBatch(ctx, batch)
+		if err == nil {
+			for _, i := range pending {
+				results[i].ShortURL = fmt.Sprintf("%s/%s", config.Config.BaseAddr, urlsData[i].ShortURL)
+				results[i].Status = batchStatusCreated
+			}
+			return nil
+		}
+		var batchErr *storage.BatchError
+		if !errors.As(err, &batchErr) {
+			return err
+		}
+		for index, shortURL := range batchErr.Existing {
+			i := pending[index]
+			results[i].ShortURL = fmt.Sprintf("%s/%s", config.Config.BaseAddr, shortURL)
+			results[i].Status = batchStatusExists
+		}
+		taken := batchErr.Taken
+		i := pending[batchErr.Index]
+		switch {
+		case results[i].Status == batchStatusExists || slices.Contains(taken, batchErr.Index):
+		case errors.Is(err, storage.ErrConflict):
+			// код уже сохранённой ссылки хранилище могло не сообщить
+			shortURL, err := s.GetShortURL(ctx, urlsData[i].OriginalURL)
+			if err != nil {
+				return err
+			}
+			results[i].ShortURL = fmt.Sprintf("%s/%s", config.Config.BaseAddr, shortURL)
+			results[i].Status = batchStatusExists
+		case errors.Is(err, storage.ErrShortURLTaken):
+			taken = append(taken, batchErr.Index)
+		default:
+			return err
+		}
+		for _, index := range taken {
+			i := pending[index]
+			if reqBody[i].Alias != "" {
+				results[i].Status, results[i].Error = batchStatusInvalid, fmt.Sprintf("alias %q is already taken", reqBody[i].Alias)
+				continue
+			}
+			attempts[i]++
+			if attempts[i] >= codegen.MaxAttempts {
+				return codegen.ErrNoFreeCode
+			}
+			var err error
+			urlsData[i].ShortURL, err = g.Generate(ctx, urlsData[i].OriginalURL)
+			if err != nil {
+				return err
+			}
+		}
+		pending = slices.DeleteFunc(pending, func(i int) bool { return results[i].Status != "" })
+	}
+	return nil
+}
+
+func GetUserURLs(s storage.URLStorage) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.UserIDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		urlsData, err := s.GetURLsByUser(r.Context(), userID)
+		if err != nil {
+			logger.Log.Error("Error in s.GetURLsByUser()", zap.String("user_id", userID), zap.String("error", err.Error()))
+			http.Error(w, "storage is unavailable", storageErrorStatus(err))
+			return
+		}
+		if len(urlsData) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		responseData := make([]responseUserURL, 0, len(urlsData))
+		for _, data := range urlsData {
+			responseData = append(responseData, responseUserURL{
+				ShortURL:    fmt.Sprintf("%s/%s", config.Config.BaseAddr, data.ShortURL),
+				OriginalURL: data.OriginalURL,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(responseData); err != nil {
+			logger.Log.Error("error in encoding response body", zap.String("user_id", userID))
+			return
+		}
+	})
+}
+
+func DeleteUserURLs(d *deleting.URLDeleter) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.UserIDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var shortURLs []string
+		if err := json.NewDecoder(r.Body).Decode(&shortURLs); err != nil {
+			http.Error(w, "error in decoding of request's body", http.StatusBadRequest)
+			return
+		}
+		if len(shortURLs) > maxDeleteURLs {
+			http.Error(w, fmt.Sprintf("too many urls: at most %d can be deleted at once", maxDeleteURLs), http.StatusBadRequest)
+			return
+		}
+		if err := d.Delete(userID, shortURLs); err != nil {
+			if errors.Is(err, deleting.ErrQueueFull) {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "too many pending deletions, try again later", http.StatusServiceUnavailable)
+				return
+			}
+			logger.Log.Error("Error in d.Delete()", zap.String("user_id", userID), zap.String("error", err.Error()))
+			http.Error(w, "service is unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// GetLinkStats отдаёт статистику переходов только владельцу ссылки; для остальных
+// ссылка будто не существует
+func GetLinkStats(s storage.URLStorage, rec *analytics.Recorder) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		shortenedURL := chi.URLParam(r, "id")
+		// статистика остаётся доступной и для удалённых или истёкших ссылок
+		urlData, err := s.GetURLData(r.Context(), shortenedURL)
+		if err != nil && !errors.Is(err, storage.ErrDeleted) && !errors.Is(err, storage.ErrExpired) {
+			if !errors.Is(err, storage.ErrNotFound) {
+				logger.Log.Error("Error in s.GetURLData()", zap.String("error", err.Error()))
+			}
+			http.Error(w, "shortened url not found", storageErrorStatus(err))
+			return
+		}
+		userID, _ := auth.UserIDFromContext(r.Context())
+		if urlData.UserID == "" || urlData.UserID != userID {
+			http.Error(w, "shortened url not found", http.StatusNotFound)
+			return
+		}
+		stats, err := rec.GetStats(r.Context(), shortenedURL)
+		if err != nil {
+			logger.Log.Error("Error in rec.GetStats()", zap.String("short_url", shortenedURL), zap.String("error", err.Error()))
+			http.Error(w, "analytics is unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		respBody := responseStats{
+			ShortURL: fmt.Sprintf("%s/%s", config.Config.BaseAddr, shortenedURL),
+			Stats:    stats,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(respBody); err != nil {
+			logger.Log.Error("error in encoding response body", zap.String("short_url", shortenedURL))
+			return
+		}
+	})
+}
+
+// PreviewShortURL показывает, куда ведёт ссылка, вместо перенаправления. Переход не засчитывается,
+// статистику видит только владелец ссылки
+func PreviewShortURL(s storage.URLStorage, rec *analytics.Recorder, p *policy.Engine) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		shortenedURL := chi.URLParam(r, "id")
+		urlData, err := s.GetURLData(r.Context(), shortenedURL)
+		if err != nil {
+			writeLookupError(w, err)
+			return
+		}
+		page := newPreviewPage(fmt.Sprintf("%s/%s", config.Config.BaseAddr, shortenedURL), urlData.OriginalURL)
+		page.CreatedAt, page.ExpiresAt = urlData.CreatedAt, urlData.ExpiresAt
+		page.Blocked = p.Check(urlData.OriginalURL) != nil
+		if userID, ok := auth.UserIDFromContext(r.Context()); ok && urlData.UserID != "" && userID == urlData.UserID {
+			stats, err := rec.GetStats(r.Context(), shortenedURL)
+			if err != nil {
+				// страница полезна и без статистики
+				logger.Log.Error("Error in rec.GetStats()", zap.String("short_url", shortenedURL), zap.String("error", err.Error()))
+			}
+			page.Stats = stats
+		}
+		renderPage(w, "preview.html", http.StatusOK, page)
+	})
+}
+
+const (
+	qrDefaultSize = 256
+	qrMaxSize     = 1024
+	qrMaxMargin   = 16
+)
+
+// qrIntParam читает целый параметр запроса из диапазона [lo, hi], пустой параметр даёт def
+func qrIntParam(r *http.Request, name string, def, lo, hi int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("%s must be an integer from %d to %d", name, lo, hi)
+	}
+	return n, nil
+}
+
+// GetQRCode отдаёт QR-код сокращённой ссылки. Параметры запроса: format (png или svg),
+// size — ширина картинки в пикселях, margin — рамка в модулях, level — уровень коррекции L, M, Q или H.
+// SVG масштабируется без потерь, но size меньше MinPixels и для него отклоняется: такой код
+// после растеризации уже не прочитать. На ссылки, запрещённые политикой, код не выдаётся
+func GetQRCode(s storage.URLStorage, p *policy.Engine) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		shortenedURL := chi.URLParam(r, "id")
+		query := r.URL.Query()
+		format := strings.ToLower(query.Get("format"))
+		if format == "" {
+			format = "png"
+		}
+		if format != "png" && format != "svg" {
+			http.Error(w, "format must be png or svg", http.StatusBadRequest)
+			return
+		}
+		size, err := qrIntParam(r, "size", qrDefaultSize, 1, qrMaxSize)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		margin, err := qrIntParam(r, "margin", qrcode.QuietZone, 0, qrMaxMargin)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		level := qrcode.Medium
+		if value := query.Get("level"); value != "" {
+			if level, err = qrcode.ParseLevel(value); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		originalURL, err := s.GetOriginalURL(r.Context(), shortenedURL)
+		if err != nil {
+			writeLookupError(w, err)
+			return
+		}
+		if err := p.Check(originalURL); err != nil {
+			metrics.PolicyBlocks.Inc("qr")
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		code, err := qrcode.Encode([]byte(fmt.Sprintf("%s/%s", config.Config.BaseAddr, shortenedURL)), level)
+		if err != nil {
+			logger.Log.Error("Error in qrcode.Encode()", zap.String("short_url", shortenedURL), zap.String("error", err.Error()))
+			http.Error(w, "short url does not fit into qr code", http.StatusInternalServerError)
+			return
+		}
+		if size < code.MinPixels(margin) {
+			http.Error(w, fmt.Sprintf("size must be at least %d for this link", code.MinPixels(margin)), http.StatusBadRequest)
+			return
+		}
+
+		var body []byte
+		contentType := "image/svg+xml"
+		if format == "svg" {
+			body = code.SVG(size, margin)
+		} else {
+			var buf bytes.Buffer
+			if err := code.WritePNG(&buf, size, margin); err != nil {
+				logger.Log.Error("Error in code.WritePNG()", zap.String("short_url", shortenedURL), zap.String("error", err.Error()))
+				http.Error(w, "error in rendering qr code", http.StatusInternalServerError)
+				return
+			}
+			body, contentType = buf.Bytes(), "image/png"
+		}
+		// код для ссылки не меняется, но ссылку могут удалить или запретить, а общий кэш
+		// продолжал бы отдавать картинку, поэтому кэширует только сам клиент и ненадолго
+		w.Header().Set("Cache-Control", "private, max-age=60")
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	})
+}
@@ -1,18 +1,39 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/golang/mock/gomock"
+	"github.com/hessayon/ya_practicum_go/internal/analytics"
+	"github.com/hessayon/ya_practicum_go/internal/auth"
+	"github.com/hessayon/ya_practicum_go/internal/codegen"
 	"github.com/hessayon/ya_practicum_go/internal/config"
+	"github.com/hessayon/ya_practicum_go/internal/deleting"
+	"github.com/hessayon/ya_practicum_go/internal/health"
 	"github.com/hessayon/ya_practicum_go/internal/mocks"
+	"github.com/hessayon/ya_practicum_go/internal/policy"
+	"github.com/hessayon/ya_practicum_go/internal/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+func newTestRecorder() *analytics.Recorder {
+	return analytics.NewRecorder(analytics.NewMemorySink(), []byte("secret"), 16, 1, time.Second)
+}
+
 func TestCreateShortURLHandler(t *testing.T) {
 
 	type want struct {
@@ -32,6 +53,30 @@ func TestCreateShortURLHandler(t *testing.T) {
 				contentType: "text/plain",
 			},
 		},
+		{
+			name:        "negative test#1: empty body",
+			requestBody: "",
+			want: want{
+				code:        400,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:        "negative test#2: javascript url",
+			requestBody: "javascript:alert(1)",
+			want: want{
+				code:        400,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:        "negative test#3: relative url",
+			requestBody: "/learn",
+			want: want{
+				code:        400,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -42,11 +87,12 @@ func TestCreateShortURLHandler(t *testing.T) {
 			defer ctrl.Finish()
 
 			m := mocks.NewMockURLStorage(ctrl)
-			m.EXPECT().Save(gomock.Any()).AnyTimes()
+			m.EXPECT().GetOriginalURL(gomock.Any(), gomock.Any()).Return("", storage.ErrNotFound).AnyTimes()
+			m.EXPECT().Save(gomock.Any(), gomock.Any()).AnyTimes()
 			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.requestBody))
 			router := chi.NewRouter()
-			router.Get("/{id}", DecodeShortURL(m))
-			router.Post("/", CreateShortURL(m))
+			router.Get("/{id}", DecodeShortURL(m, newTestRecorder(), nil))
+			router.Post("/", CreateShortURL(m, codegen.NewRandomGenerator(8, m), nil))
 			w := httptest.NewRecorder()
 			router.ServeHTTP(w, request)
 			res := w.Result()
@@ -67,7 +113,7 @@ func TestDecodeShortURLHandler(t *testing.T) {
 		correctReq    bool
 		getCallKey    string
 		getCallValue  string
-		getCallStatus bool
+		getCallErr    error
 		requestURL    string
 		want          want
 	}{
@@ -76,7 +122,7 @@ func TestDecodeShortURLHandler(t *testing.T) {
 			correctReq:    true,
 			getCallKey:    "EwHXdJfB",
 			getCallValue:  "https://practicum.yandex.ru/",
-			getCallStatus: true,
+			getCallErr:    nil,
 			requestURL:    "/EwHXdJfB",
 			want: want{
 				code:                307,
@@ -88,15 +134,51 @@ func TestDecodeShortURLHandler(t *testing.T) {
 			correctReq:    true,
 			getCallKey:    "yhfjOHdb",
 			getCallValue:  "",
-			getCallStatus: false,
+			getCallErr:    storage.ErrNotFound,
 			requestURL:    "/yhfjOHdb",
 			want: want{
-				code:                400,
+				code:                404,
+				locationHeaderValue: "",
+			},
+		},
+		{
+			name:          "negative test#2: deleted url",
+			correctReq:    true,
+			getCallKey:    "EwHXdJfB",
+			getCallValue:  "",
+			getCallErr:    storage.ErrDeleted,
+			requestURL:    "/EwHXdJfB",
+			want: want{
+				code:                410,
 				locationHeaderValue: "",
 			},
 		},
 		{
-			name:       "negative test#2",
+			name:          "negative test#3: expired url",
+			correctReq:    true,
+			getCallKey:    "EwHXdJfB",
+			getCallValue:  "",
+			getCallErr:    storage.ErrExpired,
+			requestURL:    "/EwHXdJfB",
+			want: want{
+				code:                410,
+				locationHeaderValue: "",
+			},
+		},
+		{
+			name:          "negative test#4: storage is unavailable",
+			correctReq:    true,
+			getCallKey:    "EwHXdJfB",
+			getCallValue:  "",
+			getCallErr:    errors.New("connection refused"),
+			requestURL:    "/EwHXdJfB",
+			want: want{
+				code:                503,
+				locationHeaderValue: "",
+			},
+		},
+		{
+			name:       "negative test#5",
 			correctReq: false,
 			requestURL: "/EwHXdJfB/yhfjOHdb",
 			want: want{
@@ -114,13 +196,13 @@ func TestDecodeShortURLHandler(t *testing.T) {
 			m := mocks.NewMockURLStorage(ctrl)
 			if test.correctReq {
 
-				m.EXPECT().GetOriginalURL(test.getCallKey).Return(test.getCallValue, test.getCallStatus)
+				m.EXPECT().GetOriginalURL(gomock.Any(), test.getCallKey).Return(test.getCallValue, test.getCallErr)
 			}
 
 			request := httptest.NewRequest(http.MethodGet, test.requestURL, nil)
 			router := chi.NewRouter()
-			router.Get("/{id}", DecodeShortURL(m))
-			router.Post("/", CreateShortURL(m))
+			router.Get("/{id}", DecodeShortURL(m, newTestRecorder(), nil))
+			router.Post("/", CreateShortURL(m, codegen.NewRandomGenerator(8, m), nil))
 			w := httptest.NewRecorder()
 			router.ServeHTTP(w, request)
 			res := w.Result()
@@ -131,6 +213,44 @@ func TestDecodeShortURLHandler(t *testing.T) {
 	}
 }
 
+// eventsSink запоминает сброшенные события переходов
+type eventsSink struct {
+	*analytics.MemorySink
+	events []analytics.ClickEvent
+}
+
+func (s *eventsSink) WriteClicks(ctx context.Context, events []analytics.ClickEvent) error {
+	s.events = append(s.events, events...)
+	return s.MemorySink.WriteClicks(ctx, events)
+}
+
+func TestDecodeShortURLHashesClientIP(t *testing.T) {
+	config.Config = config.NewDefaultServiceConfig()
+	config.Config.TrustedProxyPrefixes = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockURLStorage(ctrl)
+	m.EXPECT().GetOriginalURL(gomock.Any(), "EwHXdJfB").Return("https://practicum.yandex.ru/", nil).AnyTimes()
+	sink := &eventsSink{MemorySink: analytics.NewMemorySink()}
+	rec := analytics.NewRecorder(sink, []byte("secret"), 16, 16, time.Hour)
+	router := chi.NewRouter()
+	router.Get("/{id}", DecodeShortURL(m, rec, nil))
+
+	// все переходы приходят от прокси, клиента он сообщает в X-Forwarded-For
+	for _, clientIP := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.1"} {
+		request := httptest.NewRequest(http.MethodGet, "/EwHXdJfB", nil)
+		request.RemoteAddr = "10.0.0.2:4000"
+		request.Header.Set("X-Forwarded-For", clientIP)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	}
+	require.NoError(t, rec.Close())
+	require.Len(t, sink.events, 3)
+	assert.NotEqual(t, sink.events[0].IPHash, sink.events[1].IPHash)
+	assert.Equal(t, sink.events[0].IPHash, sink.events[2].IPHash)
+}
+
 func TestCreateShortURLJSONHandler(t *testing.T) {
 
 	type want struct {
@@ -140,6 +260,7 @@ func TestCreateShortURLJSONHandler(t *testing.T) {
 	tests := []struct {
 		name        string
 		requestBody string
+		saveErr     error
 		want        want
 	}{
 		{
@@ -158,6 +279,95 @@ func TestCreateShortURLJSONHandler(t *testing.T) {
 				contentType: "text/plain; charset=utf-8",
 			},
 		},
+		{
+			name:        "positive test#2: alias",
+			requestBody: "{\"url\": \"https://practicum.yandex.ru\", \"alias\": \"spring-sale\"}",
+			want: want{
+				code:        201,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:        "negative test#2: reserved alias",
+			requestBody: "{\"url\": \"https://practicum.yandex.ru\", \"alias\": \"api\"}",
+			want: want{
+				code:        400,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:        "negative test#3: alias with wrong characters",
+			requestBody: "{\"url\": \"https://practicum.yandex.ru\", \"alias\": \"spring/sale\"}",
+			want: want{
+				code:        400,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:        "positive test#3: expires_in",
+			requestBody: "{\"url\": \"https://practicum.yandex.ru\", \"expires_in\": 3600}",
+			want: want{
+				code:        201,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:        "negative test#4: negative expires_in",
+			requestBody: "{\"url\": \"https://practicum.yandex.ru\", \"expires_in\": -1}",
+			want: want{
+				code:        400,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:        "negative test#5: expires_at in the past",
+			requestBody: "{\"url\": \"https://practicum.yandex.ru\", \"expires_at\": \"2020-01-01T00:00:00Z\"}",
+			want: want{
+				code:        400,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:        "negative test#6: both expires_in and expires_at",
+			requestBody: "{\"url\": \"https://practicum.yandex.ru\", \"expires_in\": 60, \"expires_at\": \"2100-01-01T00:00:00Z\"}",
+			want: want{
+				code:        400,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:        "negative test#7: url without scheme",
+			requestBody: "{\"url\": \"practicum.yandex.ru\"}",
+			want: want{
+				code:        400,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:        "negative test#8: too large expires_in",
+			requestBody: "{\"url\": \"https://practicum.yandex.ru\", \"expires_in\": 1000000000000}",
+			want: want{
+				code:        400,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:        "negative test#9: expires_at too far in the future",
+			requestBody: "{\"url\": \"https://practicum.yandex.ru\", \"expires_at\": \"9999-01-01T00:00:00Z\"}",
+			want: want{
+				code:        400,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:        "negative test#10: alias is taken",
+			requestBody: "{\"url\": \"https://practicum.yandex.ru\", \"alias\": \"spring-sale\"}",
+			saveErr:     storage.ErrShortURLTaken,
+			want: want{
+				code:        409,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -166,12 +376,79 @@ func TestCreateShortURLJSONHandler(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 			m := mocks.NewMockURLStorage(ctrl)
-			m.EXPECT().Save(gomock.Any()).AnyTimes()
+			m.EXPECT().GetOriginalURL(gomock.Any(), gomock.Any()).Return("", storage.ErrNotFound).AnyTimes()
+			m.EXPECT().Save(gomock.Any(), gomock.Any()).Return(test.saveErr).AnyTimes()
 			request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(test.requestBody))
 			router := chi.NewRouter()
-			router.Get("/{id}", DecodeShortURL(m))
-			router.Post("/", CreateShortURL(m))
-			router.Post("/api/shorten", CreateShortURLJSON(m))
+			router.Get("/{id}", DecodeShortURL(m, newTestRecorder(), nil))
+			router.Post("/", CreateShortURL(m, codegen.NewRandomGenerator(8, m), nil))
+			router.Post("/api/shorten", CreateShortURLJSON(m, codegen.NewRandomGenerator(8, m), nil))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, request)
+			res := w.Result()
+			assert.Equal(t, test.want.code, res.StatusCode)
+			assert.Equal(t, test.want.contentType, res.Header.Get("Content-Type"))
+			res.Body.Close()
+		})
+	}
+}
+
+func TestGetUserURLsHandler(t *testing.T) {
+	type want struct {
+		code        int
+		contentType string
+	}
+	tests := []struct {
+		name       string
+		userID     string
+		storedURLs []*storage.URLData
+		want       want
+	}{
+		{
+			name:   "positive test#1",
+			userID: "user1",
+			storedURLs: []*storage.URLData{
+				{ShortURL: "EwHXdJfB", OriginalURL: "https://practicum.yandex.ru/", UserID: "user1"},
+			},
+			want: want{
+				code:        200,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:       "positive test#2: no urls",
+			userID:     "user1",
+			storedURLs: []*storage.URLData{},
+			want: want{
+				code:        204,
+				contentType: "",
+			},
+		},
+		{
+			name:   "negative test#1: no user",
+			userID: "",
+			want: want{
+				code:        401,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config.Config = config.NewDefaultServiceConfig()
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			m := mocks.NewMockURLStorage(ctrl)
+			if test.userID != "" {
+				m.EXPECT().GetURLsByUser(gomock.Any(), test.userID).Return(test.storedURLs, nil)
+			}
+			request := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+			if test.userID != "" {
+				request = request.WithContext(auth.WithUserID(request.Context(), test.userID))
+			}
+			router := chi.NewRouter()
+			router.Get("/api/user/urls", GetUserURLs(m))
 			w := httptest.NewRecorder()
 			router.ServeHTTP(w, request)
 			res := w.Result()
@@ -181,3 +458,725 @@ func TestCreateShortURLJSONHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestDeleteUserURLsHandler(t *testing.T) {
+	tooMany := make([]string, maxDeleteURLs+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("code%d", i)
+	}
+	tooManyBody, err := json.Marshal(tooMany)
+	require.NoError(t, err)
+	tests := []struct {
+		name        string
+		userID      string
+		requestBody string
+		wantCode    int
+	}{
+		{
+			name:        "positive test#1",
+			userID:      "user1",
+			requestBody: `["EwHXdJfB", "yhfjOHdb"]`,
+			wantCode:    202,
+		},
+		{
+			name:        "negative test#1: no user",
+			requestBody: `["EwHXdJfB"]`,
+			wantCode:    401,
+		},
+		{
+			name:        "negative test#2: malformed json",
+			userID:      "user1",
+			requestBody: `["EwHXdJfB"`,
+			wantCode:    400,
+		},
+		{
+			name:        "negative test#3: too many urls",
+			userID:      "user1",
+			requestBody: string(tooManyBody),
+			wantCode:    400,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			m := mocks.NewMockURLStorage(ctrl)
+			if test.wantCode == http.StatusAccepted {
+				m.EXPECT().DeleteURLs(gomock.Any(), []storage.DeleteTask{
+					{UserID: test.userID, ShortURL: "EwHXdJfB"},
+					{UserID: test.userID, ShortURL: "yhfjOHdb"},
+				}).Return(nil)
+			}
+			d := deleting.NewURLDeleter(m, 1, 1, 2, time.Hour)
+			request := httptest.NewRequest(http.MethodDelete, "/api/user/urls", strings.NewReader(test.requestBody))
+			if test.userID != "" {
+				request = request.WithContext(auth.WithUserID(request.Context(), test.userID))
+			}
+			w := httptest.NewRecorder()
+			DeleteUserURLs(d)(w, request)
+			// Close дожидается записи принятых удалений, и мок проверяет, что они дошли до хранилища
+			d.Close()
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, test.wantCode, res.StatusCode)
+		})
+	}
+}
+
+func TestDeleteUserURLsHandlerQueueFull(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	// хранилище зависает, пока тест его не отпустит
+	release := make(chan struct{})
+	m := mocks.NewMockURLStorage(ctrl)
+	m.EXPECT().DeleteURLs(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ []storage.DeleteTask) error {
+		<-release
+		return nil
+	}).AnyTimes()
+	d := deleting.NewURLDeleter(m, 1, 1, 1, time.Hour)
+	defer d.Close()
+	defer close(release)
+
+	var res *http.Response
+	for i := 0; i < 10; i++ {
+		request := httptest.NewRequest(http.MethodDelete, "/api/user/urls", strings.NewReader(`["EwHXdJfB"]`))
+		request = request.WithContext(auth.WithUserID(request.Context(), "user1"))
+		w := httptest.NewRecorder()
+		DeleteUserURLs(d)(w, request)
+		res = w.Result()
+		res.Body.Close()
+		if res.StatusCode != http.StatusAccepted {
+			break
+		}
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	assert.Equal(t, "1", res.Header.Get("Retry-After"))
+}
+
+func TestShortenDeletedURLAgain(t *testing.T) {
+	config.Config = config.NewDefaultServiceConfig()
+	s, err := storage.NewURLStorage("")
+	require.NoError(t, err)
+	defer s.Close()
+	d := deleting.NewURLDeleter(s, 1, 1, 1, time.Hour)
+	router := chi.NewRouter()
+	router.Post("/", CreateShortURL(s, codegen.NewRandomGenerator(8, s), nil))
+	router.Delete("/api/user/urls", DeleteUserURLs(d))
+	shorten := func() (int, string) {
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://practicum.yandex.ru/"))
+		request = request.WithContext(auth.WithUserID(request.Context(), "user1"))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w.Code, strings.TrimPrefix(w.Body.String(), config.Config.BaseAddr+"/")
+	}
+
+	code, shortURL := shorten()
+	require.Equal(t, http.StatusCreated, code)
+	request := httptest.NewRequest(http.MethodDelete, "/api/user/urls", strings.NewReader(`["`+shortURL+`"]`))
+	request = request.WithContext(auth.WithUserID(request.Context(), "user1"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	// Close дожидается, пока удаление дойдёт до хранилища
+	d.Close()
+
+	// удалённая ссылка не мешает сократить тот же адрес заново, код выдаётся новый
+	code, newShortURL := shorten()
+	assert.Equal(t, http.StatusCreated, code)
+	assert.NotEqual(t, shortURL, newShortURL)
+	_, err = s.GetOriginalURL(context.Background(), shortURL)
+	assert.ErrorIs(t, err, storage.ErrDeleted)
+}
+
+func TestHealthHandlers(t *testing.T) {
+	type want struct {
+		code         int
+		bodyContains string
+	}
+	tests := []struct {
+		name     string
+		url      string
+		checkErr error
+		want     want
+	}{
+		{
+			name: "liveness does not check storage",
+			url:  "/healthz",
+			want: want{code: 200, bodyContains: `"status":"up"`},
+		},
+		{
+			name: "readiness with available storage",
+			url:  "/readyz",
+			want: want{code: 200, bodyContains: `"storage":{"status":"up"`},
+		},
+		{
+			name:     "readiness with unavailable storage",
+			url:      "/readyz",
+			checkErr: errors.New("connection refused"),
+			want:     want{code: 503, bodyContains: `"error":"connection refused"`},
+		},
+		{
+			name: "ping with available storage",
+			url:  "/ping",
+			want: want{code: 200},
+		},
+		{
+			name:     "ping with unavailable storage",
+			url:      "/ping",
+			checkErr: errors.New("connection refused"),
+			want:     want{code: 500, bodyContains: "db is not connected"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			m := mocks.NewMockURLStorage(ctrl)
+			m.EXPECT().Check(gomock.Any()).Return(test.checkErr).AnyTimes()
+			hc := health.New(time.Second)
+			hc.Register("storage", m)
+
+			router := chi.NewRouter()
+			router.Get("/healthz", Liveness)
+			router.Get("/readyz", Readiness(hc))
+			router.Get("/ping", Ping(hc))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.url, nil))
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, test.want.code, res.StatusCode)
+			assert.Contains(t, w.Body.String(), test.want.bodyContains)
+		})
+	}
+}
+
+func TestCreateShortURLBatchHandler(t *testing.T) {
+	type want struct {
+		code    int
+		results []responseBatchBody
+	}
+	tests := []struct {
+		name        string
+		requestBody string
+		want        want
+	}{
+		{
+			name:        "positive test#1: all created",
+			requestBody: `[{"correlation_id": "1", "original_url": "https://b", "alias": "b-link"}, {"correlation_id": "2", "original_url": "https://c", "alias": "c-link"}]`,
+			want: want{
+				code: 201,
+				results: []responseBatchBody{
+					{CorrelationID: "1", ShortURL: "http://localhost:8080/b-link", Status: batchStatusCreated},
+					{CorrelationID: "2", ShortURL: "http://localhost:8080/c-link", Status: batchStatusCreated},
+				},
+			},
+		},
+		{
+			name:        "positive test#2: mixed results",
+			requestBody: `[{"correlation_id": "1", "original_url": "https://a"}, {"correlation_id": "2", "original_url": "https://b", "alias": "b-link"}, {"correlation_id": "3", "original_url": "https://c", "alias": "a-link"}, {"correlation_id": "4", "original_url": ""}, {"correlation_id": "5", "original_url": "HTTPS://B:443#top"}]`,
+			want: want{
+				code: 201,
+				results: []responseBatchBody{
+					{CorrelationID: "1", ShortURL: "http://localhost:8080/a-link", Status: batchStatusExists},
+					{CorrelationID: "2", ShortURL: "http://localhost:8080/b-link", Status: batchStatusCreated},
+					{CorrelationID: "3", Status: batchStatusInvalid, Error: `alias "a-link" is already taken`},
+					{CorrelationID: "4", Status: batchStatusInvalid, Error: "invalid url: url is empty"},
+					{CorrelationID: "5", ShortURL: "http://localhost:8080/b-link", Status: batchStatusExists},
+				},
+			},
+		},
+		{
+			name:        "positive test#3: repeated url",
+			requestBody: `[{"correlation_id": "1", "original_url": "https://d", "alias": "d-link", "expires_in": 60}, {"correlation_id": "2", "original_url": "https://d", "expires_in": 60}, {"correlation_id": "3", "original_url": "https://d", "alias": "other-link", "expires_in": 60}, {"correlation_id": "4", "original_url": "https://d"}]`,
+			want: want{
+				code: 201,
+				results: []responseBatchBody{
+					{CorrelationID: "1", ShortURL: "http://localhost:8080/d-link", Status: batchStatusCreated},
+					{CorrelationID: "2", ShortURL: "http://localhost:8080/d-link", Status: batchStatusExists},
+					{CorrelationID: "3", Status: batchStatusInvalid, Error: `original_url repeats correlation_id "1" with a different alias`},
+					{CorrelationID: "4", Status: batchStatusInvalid, Error: `original_url repeats correlation_id "1" with a different expiration`},
+				},
+			},
+		},
+		{
+			name:        "negative test#1: all exist",
+			requestBody: `[{"correlation_id": "1", "original_url": "https://a"}]`,
+			want: want{
+				code: 409,
+				results: []responseBatchBody{
+					{CorrelationID: "1", ShortURL: "http://localhost:8080/a-link", Status: batchStatusExists},
+				},
+			},
+		},
+		{
+			name:        "negative test#2: all invalid",
+			requestBody: `[{"correlation_id": "1", "original_url": "https://b", "alias": "api"}, {"correlation_id": "2", "original_url": "https://c", "expires_in": -1}, {"correlation_id": "3", "original_url": "https://d", "expires_in": 1000000000000}]`,
+			want: want{
+				code: 400,
+				results: []responseBatchBody{
+					{CorrelationID: "1", Status: batchStatusInvalid, Error: `invalid alias: "api" is reserved`},
+					{CorrelationID: "2", Status: batchStatusInvalid, Error: "expires_in must be positive"},
+					{CorrelationID: "3", Status: batchStatusInvalid, Error: "expires_in must not exceed 315360000 seconds"},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config.Config = config.NewDefaultServiceConfig()
+			s, err := storage.NewURLStorage("")
+			require.NoError(t, err)
+			defer s.Close()
+			require.NoError(t, s.Save(context.Background(), &storage.URLData{ShortURL: "a-link", OriginalURL: "https://a"}))
+
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(test.requestBody))
+			w := httptest.NewRecorder()
+			CreateShortURLBatch(s, codegen.NewRandomGenerator(8, s), nil)(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, test.want.code, res.StatusCode)
+			var results []responseBatchBody
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&results))
+			assert.Equal(t, test.want.results, results)
+
+			// созданные ссылки действительно сохранены
+			for _, result := range results {
+				if result.Status != batchStatusCreated {
+					continue
+				}
+				_, err := s.GetOriginalURL(context.Background(), strings.TrimPrefix(result.ShortURL, "http://localhost:8080/"))
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCreateShortURLBatchHandlerStorageError(t *testing.T) {
+	config.Config = config.NewDefaultServiceConfig()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockURLStorage(ctrl)
+	m.EXPECT().SaveBatch(gomock.Any(), gomock.Any()).Return(errors.New("connection refused"))
+
+	request := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(`[{"correlation_id": "1", "original_url": "https://b", "alias": "b-link"}]`))
+	w := httptest.NewRecorder()
+	CreateShortURLBatch(m, codegen.NewRandomGenerator(8, m), nil)(w, request)
+	res := w.Result()
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+}
+
+func TestCreateShortURLBatchHandlerExisting(t *testing.T) {
+	config.Config = config.NewDefaultServiceConfig()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockURLStorage(ctrl)
+	m.EXPECT().GetOriginalURL(gomock.Any(), gomock.Any()).Return("", storage.ErrNotFound).AnyTimes()
+	// хранилище сразу сообщает коды всех уже сохранённых ссылок, повтор идёт без них
+	gomock.InOrder(
+		m.EXPECT().SaveBatch(gomock.Any(), gomock.Len(3)).Return(&storage.BatchError{
+			Index:    0,
+			Err:      storage.ErrConflict,
+			Existing: map[int]string{0: "a-link", 2: "c-link"},
+		}),
+		m.EXPECT().SaveBatch(gomock.Any(), gomock.Len(1)).Return(nil),
+	)
+
+	requestBody := `[{"correlation_id": "1", "original_url": "https://a"}, {"correlation_id": "2", "original_url": "https://b", "alias": "b-link"}, {"correlation_id": "3", "original_url": "https://c"}]`
+	request := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(requestBody))
+	w := httptest.NewRecorder()
+	CreateShortURLBatch(m, codegen.NewRandomGenerator(8, m), nil)(w, request)
+	res := w.Result()
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	var results []responseBatchBody
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&results))
+	assert.Equal(t, []responseBatchBody{
+		{CorrelationID: "1", ShortURL: "http://localhost:8080/a-link", Status: batchStatusExists},
+		{CorrelationID: "2", ShortURL: "http://localhost:8080/b-link", Status: batchStatusCreated},
+		{CorrelationID: "3", ShortURL: "http://localhost:8080/c-link", Status: batchStatusExists},
+	}, results)
+}
+
+// saveBatchCounter считает вызовы SaveBatch у настоящего хранилища
+type saveBatchCounter struct {
+	storage.URLStorage
+	calls int
+}
+
+func (s *saveBatchCounter) SaveBatch(ctx context.Context, urlsBatch []*storage.URLData) error {
+	s.calls++
+	return s.URLStorage.SaveBatch(ctx, urlsBatch)
+}
+
+func TestCreateShortURLBatchHandlerManyConflicts(t *testing.T) {
+	const n = 100
+	storages := map[string]func(t *testing.T, prefix string) storage.URLStorage{
+		"memory": func(t *testing.T, prefix string) storage.URLStorage {
+			s, err := storage.NewURLStorage("")
+			require.NoError(t, err)
+			return s
+		},
+		"kv": func(t *testing.T, prefix string) storage.URLStorage {
+			s, err := storage.NewKVURLStorage(filepath.Join(t.TempDir(), "urls.db"))
+			require.NoError(t, err)
+			return s
+		},
+		"postgres": func(t *testing.T, prefix string) storage.URLStorage {
+			dsn := os.Getenv("TEST_DATABASE_DSN")
+			if dsn == "" {
+				t.Skip("TEST_DATABASE_DSN is not set")
+			}
+			s, err := storage.NewDBURLStorage(dsn, storage.DBOptions{BatchChunkSize: 1000})
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				s.(*storage.URLDBStorage).DB.Exec("DELETE FROM urls WHERE full_url LIKE $1", prefix+"%")
+			})
+			return s
+		},
+	}
+	for kind, newStorage := range storages {
+		t.Run(kind, func(t *testing.T) {
+			config.Config = config.NewDefaultServiceConfig()
+			ctx := context.Background()
+			prefix := fmt.Sprintf("https://conflicts.test/%d/", time.Now().UnixNano())
+			s := &saveBatchCounter{URLStorage: newStorage(t, prefix)}
+			defer s.Close()
+			for i := 0; i < n; i++ {
+				require.NoError(t, s.URLStorage.Save(ctx, &storage.URLData{ShortURL: fmt.Sprintf("test-old-%d", i), OriginalURL: fmt.Sprintf("%sold/%d", prefix, i)}))
+			}
+
+			// новые ссылки, а в конце батча — уже сохранённые и ссылки с занятыми псевдонимами
+			var items []requestBatchBody
+			for i := 0; i < n; i++ {
+				items = append(items, requestBatchBody{CorrelationID: fmt.Sprintf("new-%d", i), OriginalURL: fmt.Sprintf("%snew/%d", prefix, i)})
+			}
+			for i := 0; i < n; i++ {
+				items = append(items, requestBatchBody{CorrelationID: fmt.Sprintf("old-%d", i), OriginalURL: fmt.Sprintf("%sold/%d", prefix, i)})
+			}
+			for i := 0; i < n; i++ {
+				items = append(items, requestBatchBody{CorrelationID: fmt.Sprintf("taken-%d", i), OriginalURL: fmt.Sprintf("%staken/%d", prefix, i), Alias: fmt.Sprintf("test-old-%d", i)})
+			}
+			requestBody, err := json.Marshal(items)
+			require.NoError(t, err)
+
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(string(requestBody)))
+			w := httptest.NewRecorder()
+			CreateShortURLBatch(s, codegen.NewRandomGenerator(8, s), nil)(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, http.StatusCreated, res.StatusCode)
+			var results []responseBatchBody
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&results))
+			require.Len(t, results, 3*n)
+			for i := 0; i < n; i++ {
+				assert.Equal(t, batchStatusCreated, results[i].Status)
+				assert.Equal(t, responseBatchBody{CorrelationID: fmt.Sprintf("old-%d", i), ShortURL: fmt.Sprintf("http://localhost:8080/test-old-%d", i), Status: batchStatusExists}, results[n+i])
+				assert.Equal(t, batchStatusInvalid, results[2*n+i].Status)
+			}
+			// все конфликты исключаются за один повтор
+			assert.Equal(t, 2, s.calls)
+		})
+	}
+}
+
+func TestCreateShortURLBatchHandlerTooLarge(t *testing.T) {
+	config.Config = config.NewDefaultServiceConfig()
+	config.Config.BatchMaxSize = 1
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockURLStorage(ctrl)
+
+	requestBody := `[{"correlation_id": "1", "original_url": "https://a"}, {"correlation_id": "2", "original_url": "https://b"}]`
+	request := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(requestBody))
+	w := httptest.NewRecorder()
+	CreateShortURLBatch(m, codegen.NewRandomGenerator(8, m), nil)(w, request)
+	res := w.Result()
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+}
+
+func TestPolicyBlocking(t *testing.T) {
+	config.Config = config.NewDefaultServiceConfig()
+	filename := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(filename, []byte("deny:\n  - evil.com\n  - \"*.evil.com\"\n"), 0o600))
+	p, err := policy.Load(filename, 0)
+	require.NoError(t, err)
+	defer p.Close()
+	s, err := storage.NewURLStorage("")
+	require.NoError(t, err)
+	defer s.Close()
+	g := codegen.NewRandomGenerator(8, s)
+
+	t.Run("create", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://login.evil.com/"))
+		w := httptest.NewRecorder()
+		CreateShortURL(s, g, p)(w, request)
+		res := w.Result()
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusUnprocessableEntity, res.StatusCode)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		requestBody := `[{"correlation_id": "1", "original_url": "https://evil.com"}, {"correlation_id": "2", "original_url": "https://good.com"}]`
+		request := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(requestBody))
+		w := httptest.NewRecorder()
+		CreateShortURLBatch(s, g, p)(w, request)
+		res := w.Result()
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusCreated, res.StatusCode)
+		var results []responseBatchBody
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&results))
+		require.Len(t, results, 2)
+		assert.Equal(t, batchStatusBlocked, results[0].Status)
+		assert.Equal(t, batchStatusCreated, results[1].Status)
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		// ссылка сохранена до того, как домен попал в список запрещённых
+		require.NoError(t, s.Save(context.Background(), &storage.URLData{ShortURL: "old-link", OriginalURL: "https://evil.com/pay"}))
+		router := chi.NewRouter()
+		router.Get("/{id}", DecodeShortURL(s, newTestRecorder(), p))
+		request := httptest.NewRequest(http.MethodGet, "/old-link", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		res := w.Result()
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Empty(t, res.Header.Get("Location"))
+		assert.Contains(t, res.Header.Get("Content-Type"), "text/html")
+		assert.Contains(t, w.Body.String(), "https://evil.com/pay")
+	})
+}
+
+func TestGetQRCode(t *testing.T) {
+	type want struct {
+		code        int
+		contentType string
+	}
+	tests := []struct {
+		name   string
+		target string
+		want   want
+	}{
+		{
+			name:   "positive test#1: png by default",
+			target: "/api/qr/a-link",
+			want:   want{code: 200, contentType: "image/png"},
+		},
+		{
+			name:   "positive test#2: svg",
+			target: "/api/qr/a-link?format=svg&size=512&margin=2&level=H",
+			want:   want{code: 200, contentType: "image/svg+xml"},
+		},
+		{
+			name:   "negative test#1: unknown link",
+			target: "/api/qr/missing",
+			want:   want{code: 404, contentType: "text/plain; charset=utf-8"},
+		},
+		{
+			name:   "negative test#2: deleted link",
+			target: "/api/qr/deleted",
+			want:   want{code: 410, contentType: "text/plain; charset=utf-8"},
+		},
+		{
+			name:   "negative test#3: unknown level",
+			target: "/api/qr/a-link?level=X",
+			want:   want{code: 400, contentType: "text/plain; charset=utf-8"},
+		},
+		{
+			name:   "negative test#4: size is too small",
+			target: "/api/qr/a-link?size=20",
+			want:   want{code: 400, contentType: "text/plain; charset=utf-8"},
+		},
+		{
+			name:   "negative test#5: size is too large",
+			target: "/api/qr/a-link?size=2048",
+			want:   want{code: 400, contentType: "text/plain; charset=utf-8"},
+		},
+		{
+			name:   "negative test#6: svg size is too small",
+			target: "/api/qr/a-link?format=svg&size=20",
+			want:   want{code: 400, contentType: "text/plain; charset=utf-8"},
+		},
+		{
+			name:   "negative test#7: blocked link",
+			target: "/api/qr/evil-link",
+			want:   want{code: 403, contentType: "text/plain; charset=utf-8"},
+		},
+	}
+
+	config.Config = config.NewDefaultServiceConfig()
+	filename := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(filename, []byte(`{"deny": ["evil.com"]}`), 0o600))
+	p, err := policy.Load(filename, 0)
+	require.NoError(t, err)
+	defer p.Close()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockURLStorage(ctrl)
+	m.EXPECT().GetOriginalURL(gomock.Any(), "a-link").Return("https://a", nil).AnyTimes()
+	m.EXPECT().GetOriginalURL(gomock.Any(), "evil-link").Return("https://evil.com/pay", nil).AnyTimes()
+	m.EXPECT().GetOriginalURL(gomock.Any(), "missing").Return("", storage.ErrNotFound).AnyTimes()
+	m.EXPECT().GetOriginalURL(gomock.Any(), "deleted").Return("", storage.ErrDeleted).AnyTimes()
+	router := chi.NewRouter()
+	router.Get("/api/qr/{id}", GetQRCode(m, p))
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, test.target, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, test.want.code, res.StatusCode)
+			assert.Equal(t, test.want.contentType, res.Header.Get("Content-Type"))
+			// кэшировать можно только сам код и только клиенту, ошибки не кэшируются
+			if test.want.code == http.StatusOK {
+				assert.Equal(t, "private, max-age=60", res.Header.Get("Cache-Control"))
+			} else {
+				assert.Empty(t, res.Header.Get("Cache-Control"))
+			}
+			if test.want.contentType == "image/png" {
+				img, err := png.Decode(res.Body)
+				require.NoError(t, err)
+				assert.Equal(t, 256, img.Bounds().Dx())
+			}
+		})
+	}
+}
+
+func TestPreviewShortURL(t *testing.T) {
+	config.Config = config.NewDefaultServiceConfig()
+	filename := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(filename, []byte(`{"deny": ["evil.com"]}`), 0o600))
+	p, err := policy.Load(filename, 0)
+	require.NoError(t, err)
+	defer p.Close()
+	s, err := storage.NewURLStorage("")
+	require.NoError(t, err)
+	defer s.Close()
+	ctx := context.Background()
+	require.NoError(t, s.SaveBatch(ctx, []*storage.URLData{
+		{ShortURL: "a-link", OriginalURL: "http://a.com/?q=<script>", UserID: "owner"},
+		{ShortURL: "evil-link", OriginalURL: "https://evil.com/pay"},
+		{ShortURL: "gone-link", OriginalURL: "https://gone.com", UserID: "owner"},
+	}))
+	require.NoError(t, s.DeleteURLs(ctx, []storage.DeleteTask{{UserID: "owner", ShortURL: "gone-link"}}))
+	router := chi.NewRouter()
+	router.Get("/{id}+", PreviewShortURL(s, newTestRecorder(), p))
+
+	tests := []struct {
+		name        string
+		target      string
+		userID      string
+		wantCode    int
+		wantBody    []string
+		notWantBody []string
+	}{
+		{
+			name:        "positive test#1: anonymous user",
+			target:      "/a-link+",
+			wantCode:    200,
+			wantBody:    []string{"http://a.com/?q=&lt;script&gt;", "Created", "does not use HTTPS", "Continue to a.com"},
+			notWantBody: []string{"<script>", "Statistics"},
+		},
+		{
+			name:     "positive test#2: owner sees stats",
+			target:   "/a-link+",
+			userID:   "owner",
+			wantCode: 200,
+			wantBody: []string{"Statistics", "Total clicks: 0"},
+		},
+		{
+			name:        "positive test#3: blocked link",
+			target:      "/evil-link+",
+			wantCode:    200,
+			wantBody:    []string{"This link has been blocked"},
+			notWantBody: []string{"Continue to"},
+		},
+		{
+			name:     "negative test#1: deleted link",
+			target:   "/gone-link+",
+			userID:   "owner",
+			wantCode: 410,
+		},
+		{
+			name:     "negative test#2: unknown link",
+			target:   "/missing+",
+			wantCode: 404,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, test.target, nil)
+			if test.userID != "" {
+				request = request.WithContext(auth.WithUserID(request.Context(), test.userID))
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, test.wantCode, res.StatusCode)
+			if test.wantCode != http.StatusOK {
+				return
+			}
+			assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+			for _, want := range test.wantBody {
+				assert.Contains(t, w.Body.String(), want)
+			}
+			for _, notWant := range test.notWantBody {
+				assert.NotContains(t, w.Body.String(), notWant)
+			}
+		})
+	}
+}
+
+func TestGetLinkStats(t *testing.T) {
+	config.Config = config.NewDefaultServiceConfig()
+	s, err := storage.NewURLStorage("")
+	require.NoError(t, err)
+	defer s.Close()
+	ctx := context.Background()
+	require.NoError(t, s.SaveBatch(ctx, []*storage.URLData{
+		{ShortURL: "a-link", OriginalURL: "https://a.com", UserID: "owner"},
+		{ShortURL: "anon-link", OriginalURL: "https://b.com"},
+		{ShortURL: "gone-link", OriginalURL: "https://gone.com", UserID: "owner"},
+	}))
+	require.NoError(t, s.DeleteURLs(ctx, []storage.DeleteTask{{UserID: "owner", ShortURL: "gone-link"}}))
+	router := chi.NewRouter()
+	router.Get("/api/stats/{id}", GetLinkStats(s, newTestRecorder()))
+
+	tests := []struct {
+		name     string
+		target   string
+		userID   string
+		wantCode int
+	}{
+		{name: "positive test#1: owner", target: "/api/stats/a-link", userID: "owner", wantCode: 200},
+		{name: "positive test#2: deleted link", target: "/api/stats/gone-link", userID: "owner", wantCode: 200},
+		{name: "negative test#1: another user", target: "/api/stats/a-link", userID: "stranger", wantCode: 404},
+		{name: "negative test#2: link without owner", target: "/api/stats/anon-link", userID: "stranger", wantCode: 404},
+		{name: "negative test#3: unknown link", target: "/api/stats/missing", userID: "owner", wantCode: 404},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, test.target, nil)
+			request = request.WithContext(auth.WithUserID(request.Context(), test.userID))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, test.wantCode, res.StatusCode)
+			if test.wantCode != http.StatusOK {
+				return
+			}
+			var stats responseStats
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&stats))
+			assert.Equal(t, "http://localhost:8080"+strings.TrimPrefix(test.target, "/api/stats"), stats.ShortURL)
+		})
+	}
+}
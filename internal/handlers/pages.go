@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hessayon/ya_practicum_go/internal/analytics"
+	"github.com/hessayon/ya_practicum_go/internal/logger"
+	"go.uber.org/zap"
+)
+
+//go:embed templates/*.html
+var templatesFS embed.FS
+
+var pages = template.Must(template.ParseFS(templatesFS, "templates/*.html"))
+
+type blockedPage struct {
+	ShortURL    string
+	OriginalURL string
+}
+
+type previewPage struct {
+	ShortURL    string
+	OriginalURL string
+	Host        string
+	// Insecure — ссылка ведёт на http, IDN — в домене есть национальные символы в punycode
+	Insecure  bool
+	IDN       bool
+	Blocked   bool
+	CreatedAt *time.Time
+	ExpiresAt *time.Time
+	// Stats показывается только владельцу ссылки
+	Stats *analytics.Stats
+}
+
+// newPreviewPage заполняет страницу предпросмотра; ссылки в хранилище уже нормализованы,
+// поэтому домен в punycode и его можно показывать как есть
+func newPreviewPage(shortURL, originalURL string) previewPage {
+	page := previewPage{ShortURL: shortURL, OriginalURL: originalURL}
+	if u, err := url.Parse(originalURL); err == nil {
+		page.Host = u.Hostname()
+		page.Insecure = u.Scheme != "https"
+		page.IDN = strings.HasPrefix(page.Host, "xn--") || strings.Contains(page.Host, ".xn--")
+	}
+	return page
+}
+
+// renderPage отдаёт HTML-страницу из templates; html/template экранирует данные страницы.
+// Страница собирается в буфер, чтобы ошибка шаблона не оставила клиенту половину ответа
+func renderPage(w http.ResponseWriter, name string, statusCode int, data any) {
+	var buf bytes.Buffer
+	if err := pages.ExecuteTemplate(&buf, name, data); err != nil {
+		logger.Log.Error("Error in rendering of page", zap.String("page", name), zap.String("error", err.Error()))
+		http.Error(w, "error in rendering of page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// страницы зависят от политики и от того, кто смотрит, поэтому не кэшируются
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,120 @@
+// Package health проверяет готовность зависимостей сервиса.
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Checker — зависимость, которая умеет проверить свою доступность
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc позволяет использовать обычную функцию как Checker
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// CheckResult — результат проверки одной зависимости
+type CheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report — сводный отчёт: статус up, только если доступны все зависимости
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+func (report *Report) IsUp() bool {
+	return report.Status == StatusUp
+}
+
+// Health запускает зарегистрированные проверки параллельно, каждую со своим таймаутом
+type Health struct {
+	timeout  time.Duration
+	mu       sync.RWMutex
+	checkers map[string]Checker
+}
+
+func New(timeout time.Duration) *Health {
+	return &Health{
+		timeout:  timeout,
+		checkers: make(map[string]Checker),
+	}
+}
+
+func (h *Health) Register(name string, checker Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checkers[name] = checker
+}
+
+func (h *Health) Run(ctx context.Context) *Report {
+	h.mu.RLock()
+	names := make([]string, 0, len(h.checkers))
+	for name := range h.checkers {
+		names = append(names, name)
+	}
+	checkers := make([]Checker, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		checkers = append(checkers, h.checkers[name])
+	}
+	h.mu.RUnlock()
+
+	results := make([]CheckResult, len(checkers))
+	var wg sync.WaitGroup
+	for i, checker := range checkers {
+		wg.Add(1)
+		go func(i int, checker Checker) {
+			defer wg.Done()
+			results[i] = h.check(ctx, checker)
+		}(i, checker)
+	}
+	wg.Wait()
+
+	report := &Report{Status: StatusUp, Checks: make(map[string]CheckResult, len(names))}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+// check выполняет одну проверку; зависшая проверка считается неуспешной по истечении таймаута
+func (h *Health) check(ctx context.Context, checker Checker) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- checker.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result := CheckResult{Status: StatusUp, Duration: time.Since(start).String()}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
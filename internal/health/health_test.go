@@ -0,0 +1,72 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthRun(t *testing.T) {
+	up := CheckerFunc(func(ctx context.Context) error { return nil })
+	failing := CheckerFunc(func(ctx context.Context) error { return errors.New("connection refused") })
+	// проверка, которая не смотрит на контекст, не должна задерживать отчёт дольше таймаута
+	hanging := CheckerFunc(func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	tests := []struct {
+		name       string
+		checkers   map[string]Checker
+		wantStatus string
+		wantChecks map[string]string
+		wantError  map[string]string
+	}{
+		{
+			name:       "no checkers",
+			checkers:   map[string]Checker{},
+			wantStatus: StatusUp,
+			wantChecks: map[string]string{},
+		},
+		{
+			name:       "all up",
+			checkers:   map[string]Checker{"storage": up, "analytics": up},
+			wantStatus: StatusUp,
+			wantChecks: map[string]string{"storage": StatusUp, "analytics": StatusUp},
+		},
+		{
+			name:       "one failing",
+			checkers:   map[string]Checker{"storage": failing, "analytics": up},
+			wantStatus: StatusDown,
+			wantChecks: map[string]string{"storage": StatusDown, "analytics": StatusUp},
+			wantError:  map[string]string{"storage": "connection refused"},
+		},
+		{
+			name:       "timeout",
+			checkers:   map[string]Checker{"storage": hanging},
+			wantStatus: StatusDown,
+			wantChecks: map[string]string{"storage": StatusDown},
+			wantError:  map[string]string{"storage": context.DeadlineExceeded.Error()},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := New(50 * time.Millisecond)
+			for name, checker := range test.checkers {
+				h.Register(name, checker)
+			}
+			start := time.Now()
+			report := h.Run(context.Background())
+			assert.Less(t, time.Since(start), 500*time.Millisecond)
+			assert.Equal(t, test.wantStatus, report.Status)
+			assert.Len(t, report.Checks, len(test.wantChecks))
+			for name, status := range test.wantChecks {
+				assert.Equal(t, status, report.Checks[name].Status, name)
+				assert.Equal(t, test.wantError[name], report.Checks[name].Error, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+// Package metrics собирает метрики сервиса и отдаёт их в текстовом формате Prometheus.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultLatencyBuckets — границы гистограмм длительности в секундах, как в клиенте Prometheus
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// SizeBuckets — границы гистограмм размера ответа в байтах
+var SizeBuckets = []float64{100, 1000, 10000, 100000, 1000000}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry хранит зарегистрированные метрики и выводит их в порядке регистрации
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Write выводит все метрики в текстовом формате Prometheus
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler отдаёт метрики реестра на GET-запрос
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		r.Write(w)
+	}
+}
+
+// vec — общая часть метрик с метками: серии хранятся по ключу из значений меток
+type vec[T any] struct {
+	name       string
+	help       string
+	labelNames []string
+	mu         sync.Mutex
+	series     map[string]*T
+	newSeries  func() *T
+}
+
+type seriesEntry[T any] struct {
+	labelValues []string
+	value       *T
+}
+
+func (v *vec[T]) get(labelValues []string) *T {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\x00")
+	s, ok := v.series[key]
+	if !ok {
+		s = v.newSeries()
+		v.series[key] = s
+	}
+	return s
+}
+
+// sorted возвращает серии, упорядоченные по значениям меток, чтобы вывод был стабильным
+func (v *vec[T]) sorted() []seriesEntry[T] {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	entries := make([]seriesEntry[T], 0, len(keys))
+	for _, key := range keys {
+		var labelValues []string
+		if len(v.labelNames) > 0 {
+			labelValues = strings.Split(key, "\x00")
+		}
+		entries = append(entries, seriesEntry[T]{labelValues: labelValues, value: v.series[key]})
+	}
+	return entries
+}
+
+func (v *vec[T]) writeHeader(w *bufio.Writer, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, metricType)
+}
+
+// CounterVec — монотонно растущий счётчик с метками
+type CounterVec struct {
+	vec[float64]
+}
+
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{vec[float64]{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		series:     make(map[string]*float64),
+		newSeries:  func() *float64 { return new(float64) },
+	}}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add увеличивает счётчик; отрицательные значения игнорируются
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.get(labelValues) += delta
+}
+
+// Value возвращает текущее значение серии
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return *c.get(labelValues)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w, "counter")
+	for _, s := range c.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labelNames, s.labelValues), formatValue(*s.value))
+	}
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec — распределение наблюдений по корзинам с метками
+type HistogramVec struct {
+	vec[histogram]
+	buckets []float64
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{buckets: buckets}
+	h.vec = vec[histogram]{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		series:     make(map[string]*histogram),
+		newSeries:  func() *histogram { return &histogram{counts: make([]uint64, len(buckets))} },
+	}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(labelValues)
+	// в серии хранится число попаданий в каждую корзину, накопительные значения считаются при выводе
+	i := sort.SearchFloat64s(h.buckets, value)
+	if i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w, "histogram")
+	bucketLabels := append(append([]string(nil), h.labelNames...), "le")
+	for _, s := range h.sorted() {
+		labelValues := make([]string, len(s.labelValues), len(s.labelValues)+1)
+		copy(labelValues, s.labelValues)
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.value.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, append(labelValues, formatValue(bound))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, append(labelValues, "+Inf")), s.value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labelNames, s.labelValues), formatValue(s.value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labelNames, s.labelValues), s.value.count)
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelValueReplacer.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func(r *Registry)
+		want   string
+	}{
+		{
+			name: "counter without labels",
+			record: func(r *Registry) {
+				c := r.NewCounterVec("links_total", "Created links.")
+				c.Inc()
+				c.Add(2)
+				c.Add(-5)
+			},
+			want: "# HELP links_total Created links.\n" +
+				"# TYPE links_total counter\n" +
+				"links_total 3\n",
+		},
+		{
+			name: "counter series are sorted and escaped",
+			record: func(r *Registry) {
+				c := r.NewCounterVec("requests_total", "Requests.", "route", "status")
+				c.Inc("/{id}", "307")
+				c.Inc("/", "201")
+				c.Inc(`/"quoted"\`, "404")
+			},
+			want: "# HELP requests_total Requests.\n" +
+				"# TYPE requests_total counter\n" +
+				"requests_total{route=\"/\",status=\"201\"} 1\n" +
+				"requests_total{route=\"/\\\"quoted\\\"\\\\\",status=\"404\"} 1\n" +
+				"requests_total{route=\"/{id}\",status=\"307\"} 1\n",
+		},
+		{
+			name: "histogram buckets are cumulative",
+			record: func(r *Registry) {
+				h := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "op")
+				h.Observe(0.05, "save")
+				h.Observe(0.5, "save")
+				h.Observe(3, "save")
+			},
+			want: "# HELP latency_seconds Latency.\n" +
+				"# TYPE latency_seconds histogram\n" +
+				"latency_seconds_bucket{op=\"save\",le=\"0.1\"} 1\n" +
+				"latency_seconds_bucket{op=\"save\",le=\"1\"} 2\n" +
+				"latency_seconds_bucket{op=\"save\",le=\"+Inf\"} 3\n" +
+				"latency_seconds_sum{op=\"save\"} 3.55\n" +
+				"latency_seconds_count{op=\"save\"} 3\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewRegistry()
+			test.record(r)
+			var b strings.Builder
+			require.NoError(t, r.Write(&b))
+			assert.Equal(t, test.want, b.String())
+		})
+	}
+}
+
+func TestRegistryHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("redirects_total", "Redirects.").Inc()
+
+	w := httptest.NewRecorder()
+	r.Handler()(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	res := w.Result()
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, res.Header.Get("Content-Type"), "text/plain; version=0.0.4")
+	assert.Contains(t, w.Body.String(), "redirects_total 1\n")
+}
+
+func TestVecLabelCountMismatch(t *testing.T) {
+	c := NewRegistry().NewCounterVec("requests_total", "Requests.", "route")
+	assert.Panics(t, func() { c.Inc() })
+}
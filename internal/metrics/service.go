@@ -0,0 +1,41 @@
+package metrics
+
+import "net/http"
+
+// Default — реестр метрик сервиса, который отдаётся на GET /metrics
+var Default = NewRegistry()
+
+var (
+	HTTPRequests = Default.NewCounterVec("shortener_http_requests_total",
+		"Number of handled HTTP requests.", "method", "route", "status")
+	HTTPRequestDuration = Default.NewHistogramVec("shortener_http_request_duration_seconds",
+		"Latency of HTTP requests.", DefaultLatencyBuckets, "method", "route", "status")
+	HTTPResponseSize = Default.NewHistogramVec("shortener_http_response_size_bytes",
+		"Size of HTTP response bodies.", SizeBuckets, "method", "route", "status")
+	// отношение compressed="true" ко всем ответам даёт долю ответов, сжатых gzip
+	GzipResponses = Default.NewCounterVec("shortener_http_gzip_responses_total",
+		"Number of responses by whether they were gzip-compressed.", "compressed")
+	StorageOperationDuration = Default.NewHistogramVec("shortener_storage_operation_duration_seconds",
+		"Latency of storage operations.", DefaultLatencyBuckets, "backend", "operation")
+	StorageErrors = Default.NewCounterVec("shortener_storage_errors_total",
+		"Number of failed storage operations.", "backend", "operation")
+	CacheRequests = Default.NewCounterVec("shortener_cache_requests_total",
+		"Number of short URL cache lookups by result.", "result")
+	LinksCreated = Default.NewCounterVec("shortener_links_created_total",
+		"Number of created short links.")
+	Redirects = Default.NewCounterVec("shortener_redirects_total",
+		"Number of redirects to original URLs.")
+	RateLimitedRequests = Default.NewCounterVec("shortener_rate_limited_requests_total",
+		"Number of requests rejected by rate limits.", "class")
+	ClickEventsDropped = Default.NewCounterVec("shortener_click_events_dropped_total",
+		"Number of click events dropped because the analytics buffer was full.")
+	// action="create" — отказ в сокращении, action="redirect" — показ предупреждения вместо перехода,
+	// action="qr" — отказ в QR-коде
+	PolicyBlocks = Default.NewCounterVec("shortener_policy_blocks_total",
+		"Number of links blocked by domain policy.", "action")
+)
+
+// Handler отдаёт метрики сервиса
+func Handler() http.HandlerFunc {
+	return Default.Handler()
+}
@@ -1,8 +1,12 @@
 package middleware
 import (
 	"net/http"
+	"strconv"
 	"time"
+	"github.com/go-chi/chi/v5"
+	"github.com/hessayon/ya_practicum_go/internal/auth"
 	"github.com/hessayon/ya_practicum_go/internal/compressing"
+	"github.com/hessayon/ya_practicum_go/internal/metrics"
 	"go.uber.org/zap"
 )
 
@@ -42,7 +46,9 @@ func GzipCompress(h http.HandlerFunc) http.HandlerFunc {
 		// проверяем, что клиент умеет получать от сервера сжатые данные в формате gzip
 		supportsGzip := compressing.CheckSupportOfGzip(r.Header.Values("Accept-Encoding"))
 
-		if compressing.IsGzipContentType(r.Header.Get("Content-Type")) && supportsGzip{
+		// сжимать или нет, решается по Content-Type ответа, когда хендлер начнёт его писать
+		w.Header().Add("Vary", "Accept-Encoding")
+		if supportsGzip{
 			compressWr := compressing.NewCompressWriter(w)
 			currentWriter = compressWr
 			defer compressWr.Close()
@@ -61,6 +67,7 @@ func GzipCompress(h http.HandlerFunc) http.HandlerFunc {
 		}
 
 		h(currentWriter, r)
+		metrics.GzipResponses.Inc(strconv.FormatBool(w.Header().Get("Content-Encoding") == "gzip"))
 	}
 }
 
@@ -80,6 +87,7 @@ func RequestLogger(log *zap.Logger, h http.HandlerFunc) http.HandlerFunc {
 
 		h(&lw, r) // обслуживание оригинального запроса
 		duration := time.Since(start)
+		observeRequest(r, responseData, duration)
 		log.Info("got incoming HTTP request",
 			zap.String("uri", r.RequestURI),
 			zap.String("method", r.Method),
@@ -93,4 +101,82 @@ func RequestLogger(log *zap.Logger, h http.HandlerFunc) http.HandlerFunc {
 			zap.Strings("accept_encoding", r.Header.Values("Accept-Encoding")),
 		)
 	})
-}
\ No newline at end of file
+}
+
+// observeRequest записывает метрики запроса с меткой шаблона маршрута, а не конкретного пути,
+// чтобы число серий не зависело от количества ссылок
+func observeRequest(r *http.Request, responseData *ResponseData, duration time.Duration) {
+	route := "unknown"
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && len(rctx.RoutePatterns) > 0 {
+		route = rctx.RoutePattern()
+		if route == "" {
+			// chi обрезает завершающий слеш, и от корневого маршрута остаётся пустая строка
+			route = "/"
+		}
+	}
+	status := responseData.Status
+	if status == 0 {
+		// обработчик не вызвал WriteHeader явно
+		status = http.StatusOK
+	}
+	statusLabel := strconv.Itoa(status)
+	metrics.HTTPRequests.Inc(r.Method, route, statusLabel)
+	metrics.HTTPRequestDuration.Observe(duration.Seconds(), r.Method, route, statusLabel)
+	metrics.HTTPResponseSize.Observe(float64(responseData.Size), r.Method, route, statusLabel)
+}
+
+// Authenticate выдаёт пользователю подписанную куку с ID, если её нет или она невалидна,
+// и кладёт ID пользователя в контекст запроса.
+func Authenticate(key []byte, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(auth.CookieName); err == nil {
+			if userID, err := auth.ParseToken(cookie.Value, key); err == nil {
+				h(w, r.WithContext(auth.WithUserID(r.Context(), userID)))
+				return
+			}
+		}
+		userID, err := auth.NewUserID()
+		if err != nil {
+			http.Error(w, "service internal error", http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     auth.CookieName,
+			Value:    auth.BuildToken(userID, key),
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+		})
+		h(w, r.WithContext(auth.WithUserID(r.Context(), userID)))
+	}
+}
+
+// Identify кладёт в контекст ID пользователя из валидной куки, но новую куку не выдаёт:
+// для страниц, где пользователь может быть и анонимным.
+func Identify(key []byte, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(auth.CookieName); err == nil {
+			if userID, err := auth.ParseToken(cookie.Value, key); err == nil {
+				r = r.WithContext(auth.WithUserID(r.Context(), userID))
+			}
+		}
+		h(w, r)
+	}
+}
+
+// RequireAuth пропускает только запросы с валидной кукой, иначе отвечает 401.
+func RequireAuth(key []byte, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(auth.CookieName)
+		if err != nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		userID, err := auth.ParseToken(cookie.Value, key)
+		if err != nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r.WithContext(auth.WithUserID(r.Context(), userID)))
+	}
+}
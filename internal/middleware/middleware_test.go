@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGzipCompress(t *testing.T) {
+	tests := []struct {
+		name           string
+		contentType    string
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{name: "html with charset", contentType: "text/html; charset=utf-8", acceptEncoding: "gzip", wantGzip: true},
+		{name: "json", contentType: "application/json", acceptEncoding: "gzip, deflate", wantGzip: true},
+		{name: "image is not compressed", contentType: "image/png", acceptEncoding: "gzip"},
+		{name: "client without gzip", contentType: "text/html; charset=utf-8"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// GET без тела: сжатие не должно зависеть от Content-Type запроса
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.acceptEncoding != "" {
+				request.Header.Set("Accept-Encoding", test.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			GzipCompress(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				w.Write([]byte("<p>hello</p>"))
+			})(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+			body := io.Reader(res.Body)
+			if test.wantGzip {
+				require.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+				zr, err := gzip.NewReader(res.Body)
+				require.NoError(t, err)
+				body = zr
+			} else {
+				assert.Empty(t, res.Header.Get("Content-Encoding"))
+			}
+			data, err := io.ReadAll(body)
+			require.NoError(t, err)
+			assert.Equal(t, "<p>hello</p>", string(data))
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hessayon/ya_practicum_go/internal/auth"
+	"github.com/hessayon/ya_practicum_go/internal/metrics"
+	"github.com/hessayon/ya_practicum_go/internal/ratelimit"
+)
+
+// ClientKey возвращает ключи, по которым считается лимит запросов клиента;
+// запрос проходит, только если его пропускают бакеты всех ключей
+type ClientKey func(r *http.Request) []string
+
+// ClientIPKey считает лимит по IP клиента. X-Forwarded-For учитывается, только если запрос
+// пришёл от доверенного прокси: адреса в заголовке разбираются справа налево, и клиентом
+// считается первый адрес не из trustedProxies
+func ClientIPKey(trustedProxies []netip.Prefix) ClientKey {
+	return func(r *http.Request) []string {
+		return []string{"ip:" + ClientIP(r, trustedProxies)}
+	}
+}
+
+// UserKey считает лимит по ID пользователя из подписанной куки вдобавок к лимиту fallback.
+// Куку сервер выдаёт любому новому клиенту, поэтому одного лимита по пользователю мало:
+// набрав кук и перебирая их, клиент получал бы новый бакет на каждый запрос
+func UserKey(key []byte, fallback ClientKey) ClientKey {
+	return func(r *http.Request) []string {
+		if cookie, err := r.Cookie(auth.CookieName); err == nil {
+			if userID, err := auth.ParseToken(cookie.Value, key); err == nil {
+				return append([]string{"user:" + userID}, fallback(r)...)
+			}
+		}
+		return fallback(r)
+	}
+}
+
+// ClientIP определяет адрес клиента с учётом доверенных прокси
+func ClientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	addr, err := netip.ParseAddr(remote)
+	if err != nil || !isTrusted(addr, trustedProxies) {
+		// за unix-сокетом RemoteAddr не адрес; такой сокет стоит за прокси, и он должен быть доверенным
+		return remote
+	}
+	client := remote
+	forwarded := r.Header.Values("X-Forwarded-For")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hops := strings.Split(forwarded[i], ",")
+		for j := len(hops) - 1; j >= 0; j-- {
+			hop := strings.TrimSpace(hops[j])
+			addr, err := netip.ParseAddr(hop)
+			if err != nil {
+				// подделанный или испорченный заголовок: дальше него цепочке не верим
+				return client
+			}
+			client = addr.Unmap().String()
+			if !isTrusted(addr, trustedProxies) {
+				return client
+			}
+		}
+	}
+	return client
+}
+
+func isTrusted(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimit отвечает 429, когда клиент исчерпал лимит запросов класса маршрутов class.
+// Состояние лимита сообщается заголовками RateLimit-Limit, RateLimit-Remaining и RateLimit-Reset
+// по самому строгому из бакетов клиента; без limiter запросы пропускаются как есть
+func RateLimit(class string, limiter *ratelimit.Limiter, key ClientKey, h http.HandlerFunc) http.HandlerFunc {
+	if limiter == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		// токены забираются, только если запрос пропускают все бакеты клиента
+		result := limiter.AllowAll(key(r))
+		header := w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+		if !result.Allowed {
+			metrics.RateLimitedRequests.Inc(class)
+			header.Set("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// seconds округляет вверх: клиент, подождавший указанное время, должен пройти
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/hessayon/ya_practicum_go/internal/auth"
+	"github.com/hessayon/ya_practicum_go/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "203.0.113.5:4000",
+			want:       "203.0.113.5",
+		},
+		{
+			name:       "header from untrusted client is ignored",
+			remoteAddr: "203.0.113.5:4000",
+			forwarded:  []string{"198.51.100.1"},
+			want:       "203.0.113.5",
+		},
+		{
+			name:       "client behind trusted proxy",
+			remoteAddr: "10.0.0.2:4000",
+			forwarded:  []string{"198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "spoofed hops before real client are skipped",
+			remoteAddr: "10.0.0.2:4000",
+			forwarded:  []string{"1.1.1.1, 198.51.100.1", "10.0.0.3"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "garbage in header stops the chain",
+			remoteAddr: "[::1]:4000",
+			forwarded:  []string{"198.51.100.1, bogus"},
+			want:       "::1",
+		},
+		{
+			name:       "only trusted hops",
+			remoteAddr: "10.0.0.2:4000",
+			forwarded:  []string{"10.0.0.7"},
+			want:       "10.0.0.7",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			for _, value := range test.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			assert.Equal(t, test.want, ClientIP(r, trusted))
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	key := []byte("secret")
+	limiter := ratelimit.New(ratelimit.Limit{Requests: 2, Period: time.Minute}, 100)
+	h := RateLimit("create", limiter, UserKey(key, ClientIPKey(nil)), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	request := func(userID, remoteAddr string) *http.Response {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.RemoteAddr = remoteAddr
+		if userID != "" {
+			r.AddCookie(&http.Cookie{Name: auth.CookieName, Value: auth.BuildToken(userID, key)})
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Result()
+	}
+
+	res := request("u1", "203.0.113.1:4000")
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "2", res.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "1", res.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, http.StatusCreated, request("u1", "203.0.113.2:4000").StatusCode)
+
+	// отказ по бакету пользователя не тратит бакет адреса
+	res = request("u1", "203.0.113.3:4000")
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, "0", res.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", res.Header.Get("Retry-After"))
+	assert.Equal(t, "60", res.Header.Get("RateLimit-Reset"))
+
+	// у другого пользователя свой лимит, а без куки лимит считается по адресу
+	assert.Equal(t, http.StatusCreated, request("u2", "203.0.113.3:4000").StatusCode)
+	assert.Equal(t, http.StatusCreated, request("", "203.0.113.3:4000").StatusCode)
+	// новые куки не дают новых бакетов: лимит адреса действует и для пользователей
+	assert.Equal(t, http.StatusTooManyRequests, request("u3", "203.0.113.3:4000").StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, request("u4", "203.0.113.3:4000").StatusCode)
+	// а отказ по бакету адреса не тратит бакет пользователя: с другого адреса у u3 весь лимит
+	assert.Equal(t, http.StatusTooManyRequests, request("u3", "203.0.113.3:4000").StatusCode)
+	res = request("u3", "203.0.113.4:4000")
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "1", res.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, http.StatusCreated, request("u3", "203.0.113.5:4000").StatusCode)
+}
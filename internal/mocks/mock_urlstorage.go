@@ -5,7 +5,9 @@
 package mocks
 
 import (
+	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	storage "github.com/hessayon/ya_practicum_go/internal/storage"
@@ -34,6 +36,20 @@ func (m *MockURLStorage) EXPECT() *MockURLStorageMockRecorder {
 	return m.recorder
 }
 
+// Check mocks base method.
+func (m *MockURLStorage) Check(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Check", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Check indicates an expected call of Check.
+func (mr *MockURLStorageMockRecorder) Check(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Check", reflect.TypeOf((*MockURLStorage)(nil).Check), arg0)
+}
+
 // Close mocks base method.
 func (m *MockURLStorage) Close() {
 	m.ctrl.T.Helper()
@@ -46,60 +62,120 @@ func (mr *MockURLStorageMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockURLStorage)(nil).Close))
 }
 
+// DeleteURLs mocks base method.
+func (m *MockURLStorage) DeleteURLs(arg0 context.Context, arg1 []storage.DeleteTask) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteURLs", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteURLs indicates an expected call of DeleteURLs.
+func (mr *MockURLStorageMockRecorder) DeleteURLs(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteURLs", reflect.TypeOf((*MockURLStorage)(nil).DeleteURLs), arg0, arg1)
+}
+
 // GetOriginalURL mocks base method.
-func (m *MockURLStorage) GetOriginalURL(arg0 string) (string, bool) {
+func (m *MockURLStorage) GetOriginalURL(arg0 context.Context, arg1 string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetOriginalURL", arg0)
+	ret := m.ctrl.Call(m, "GetOriginalURL", arg0, arg1)
 	ret0, _ := ret[0].(string)
-	ret1, _ := ret[1].(bool)
+	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetOriginalURL indicates an expected call of GetOriginalURL.
-func (mr *MockURLStorageMockRecorder) GetOriginalURL(arg0 interface{}) *gomock.Call {
+func (mr *MockURLStorageMockRecorder) GetOriginalURL(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOriginalURL", reflect.TypeOf((*MockURLStorage)(nil).GetOriginalURL), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOriginalURL", reflect.TypeOf((*MockURLStorage)(nil).GetOriginalURL), arg0, arg1)
 }
 
 // GetShortURL mocks base method.
-func (m *MockURLStorage) GetShortURL(arg0 string) (string, bool) {
+func (m *MockURLStorage) GetShortURL(arg0 context.Context, arg1 string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetShortURL", arg0)
+	ret := m.ctrl.Call(m, "GetShortURL", arg0, arg1)
 	ret0, _ := ret[0].(string)
-	ret1, _ := ret[1].(bool)
+	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetShortURL indicates an expected call of GetShortURL.
-func (mr *MockURLStorageMockRecorder) GetShortURL(arg0 interface{}) *gomock.Call {
+func (mr *MockURLStorageMockRecorder) GetShortURL(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetShortURL", reflect.TypeOf((*MockURLStorage)(nil).GetShortURL), arg0, arg1)
+}
+
+// GetURLData mocks base method.
+func (m *MockURLStorage) GetURLData(arg0 context.Context, arg1 string) (*storage.URLData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetURLData", arg0, arg1)
+	ret0, _ := ret[0].(*storage.URLData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetURLData indicates an expected call of GetURLData.
+func (mr *MockURLStorageMockRecorder) GetURLData(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetURLData", reflect.TypeOf((*MockURLStorage)(nil).GetURLData), arg0, arg1)
+}
+
+// GetURLsByUser mocks base method.
+func (m *MockURLStorage) GetURLsByUser(arg0 context.Context, arg1 string) ([]*storage.URLData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetURLsByUser", arg0, arg1)
+	ret0, _ := ret[0].([]*storage.URLData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetURLsByUser indicates an expected call of GetURLsByUser.
+func (mr *MockURLStorageMockRecorder) GetURLsByUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetURLsByUser", reflect.TypeOf((*MockURLStorage)(nil).GetURLsByUser), arg0, arg1)
+}
+
+// PurgeExpired mocks base method.
+func (m *MockURLStorage) PurgeExpired(arg0 context.Context, arg1 time.Duration) (int, []string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeExpired", arg0, arg1)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].([]string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// PurgeExpired indicates an expected call of PurgeExpired.
+func (mr *MockURLStorageMockRecorder) PurgeExpired(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetShortURL", reflect.TypeOf((*MockURLStorage)(nil).GetShortURL), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeExpired", reflect.TypeOf((*MockURLStorage)(nil).PurgeExpired), arg0, arg1)
 }
 
 // Save mocks base method.
-func (m *MockURLStorage) Save(arg0 *storage.URLData) error {
+func (m *MockURLStorage) Save(arg0 context.Context, arg1 *storage.URLData) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Save", arg0)
+	ret := m.ctrl.Call(m, "Save", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Save indicates an expected call of Save.
-func (mr *MockURLStorageMockRecorder) Save(arg0 interface{}) *gomock.Call {
+func (mr *MockURLStorageMockRecorder) Save(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockURLStorage)(nil).Save), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockURLStorage)(nil).Save), arg0, arg1)
 }
 
 // SaveBatch mocks base method.
-func (m *MockURLStorage) SaveBatch(arg0 []*storage.URLData) error {
+func (m *MockURLStorage) SaveBatch(arg0 context.Context, arg1 []*storage.URLData) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SaveBatch", arg0)
+	ret := m.ctrl.Call(m, "SaveBatch", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SaveBatch indicates an expected call of SaveBatch.
-func (mr *MockURLStorageMockRecorder) SaveBatch(arg0 interface{}) *gomock.Call {
+func (mr *MockURLStorageMockRecorder) SaveBatch(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBatch", reflect.TypeOf((*MockURLStorage)(nil).SaveBatch), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBatch", reflect.TypeOf((*MockURLStorage)(nil).SaveBatch), arg0, arg1)
 }
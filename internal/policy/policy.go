@@ -0,0 +1,264 @@
+// Package policy решает, можно ли сокращать ссылку и переходить по ней: списки разрешённых
+// и запрещённых доменов и подсетей и список заблокированных ссылок по их хешам
+package policy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hessayon/ya_practicum_go/internal/urlnorm"
+	"golang.org/x/net/idna"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrBlocked = errors.New("url is blocked by policy")
+
+// File — содержимое файла политики (JSON или YAML, формат по расширению).
+// Элементы allow и deny: домен (example.com), любой его поддомен (*.example.com),
+// IP-адрес или подсеть (203.0.113.0/24) для ссылок с IP вместо домена.
+// Если allow не пуст, разрешены только подходящие под него хосты; deny важнее allow.
+// BlockedURLs — SHA-256 в hex от нормализованных ссылок, см. HashURL
+type File struct {
+	Allow       []string `json:"allow" yaml:"allow"`
+	Deny        []string `json:"deny" yaml:"deny"`
+	BlockedURLs []string `json:"blocked_urls" yaml:"blocked_urls"`
+}
+
+// HashURL возвращает хеш ссылки для списка blocked_urls
+func HashURL(normalizedURL string) string {
+	sum := sha256.Sum256([]byte(normalizedURL))
+	return hex.EncodeToString(sum[:])
+}
+
+type hostList struct {
+	domains  map[string]bool
+	suffixes []string
+	prefixes []netip.Prefix
+}
+
+func (list *hostList) isEmpty() bool {
+	return len(list.domains) == 0 && len(list.suffixes) == 0 && len(list.prefixes) == 0
+}
+
+func (list *hostList) match(host string) bool {
+	if addr, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil {
+		addr = addr.Unmap()
+		for _, prefix := range list.prefixes {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+	if list.domains[host] {
+		return true
+	}
+	for _, suffix := range list.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHostList(entries []string) (*hostList, error) {
+	list := &hostList{domains: make(map[string]bool)}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case strings.Contains(entry, "/"):
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid subnet %q: %w", entry, err)
+			}
+			list.prefixes = append(list.prefixes, prefix.Masked())
+		case strings.HasPrefix(entry, "*."):
+			domain, err := idna.Lookup.ToASCII(strings.TrimSuffix(entry[2:], "."))
+			if err != nil || domain == "" {
+				return nil, fmt.Errorf("invalid wildcard domain %q", entry)
+			}
+			list.suffixes = append(list.suffixes, "."+domain)
+		default:
+			if addr, err := netip.ParseAddr(entry); err == nil {
+				addr = addr.Unmap()
+				list.prefixes = append(list.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+				continue
+			}
+			domain, err := idna.Lookup.ToASCII(strings.TrimSuffix(entry, "."))
+			if err != nil || domain == "" {
+				return nil, fmt.Errorf("invalid domain %q", entry)
+			}
+			list.domains[domain] = true
+		}
+	}
+	return list, nil
+}
+
+type rules struct {
+	allow   *hostList
+	deny    *hostList
+	blocked map[string]bool
+}
+
+func compile(file *File) (*rules, error) {
+	allow, err := parseHostList(file.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("allow: %w", err)
+	}
+	deny, err := parseHostList(file.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("deny: %w", err)
+	}
+	blocked := make(map[string]bool, len(file.BlockedURLs))
+	for _, hash := range file.BlockedURLs {
+		hash = strings.ToLower(strings.TrimSpace(hash))
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("blocked_urls: %q is not a sha256 hex digest", hash)
+		}
+		blocked[hash] = true
+	}
+	return &rules{allow: allow, deny: deny, blocked: blocked}, nil
+}
+
+// Engine применяет правила из файла и перечитывает его, когда файл меняется.
+// Нулевой *Engine разрешает всё
+type Engine struct {
+	filename string
+	rules    atomic.Pointer[rules]
+	// modTime и size последней загруженной версии файла; меняются только при загрузке
+	modTime time.Time
+	size    int64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Load читает файл политики и, если reloadInterval > 0, раз в reloadInterval проверяет,
+// не изменился ли он. Ошибка в изменённом файле пишется в лог, и действуют прежние правила
+func Load(filename string, reloadInterval time.Duration) (*Engine, error) {
+	engine := &Engine{filename: filename}
+	if _, err := engine.reload(); err != nil {
+		return nil, err
+	}
+	if reloadInterval > 0 {
+		engine.stop = make(chan struct{})
+		engine.done = make(chan struct{})
+		go engine.watch(reloadInterval)
+	}
+	return engine, nil
+}
+
+func (engine *Engine) watch(interval time.Duration) {
+	defer close(engine.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-engine.stop:
+			return
+		case <-ticker.C:
+			reloaded, err := engine.reload()
+			if err != nil {
+				log.Printf("Error in reloading policy file %s: %s", engine.filename, err.Error())
+			} else if reloaded {
+				log.Printf("Policy file %s is reloaded", engine.filename)
+			}
+		}
+	}
+}
+
+// reload загружает файл, если он изменился с прошлой загрузки
+func (engine *Engine) reload() (bool, error) {
+	info, err := os.Stat(engine.filename)
+	if err != nil {
+		return false, err
+	}
+	if engine.rules.Load() != nil && info.ModTime().Equal(engine.modTime) && info.Size() == engine.size {
+		return false, nil
+	}
+	data, err := os.ReadFile(engine.filename)
+	if err != nil {
+		return false, err
+	}
+	file, err := decode(engine.filename, data)
+	if err != nil {
+		return false, err
+	}
+	compiled, err := compile(file)
+	if err != nil {
+		return false, err
+	}
+	engine.rules.Store(compiled)
+	engine.modTime, engine.size = info.ModTime(), info.Size()
+	return true, nil
+}
+
+func decode(filename string, data []byte) (*File, error) {
+	file := &File{}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(file); err != nil {
+			return nil, fmt.Errorf("policy file: %w", err)
+		}
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(file); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("policy file: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("policy file: unsupported format %q, expected .json, .yaml or .yml", filepath.Ext(filename))
+	}
+	return file, nil
+}
+
+// Check возвращает ошибку ErrBlocked с причиной, если ссылку нельзя сокращать или открывать.
+// Ссылка нормализуется заново: в хранилище могут быть ссылки, сохранённые до появления нормализации
+func (engine *Engine) Check(rawURL string) error {
+	if engine == nil {
+		return nil
+	}
+	current := engine.rules.Load()
+	normalized, err := urlnorm.Normalize(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlocked, err.Error())
+	}
+	if current.blocked[HashURL(normalized)] {
+		return fmt.Errorf("%w: url is in blocklist", ErrBlocked)
+	}
+	u, err := url.Parse(normalized)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlocked, err.Error())
+	}
+	host := u.Hostname()
+	if current.deny.match(host) {
+		return fmt.Errorf("%w: host %s is denied", ErrBlocked, host)
+	}
+	if !current.allow.isEmpty() && !current.allow.match(host) {
+		return fmt.Errorf("%w: host %s is not allowed", ErrBlocked, host)
+	}
+	return nil
+}
+
+// Close останавливает перечитывание файла
+func (engine *Engine) Close() {
+	if engine == nil || engine.stop == nil {
+		return
+	}
+	close(engine.stop)
+	<-engine.done
+}
@@ -0,0 +1,123 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePolicy(t *testing.T, filename, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0o600))
+}
+
+func TestEngineCheck(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "policy.yaml")
+	writePolicy(t, filename, `
+deny:
+  - evil.com
+  - "*.phish.example"
+  - 203.0.113.0/24
+  - пример.рф
+blocked_urls:
+  - `+HashURL("https://good.com/malware.exe")+`
+`)
+	engine, err := Load(filename, 0)
+	require.NoError(t, err)
+
+	tests := []struct {
+		url     string
+		blocked bool
+	}{
+		{url: "https://good.com/page"},
+		{url: "https://evil.com/login", blocked: true},
+		{url: "https://EVIL.com:443/login#x", blocked: true},
+		{url: "https://notevil.com/"},
+		{url: "https://login.phish.example/", blocked: true},
+		{url: "https://a.b.phish.example/", blocked: true},
+		{url: "https://phish.example/"},
+		{url: "http://203.0.113.7/", blocked: true},
+		{url: "http://203.0.114.7/"},
+		{url: "https://xn--e1afmkfd.xn--p1ai/", blocked: true},
+		{url: "https://good.com/malware.exe", blocked: true},
+		{url: "HTTPS://Good.com/malware.exe#download", blocked: true},
+		{url: "javascript:alert(1)", blocked: true},
+	}
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			err := engine.Check(test.url)
+			if test.blocked {
+				assert.ErrorIs(t, err, ErrBlocked)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestEngineAllowList(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "policy.json")
+	writePolicy(t, filename, `{"allow": ["example.com", "*.example.com", "10.0.0.0/8"], "deny": ["bad.example.com"]}`)
+	engine, err := Load(filename, 0)
+	require.NoError(t, err)
+
+	assert.NoError(t, engine.Check("https://example.com/"))
+	assert.NoError(t, engine.Check("https://docs.example.com/"))
+	assert.NoError(t, engine.Check("http://10.1.2.3/"))
+	assert.ErrorIs(t, engine.Check("https://other.com/"), ErrBlocked)
+	// deny важнее allow
+	err = engine.Check("https://bad.example.com/")
+	assert.ErrorIs(t, err, ErrBlocked)
+	assert.Contains(t, err.Error(), "denied")
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{name: "unknown key", file: "policy.yaml", content: "block: [a.com]", wantErr: "field block not found"},
+		{name: "bad subnet", file: "policy.json", content: `{"deny": ["10.0.0.0/99"]}`, wantErr: "deny: invalid subnet"},
+		{name: "bad hash", file: "policy.json", content: `{"blocked_urls": ["abc"]}`, wantErr: "not a sha256"},
+		{name: "bad format", file: "policy.txt", content: "", wantErr: "unsupported format"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), test.file)
+			writePolicy(t, filename, test.content)
+			_, err := Load(filename, 0)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.wantErr)
+		})
+	}
+}
+
+func TestEngineReload(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "policy.yaml")
+	writePolicy(t, filename, "deny: [evil.com]\n")
+	engine, err := Load(filename, 10*time.Millisecond)
+	require.NoError(t, err)
+	defer engine.Close()
+	require.NoError(t, engine.Check("https://other.com/"))
+
+	writePolicy(t, filename, "deny: [evil.com, other.com]\n")
+	assert.Eventually(t, func() bool { return engine.Check("https://other.com/") != nil }, time.Second, 10*time.Millisecond)
+
+	// испорченный файл не сбрасывает действующие правила
+	writePolicy(t, filename, "deny: [\n")
+	time.Sleep(50 * time.Millisecond)
+	assert.ErrorIs(t, engine.Check("https://other.com/"), ErrBlocked)
+	assert.ErrorIs(t, engine.Check("https://evil.com/"), ErrBlocked)
+}
+
+func TestNilEngineAllowsEverything(t *testing.T) {
+	var engine *Engine
+	assert.NoError(t, engine.Check("https://evil.com/"))
+	engine.Close()
+}
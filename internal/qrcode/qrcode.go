@@ -0,0 +1,500 @@
+// Package qrcode — кодировщик QR-кодов (ISO/IEC 18004) в байтовом режиме, версии 1–40
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Level — уровень коррекции ошибок: какую долю символа можно повредить без потери данных
+type Level int
+
+const (
+	Low      Level = iota // ~7%
+	Medium                // ~15%
+	Quartile              // ~25%
+	High                  // ~30%
+)
+
+const (
+	minVersion = 1
+	maxVersion = 40
+)
+
+var ErrTooLong = errors.New("data is too long for qr code")
+
+// ParseLevel разбирает уровень коррекции в виде буквы L, M, Q или H
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "L":
+		return Low, nil
+	case "M":
+		return Medium, nil
+	case "Q":
+		return Quartile, nil
+	case "H":
+		return High, nil
+	default:
+		return 0, fmt.Errorf("unknown error correction level %q, expected L, M, Q or H", s)
+	}
+}
+
+func (level Level) String() string {
+	return [...]string{"L", "M", "Q", "H"}[level]
+}
+
+// formatBits — код уровня в информации о формате, порядок в стандарте отличается от L, M, Q, H
+func (level Level) formatBits() int {
+	return [...]int{1, 0, 3, 2}[level]
+}
+
+// eccCodewordsPerBlock и numBlocks — таблицы 9 стандарта по уровням и версиям, нулевая версия не используется
+var eccCodewordsPerBlock = [4][maxVersion + 1]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var numBlocks = [4][maxVersion + 1]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// Code — готовый символ: квадрат Size×Size модулей без свободной зоны вокруг
+type Code struct {
+	Size    int
+	Version int
+	Level   Level
+	Mask    int
+
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// Black сообщает, тёмный ли модуль в столбце x и строке y
+func (code *Code) Black(x, y int) bool {
+	return code.modules[y][x]
+}
+
+// Encode кодирует data минимальной подходящей версией с заданным уровнем коррекции
+func Encode(data []byte, level Level) (*Code, error) {
+	if level < Low || level > High {
+		return nil, fmt.Errorf("unknown error correction level %d", level)
+	}
+	version := minVersion
+	for ; version <= maxVersion; version++ {
+		if dataBits(len(data), version) <= numDataCodewords(version, level)*8 {
+			break
+		}
+	}
+	if version > maxVersion {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLong, len(data))
+	}
+
+	code := newCode(version, level)
+	code.drawFunctionPatterns()
+	code.drawCodewords(code.addECCAndInterleave(code.dataCodewords(data)))
+	code.applyBestMask()
+	return code, nil
+}
+
+func newCode(version int, level Level) *Code {
+	size := version*4 + 17
+	code := &Code{Size: size, Version: version, Level: level}
+	code.modules = make([][]bool, size)
+	code.isFunction = make([][]bool, size)
+	for y := range code.modules {
+		code.modules[y] = make([]bool, size)
+		code.isFunction[y] = make([]bool, size)
+	}
+	return code
+}
+
+// countBits — длина поля с количеством байт, зависит от версии
+func countBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+func dataBits(length, version int) int {
+	return 4 + countBits(version) + length*8
+}
+
+// numRawDataModules — число модулей под данные и коррекцию после вычета служебных узоров
+func numRawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+func numDataCodewords(version int, level Level) int {
+	return numRawDataModules(version)/8 - eccCodewordsPerBlock[level][version]*numBlocks[level][version]
+}
+
+// dataCodewords собирает поток данных: режим, длина, байты, терминатор и байты-заполнители
+func (code *Code) dataCodewords(data []byte) []byte {
+	capacity := numDataCodewords(code.Version, code.Level) * 8
+	var bits bitBuffer
+	bits.append(0b0100, 4)
+	bits.append(len(data), countBits(code.Version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	bits.append(0, min(4, capacity-bits.len()))
+	bits.append(0, (8-bits.len()%8)%8)
+	for pad := 0xEC; bits.len() < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	return bits.bytes()
+}
+
+type bitBuffer []bool
+
+func (bits *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*bits = append(*bits, (value>>i)&1 == 1)
+	}
+}
+
+func (bits bitBuffer) len() int {
+	return len(bits)
+}
+
+func (bits bitBuffer) bytes() []byte {
+	result := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			result[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return result
+}
+
+// addECCAndInterleave делит данные на блоки, дописывает к каждому коды Рида — Соломона
+// и перемежает блоки побайтно; короткие блоки идут первыми и на байт короче длинных
+func (code *Code) addECCAndInterleave(data []byte) []byte {
+	blocksCount := numBlocks[code.Level][code.Version]
+	eccLen := eccCodewordsPerBlock[code.Level][code.Version]
+	rawCodewords := numRawDataModules(code.Version) / 8
+	numShortBlocks := blocksCount - rawCodewords%blocksCount
+	shortBlockLen := rawCodewords / blocksCount
+
+	divisor := reedSolomonDivisor(eccLen)
+	blocks := make([][]byte, blocksCount)
+	for i, k := 0, 0; i < blocksCount; i++ {
+		dataLen := shortBlockLen - eccLen
+		if i >= numShortBlocks {
+			dataLen++
+		}
+		block := append([]byte(nil), data[k:k+dataLen]...)
+		k += dataLen
+		block = append(block, reedSolomonRemainder(block, divisor)...)
+		blocks[i] = block
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := 0; i <= shortBlockLen; i++ {
+		for j, block := range blocks {
+			// в коротких блоках нет последнего байта данных, пропускаем эту позицию
+			index := i
+			if j < numShortBlocks {
+				if i == shortBlockLen-eccLen {
+					continue
+				}
+				if i > shortBlockLen-eccLen {
+					index--
+				}
+			}
+			if index < len(block) {
+				result = append(result, block[index])
+			}
+		}
+	}
+	return result
+}
+
+func (code *Code) set(x, y int, black bool) {
+	code.modules[y][x] = black
+	code.isFunction[y][x] = true
+}
+
+func (code *Code) drawFunctionPatterns() {
+	for i := 0; i < code.Size; i++ {
+		code.set(6, i, i%2 == 0)
+		code.set(i, 6, i%2 == 0)
+	}
+	code.drawFinderPattern(3, 3)
+	code.drawFinderPattern(code.Size-4, 3)
+	code.drawFinderPattern(3, code.Size-4)
+
+	positions := alignmentPositions(code.Version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// углы с поисковыми узорами пропускаются
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			code.drawAlignmentPattern(x, y)
+		}
+	}
+	// резервируем место под информацию о формате, настоящие биты пишутся после выбора маски
+	code.drawFormatBits(0)
+	code.drawVersion()
+}
+
+// drawFinderPattern рисует поисковый узор 7×7 с центром в (x, y) и светлую рамку вокруг
+func (code *Code) drawFinderPattern(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= code.Size || yy < 0 || yy >= code.Size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			code.set(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (code *Code) drawAlignmentPattern(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			code.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// alignmentPositions — координаты центров выравнивающих узоров по каждой оси
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := 26
+	if version != 32 {
+		step = (version*4 + numAlign*2 + 1) / (numAlign*2 - 2) * 2
+	}
+	result := make([]int, numAlign)
+	result[0] = 6
+	for i, pos := numAlign-1, version*4+10; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+// formatBits — 15 бит информации о формате: уровень, маска, код БЧХ и маскирующая последовательность
+func formatBits(level Level, mask int) int {
+	data := level.formatBits()<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+func (code *Code) drawFormatBits(mask int) {
+	bits := formatBits(code.Level, mask)
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+	// первая копия — вокруг левого верхнего поискового узора
+	for i := 0; i <= 5; i++ {
+		code.set(8, i, bit(i))
+	}
+	code.set(8, 7, bit(6))
+	code.set(8, 8, bit(7))
+	code.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		code.set(14-i, 8, bit(i))
+	}
+	// вторая копия — у правого верхнего и левого нижнего
+	for i := 0; i < 8; i++ {
+		code.set(code.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		code.set(8, code.Size-15+i, bit(i))
+	}
+	// тёмный модуль есть всегда
+	code.set(8, code.Size-8, true)
+}
+
+// versionBits — 18 бит информации о версии, нужны начиная с седьмой версии
+func versionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	return version<<12 | rem
+}
+
+func (code *Code) drawVersion() {
+	if code.Version < 7 {
+		return
+	}
+	bits := versionBits(code.Version)
+	for i := 0; i < 18; i++ {
+		bit := (bits>>i)&1 == 1
+		a, b := code.Size-11+i%3, i/3
+		code.set(a, b, bit)
+		code.set(b, a, bit)
+	}
+}
+
+// drawCodewords раскладывает биты змейкой по парам столбцов снизу вверх и обратно,
+// обходя служебные модули; столбец 6 занят синхронизирующей линией
+func (code *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := code.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < code.Size; vert++ {
+			y := vert
+			if upward {
+				y = code.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if code.isFunction[y][x] || i >= len(data)*8 {
+					continue
+				}
+				code.modules[y][x] = (data[i/8]>>(7-i%8))&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+func maskBit(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// applyMask инвертирует модули данных по маске; повторный вызов снимает маску
+func (code *Code) applyMask(mask int) {
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if !code.isFunction[y][x] && maskBit(mask, x, y) {
+				code.modules[y][x] = !code.modules[y][x]
+			}
+		}
+	}
+}
+
+// applyBestMask перебирает все восемь масок и оставляет ту, у которой меньше штраф
+func (code *Code) applyBestMask() {
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		code.applyMask(mask)
+		code.drawFormatBits(mask)
+		if penalty := code.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		code.applyMask(mask)
+	}
+	code.Mask = best
+	code.applyMask(best)
+	code.drawFormatBits(best)
+}
+
+// finderLike — участок 1:1:3:1:1 с четырьмя светлыми модулями с одной из сторон
+var finderLike = [2][11]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+// penalty считает штраф по четырём правилам раздела 7.8.3 стандарта
+func (code *Code) penalty() int {
+	size := code.Size
+	at := func(x, y int, transposed bool) bool {
+		if transposed {
+			return code.modules[x][y]
+		}
+		return code.modules[y][x]
+	}
+	result := 0
+	for _, transposed := range []bool{false, true} {
+		for y := 0; y < size; y++ {
+			// подряд идущие модули одного цвета
+			run := 1
+			for x := 1; x <= size; x++ {
+				if x < size && at(x, y, transposed) == at(x-1, y, transposed) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					result += 3 + run - 5
+				}
+				run = 1
+			}
+			// узоры, похожие на поисковые
+			for x := 0; x+11 <= size; x++ {
+				for _, pattern := range finderLike {
+					matched := true
+					for k, black := range pattern {
+						if at(x+k, y, transposed) != black {
+							matched = false
+							break
+						}
+					}
+					if matched {
+						result += 40
+					}
+				}
+			}
+		}
+	}
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			black := code.modules[y][x]
+			if black {
+				dark++
+			}
+			// блоки 2×2 одного цвета
+			if x+1 < size && y+1 < size && black == code.modules[y][x+1] &&
+				black == code.modules[y+1][x] && black == code.modules[y+1][x+1] {
+				result += 3
+			}
+		}
+	}
+	// отклонение доли тёмных модулей от половины, по 10 очков за каждые 5%
+	total := size * size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return result + k*10
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,199 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// пример из учебника: "HELLO WORLD", версия 1-M
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	assert.Equal(t, want, reedSolomonRemainder(data, reedSolomonDivisor(len(want))))
+}
+
+func TestFormatAndVersionBits(t *testing.T) {
+	assert.Equal(t, 0x77C4, formatBits(Low, 0))
+	assert.Equal(t, 0x5412, formatBits(Medium, 0))
+	assert.Equal(t, 0x083B, formatBits(High, 7))
+	assert.Equal(t, 0x07C94, versionBits(7))
+	assert.Equal(t, 0x28C69, versionBits(40))
+}
+
+func TestEncodeVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		length      int
+		level       Level
+		wantVersion int
+		wantErr     error
+	}{
+		{name: "fits version 1-L", length: 17, level: Low, wantVersion: 1},
+		{name: "overflows version 1-L", length: 18, level: Low, wantVersion: 2},
+		{name: "fits version 1-H", length: 7, level: High, wantVersion: 1},
+		{name: "largest version 40-L", length: 2953, level: Low, wantVersion: 40},
+		{name: "largest version 40-H", length: 1273, level: High, wantVersion: 40},
+		{name: "too long", length: 1274, level: High, wantErr: ErrTooLong},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, err := Encode(bytes.Repeat([]byte("a"), test.length), test.level)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.wantVersion, code.Version)
+			assert.Equal(t, test.wantVersion*4+17, code.Size)
+		})
+	}
+}
+
+// readCode читает символ обратно: информацию о формате, маску, блоки и коды коррекции
+func readCode(t *testing.T, code *Code) []byte {
+	t.Helper()
+	format := 0
+	for i := 0; i <= 5; i++ {
+		format |= b2i(code.Black(8, i)) << i
+	}
+	format |= b2i(code.Black(8, 7))<<6 | b2i(code.Black(8, 8))<<7 | b2i(code.Black(7, 8))<<8
+	for i := 9; i < 15; i++ {
+		format |= b2i(code.Black(14-i, 8)) << i
+	}
+	second := 0
+	for i := 0; i < 8; i++ {
+		second |= b2i(code.Black(code.Size-1-i, 8)) << i
+	}
+	for i := 8; i < 15; i++ {
+		second |= b2i(code.Black(8, code.Size-15+i)) << i
+	}
+	require.Equal(t, format, second, "copies of format information differ")
+	require.Equal(t, formatBits(code.Level, code.Mask), format)
+
+	var bits []bool
+	for right := code.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < code.Size; vert++ {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = code.Size - 1 - vert
+			}
+			for x := right; x >= right-1; x-- {
+				if !code.isFunction[y][x] {
+					bits = append(bits, code.Black(x, y) != maskBit(code.Mask, x, y))
+				}
+			}
+		}
+	}
+	require.Equal(t, numRawDataModules(code.Version), len(bits))
+
+	codewords := bitBuffer(bits[:len(bits)/8*8]).bytes()
+	blocksCount := numBlocks[code.Level][code.Version]
+	eccLen := eccCodewordsPerBlock[code.Level][code.Version]
+	shortLen := len(codewords) / blocksCount
+	numShort := blocksCount - len(codewords)%blocksCount
+	blocks := make([][]byte, blocksCount)
+	k := 0
+	for i := 0; i < shortLen-eccLen+1; i++ {
+		for j := range blocks {
+			if j < numShort && i == shortLen-eccLen {
+				continue
+			}
+			blocks[j] = append(blocks[j], codewords[k])
+			k++
+		}
+	}
+	var data []byte
+	for _, block := range blocks {
+		data = append(data, block...)
+	}
+	// коды коррекции тоже перемежаются: i-й байт кода j-го блока стоит на месте i*blocksCount+j
+	eccStart := len(codewords) - eccLen*blocksCount
+	for j, block := range blocks {
+		ecc := make([]byte, eccLen)
+		for i := range ecc {
+			ecc[i] = codewords[eccStart+i*blocksCount+j]
+		}
+		require.Equal(t, reedSolomonRemainder(block, reedSolomonDivisor(eccLen)), ecc, "block %d", j)
+	}
+
+	var stream bitBuffer
+	for _, b := range data {
+		stream.append(int(b), 8)
+	}
+	read := func(pos, length int) int {
+		value := 0
+		for _, bit := range stream[pos : pos+length] {
+			value = value<<1 | b2i(bit)
+		}
+		return value
+	}
+	require.Equal(t, 0b0100, read(0, 4))
+	length := read(4, countBits(code.Version))
+	result := make([]byte, length)
+	for i := range result {
+		result[i] = byte(read(4+countBits(code.Version)+i*8, 8))
+	}
+	return result
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	inputs := []string{
+		"http://localhost:8080/EwHXdJfB",
+		"https://short.example.com/" + strings.Repeat("x", 100),
+		strings.Repeat("0123456789abcdef", 40),
+	}
+	for _, input := range inputs {
+		for level := Low; level <= High; level++ {
+			code, err := Encode([]byte(input), level)
+			require.NoError(t, err)
+			assert.Equal(t, input, string(readCode(t, code)), "version %d-%s", code.Version, level)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	code, err := Encode([]byte("http://localhost:8080/EwHXdJfB"), Medium)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, code.WritePNG(&buf, 300, QuietZone))
+	img, err := png.Decode(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, 300, img.Bounds().Dx())
+	// версия 3: 29 модулей и рамка по 4, то есть 37 модулей по 8 пикселей и 4 пикселя остатка
+	require.Equal(t, 3, code.Version)
+	corner := 2 + QuietZone*8
+	r, _, _, _ := img.At(corner, corner).RGBA()
+	assert.Zero(t, r)
+	r, _, _, _ = img.At(corner-1, corner-1).RGBA()
+	assert.NotZero(t, r)
+	assert.Error(t, code.WritePNG(&buf, code.MinPixels(QuietZone)-1, QuietZone))
+
+	svg := string(code.SVG(256, QuietZone))
+	assert.True(t, strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="256" height="256" viewBox="0 0 37 37"`))
+	// верхняя строка: поисковые узоры по 7 модулей
+	assert.Contains(t, svg, "M4 4h7v1h-7z")
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("q")
+	require.NoError(t, err)
+	assert.Equal(t, Quartile, level)
+	_, err = ParseLevel("X")
+	assert.Error(t, err)
+}
@@ -0,0 +1,69 @@
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// QuietZone — рекомендуемая стандартом ширина светлой рамки в модулях
+const QuietZone = 4
+
+// MinPixels — наименьший размер картинки, при котором на модуль приходится хотя бы один пиксель
+func (code *Code) MinPixels(margin int) int {
+	return code.Size + 2*margin
+}
+
+// WritePNG рисует код квадратом size×size пикселей с рамкой margin модулей. Модуль занимает
+// целое число пикселей, чтобы края оставались чёткими, остаток делится поровну по краям
+func (code *Code) WritePNG(w io.Writer, size, margin int) error {
+	modules := code.MinPixels(margin)
+	if size < modules {
+		return fmt.Errorf("size %d is less than %d modules", size, modules)
+	}
+	scale := size / modules
+	offset := (size-scale*modules)/2 + margin*scale
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if !code.Black(x, y) {
+				continue
+			}
+			for py := offset + y*scale; py < offset+(y+1)*scale; py++ {
+				row := img.Pix[py*img.Stride:]
+				for px := offset + x*scale; px < offset+(x+1)*scale; px++ {
+					row[px] = 1
+				}
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// SVG рисует код в координатах модулей, size задаёт ширину и высоту картинки.
+// Подряд идущие тёмные модули строки сливаются в один прямоугольник
+func (code *Code) SVG(size, margin int) []byte {
+	modules := code.MinPixels(margin)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, modules, modules)
+	buf.WriteString(`<rect width="100%" height="100%" fill="#fff"/><path fill="#000" d="`)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; {
+			if !code.Black(x, y) {
+				x++
+				continue
+			}
+			start := x
+			for x < code.Size && code.Black(x, y) {
+				x++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", start+margin, y+margin, x-start, x-start)
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes()
+}
@@ -0,0 +1,180 @@
+// Package ratelimit — ограничение частоты запросов токен-бакетами по ключу клиента
+package ratelimit
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit — не больше Requests запросов за Period; столько же можно сделать подряд после простоя.
+// Нулевой Limit означает отсутствие ограничения
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+func (limit Limit) IsZero() bool {
+	return limit.Requests == 0
+}
+
+func (limit Limit) String() string {
+	if limit.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%d/%s", limit.Requests, limit.Period)
+}
+
+// ParseLimit разбирает лимит вида "60/1m" или "10/s"; пустая строка и "0" — без ограничения
+func ParseLimit(s string) (Limit, error) {
+	if s == "" || s == "0" {
+		return Limit{}, nil
+	}
+	requestsStr, periodStr, found := strings.Cut(s, "/")
+	if !found {
+		return Limit{}, fmt.Errorf("limit %q must look like <requests>/<period>, e.g. 60/1m", s)
+	}
+	requests, err := strconv.Atoi(requestsStr)
+	if err != nil || requests <= 0 {
+		return Limit{}, fmt.Errorf("limit %q: number of requests must be a positive integer", s)
+	}
+	if periodStr != "" && (periodStr[0] < '0' || periodStr[0] > '9') {
+		// "10/s" — то же, что "10/1s"
+		periodStr = "1" + periodStr
+	}
+	period, err := time.ParseDuration(periodStr)
+	if err != nil || period <= 0 {
+		return Limit{}, fmt.Errorf("limit %q: period must be a positive duration", s)
+	}
+	return Limit{Requests: requests, Period: period}, nil
+}
+
+// Result — решение по запросу и состояние бакета после него
+type Result struct {
+	Allowed bool
+	// Limit — ёмкость бакета, Remaining — сколько запросов можно сделать сразу
+	Limit     int
+	Remaining int
+	// Reset — через сколько бакет наполнится полностью
+	Reset time.Duration
+	// RetryAfter — через сколько появится токен для отклонённого запроса
+	RetryAfter time.Duration
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// Limiter хранит бакеты клиентов в порядке последнего обращения. Бакет, простоявший Period,
+// снова полон и ничем не отличается от нового, поэтому такие бакеты удаляются при очередных
+// запросах; при превышении maxKeys вытесняется самый давний бакет, даже если он не полон
+type Limiter struct {
+	limit   Limit
+	rate    float64 // токенов в секунду
+	maxKeys int
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+}
+
+func New(limit Limit, maxKeys int) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		rate:    float64(limit.Requests) / limit.Period.Seconds(),
+		maxKeys: maxKeys,
+		buckets: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// Allow забирает токен из бакета клиента key, если он есть
+func (l *Limiter) Allow(key string) Result {
+	return l.AllowAll([]string{key})
+}
+
+// AllowAll забирает по токену из бакетов всех ключей, только если токен есть в каждом из них:
+// отказ по одному ключу не тратит остальные бакеты. Результат описывает самый строгий бакет
+func (l *Limiter) AllowAll(keys []string) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.evictIdle(now)
+
+	buckets := make([]*bucket, 0, len(keys))
+	allowed := true
+	for _, key := range keys {
+		b := l.refill(key, now)
+		if b.tokens < 1 {
+			allowed = false
+		}
+		buckets = append(buckets, b)
+	}
+
+	capacity := float64(l.limit.Requests)
+	result := Result{Allowed: allowed, Limit: l.limit.Requests}
+	tokens := capacity
+	for _, b := range buckets {
+		if allowed {
+			b.tokens--
+		} else if b.tokens < 1 {
+			result.RetryAfter = max(result.RetryAfter, l.duration(1-b.tokens))
+		}
+		tokens = math.Min(tokens, b.tokens)
+	}
+	result.Remaining = int(tokens)
+	result.Reset = l.duration(capacity - tokens)
+	return result
+}
+
+// refill возвращает бакет ключа, пополненный к моменту now; новый ключ получает полный бакет
+func (l *Limiter) refill(key string, now time.Time) *bucket {
+	capacity := float64(l.limit.Requests)
+	if element, ok := l.buckets[key]; ok {
+		b := element.Value.(*bucket)
+		b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+		l.order.MoveToFront(element)
+		return b
+	}
+	if l.maxKeys > 0 && l.order.Len() >= l.maxKeys {
+		l.remove(l.order.Back())
+	}
+	b := &bucket{key: key, tokens: capacity, last: now}
+	l.buckets[key] = l.order.PushFront(b)
+	return b
+}
+
+// Len возвращает число хранимых бакетов
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len()
+}
+
+// evictIdle удаляет с конца очереди бакеты, которые успели наполниться
+func (l *Limiter) evictIdle(now time.Time) {
+	for element := l.order.Back(); element != nil; element = l.order.Back() {
+		if now.Sub(element.Value.(*bucket).last) < l.limit.Period {
+			return
+		}
+		l.remove(element)
+	}
+}
+
+func (l *Limiter) remove(element *list.Element) {
+	delete(l.buckets, element.Value.(*bucket).key)
+	l.order.Remove(element)
+}
+
+// duration — время, за которое накопится tokens токенов
+func (l *Limiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Limit
+		wantErr bool
+	}{
+		{value: "", want: Limit{}},
+		{value: "0", want: Limit{}},
+		{value: "60/1m", want: Limit{Requests: 60, Period: time.Minute}},
+		{value: "10/s", want: Limit{Requests: 10, Period: time.Second}},
+		{value: "5/500ms", want: Limit{Requests: 5, Period: 500 * time.Millisecond}},
+		{value: "60", wantErr: true},
+		{value: "-1/1m", wantErr: true},
+		{value: "10/0s", wantErr: true},
+		{value: "10/forever", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			limit, err := ParseLimit(test.value)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, limit)
+		})
+	}
+}
+
+func newTestLimiter(limit Limit, maxKeys int) (*Limiter, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := New(limit, maxKeys)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLimiterAllow(t *testing.T) {
+	l, now := newTestLimiter(Limit{Requests: 3, Period: 3 * time.Second}, 0)
+
+	for i := 2; i >= 0; i-- {
+		result := l.Allow("client")
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, i, result.Remaining)
+	}
+	result := l.Allow("client")
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Second, result.RetryAfter)
+	assert.Equal(t, 3*time.Second, result.Reset)
+	// у другого клиента свой бакет
+	assert.True(t, l.Allow("other").Allowed)
+
+	*now = now.Add(time.Second)
+	result = l.Allow("client")
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.False(t, l.Allow("client").Allowed)
+}
+
+func TestLimiterAllowAll(t *testing.T) {
+	l, _ := newTestLimiter(Limit{Requests: 2, Period: 2 * time.Second}, 0)
+
+	result := l.AllowAll([]string{"user", "ip"})
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 1, result.Remaining)
+	assert.True(t, l.Allow("ip").Allowed)
+
+	// бакет ip пуст: запрос отклоняется, а бакет user остаётся нетронутым
+	for i := 0; i < 3; i++ {
+		result = l.AllowAll([]string{"user", "ip"})
+		assert.False(t, result.Allowed)
+		assert.Equal(t, 0, result.Remaining)
+		assert.Equal(t, time.Second, result.RetryAfter)
+	}
+	result = l.Allow("user")
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+}
+
+func TestLimiterEviction(t *testing.T) {
+	l, now := newTestLimiter(Limit{Requests: 2, Period: time.Minute}, 3)
+
+	l.Allow("a")
+	l.Allow("a")
+	assert.False(t, l.Allow("a").Allowed)
+	l.Allow("b")
+	l.Allow("c")
+	// при переполнении вытесняется самый давний бакет
+	l.Allow("d")
+	assert.Equal(t, 3, l.Len())
+	result := l.Allow("a")
+	assert.True(t, result.Allowed, "evicted bucket starts full")
+	assert.Equal(t, 1, result.Remaining)
+
+	// простоявшие период бакеты удаляются при следующем запросе
+	*now = now.Add(time.Minute)
+	l.Allow("e")
+	assert.Equal(t, 1, l.Len())
+}
@@ -1,21 +1,73 @@
 package router
 
 import (
+	"net/http"
+	"strings"
+
 	"github.com/go-chi/chi/v5"
+	"github.com/hessayon/ya_practicum_go/internal/analytics"
+	"github.com/hessayon/ya_practicum_go/internal/codegen"
+	"github.com/hessayon/ya_practicum_go/internal/config"
+	"github.com/hessayon/ya_practicum_go/internal/deleting"
 	"github.com/hessayon/ya_practicum_go/internal/handlers"
+	"github.com/hessayon/ya_practicum_go/internal/health"
+	"github.com/hessayon/ya_practicum_go/internal/metrics"
 	"github.com/hessayon/ya_practicum_go/internal/middleware"
+	"github.com/hessayon/ya_practicum_go/internal/policy"
+	"github.com/hessayon/ya_practicum_go/internal/ratelimit"
 	"github.com/hessayon/ya_practicum_go/internal/storage"
 	"go.uber.org/zap"
 )
 
+// rateLimitMaxClients ограничивает память лимитеров: больше бакетов одного класса не хранится
+const rateLimitMaxClients = 100000
 
+// newLimiter возвращает nil для нулевого лимита, и middleware.RateLimit тогда ничего не ограничивает
+func newLimiter(limit ratelimit.Limit) *ratelimit.Limiter {
+	if limit.IsZero() {
+		return nil
+	}
+	return ratelimit.New(limit, rateLimitMaxClients)
+}
 
-func NewServiceRouter(log *zap.Logger, s storage.URLStorage) *chi.Mux {
+func NewServiceRouter(log *zap.Logger, s storage.URLStorage, d *deleting.URLDeleter, g codegen.Generator, rec *analytics.Recorder, hc *health.Health, p *policy.Engine) *chi.Mux {
+	key := []byte(config.Config.SecretKey)
+	clientKey := middleware.ClientIPKey(config.Config.TrustedProxyPrefixes)
+	if config.Config.RateLimitBy == "user" {
+		clientKey = middleware.UserKey(key, clientKey)
+	}
+	createLimiter := newLimiter(config.Config.CreateLimit)
+	batchLimiter := newLimiter(config.Config.BatchLimit)
+	qrLimiter := newLimiter(config.Config.QRLimit)
 	newRouter := chi.NewRouter()
-	newRouter.Post("/", middleware.RequestLogger(log, middleware.GzipCompress(handlers.CreateShortURL(s))))
-	newRouter.Get("/{id}", middleware.RequestLogger(log, middleware.GzipCompress(handlers.DecodeShortURL(s))))
-	newRouter.Post("/api/shorten", middleware.RequestLogger(log, middleware.GzipCompress(handlers.CreateShortURLJSON(s))))
-	newRouter.Get("/ping", middleware.RequestLogger(log, middleware.GzipCompress(handlers.Ping)))
-	newRouter.Post("/api/shorten/batch", middleware.RequestLogger(log, middleware.GzipCompress(handlers.CreateShortURLBatch(s))))
+	newRouter.Post("/", middleware.RequestLogger(log, middleware.RateLimit("create", createLimiter, clientKey, middleware.GzipCompress(middleware.Authenticate(key, handlers.CreateShortURL(s, g, p))))))
+	newRouter.Get("/{id}", middleware.RequestLogger(log, middleware.GzipCompress(handlers.DecodeShortURL(s, rec, p))))
+	newRouter.Get("/{id}+", middleware.RequestLogger(log, middleware.GzipCompress(middleware.Identify(key, handlers.PreviewShortURL(s, rec, p)))))
+	newRouter.Post("/api/shorten", middleware.RequestLogger(log, middleware.RateLimit("create", createLimiter, clientKey, middleware.GzipCompress(middleware.Authenticate(key, handlers.CreateShortURLJSON(s, g, p))))))
+	newRouter.Get("/ping", middleware.RequestLogger(log, middleware.GzipCompress(handlers.Ping(hc))))
+	newRouter.Get("/healthz", middleware.RequestLogger(log, middleware.GzipCompress(handlers.Liveness)))
+	newRouter.Get("/readyz", middleware.RequestLogger(log, middleware.GzipCompress(handlers.Readiness(hc))))
+	newRouter.Post("/api/shorten/batch", middleware.RequestLogger(log, middleware.RateLimit("batch", batchLimiter, clientKey, middleware.GzipCompress(middleware.Authenticate(key, handlers.CreateShortURLBatch(s, g, p))))))
+	newRouter.Get("/api/user/urls", middleware.RequestLogger(log, middleware.GzipCompress(middleware.RequireAuth(key, handlers.GetUserURLs(s)))))
+	newRouter.Delete("/api/user/urls", middleware.RequestLogger(log, middleware.GzipCompress(middleware.RequireAuth(key, handlers.DeleteUserURLs(d)))))
+	newRouter.Get("/api/qr/{id}", middleware.RequestLogger(log, middleware.RateLimit("qr", qrLimiter, clientKey, middleware.GzipCompress(handlers.GetQRCode(s, p)))))
+	newRouter.Get("/api/stats/{id}", middleware.RequestLogger(log, middleware.GzipCompress(middleware.RequireAuth(key, handlers.GetLinkStats(s, rec)))))
+	if config.Config.MetricsAddr == "" {
+		// отдельный адрес не задан — метрики доступны на основном
+		newRouter.Get("/metrics", middleware.RequestLogger(log, metrics.Handler()))
+	}
+	reserveRouteAliases(newRouter)
 	return newRouter
 }
+
+// reserveRouteAliases запрещает коды, совпадающие с первыми сегментами путей роутера,
+// иначе ссылка с таким кодом была бы недоступна
+func reserveRouteAliases(r chi.Routes) {
+	chi.Walk(r, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		segment, _, _ := strings.Cut(strings.TrimPrefix(route, "/"), "/")
+		if segment != "" && !strings.Contains(segment, "{") {
+			codegen.ReserveAliases(segment)
+		}
+		return nil
+	})
+}
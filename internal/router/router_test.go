@@ -0,0 +1,161 @@
+package router
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hessayon/ya_practicum_go/internal/analytics"
+	"github.com/hessayon/ya_practicum_go/internal/codegen"
+	"github.com/hessayon/ya_practicum_go/internal/config"
+	"github.com/hessayon/ya_practicum_go/internal/deleting"
+	"github.com/hessayon/ya_practicum_go/internal/health"
+	"github.com/hessayon/ya_practicum_go/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestRouter(t *testing.T) *chi.Mux {
+	config.Config = config.NewDefaultServiceConfig()
+	s, err := storage.NewURLStorage("")
+	require.NoError(t, err)
+	d := deleting.NewURLDeleter(s, 1, 10, 10, time.Second)
+	rec := analytics.NewRecorder(analytics.NewMemorySink(), []byte("secret"), 16, 1, time.Second)
+	t.Cleanup(func() {
+		d.Close()
+		rec.Close()
+		s.Close()
+	})
+	return NewServiceRouter(zap.NewNop(), s, d, codegen.NewRandomGenerator(8, s), rec, health.New(time.Second), nil)
+}
+
+// readBody распаковывает тело, если ответ сжат
+func readBody(t *testing.T, res *http.Response) string {
+	body := io.Reader(res.Body)
+	if res.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(res.Body)
+		require.NoError(t, err)
+		body = zr
+	}
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestRouterCompression(t *testing.T) {
+	r := newTestRouter(t)
+	var cookies []*http.Cookie
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		contentType    string
+		acceptEncoding string
+		body           string
+		wantCode       int
+		wantGzip       bool
+		wantBody       string
+	}{
+		{name: "json route", method: http.MethodPost, target: "/api/shorten", contentType: "application/json", acceptEncoding: "gzip", body: `{"url": "https://a.example"}`, wantCode: http.StatusCreated, wantGzip: true, wantBody: `"result"`},
+		{name: "json route without gzip", method: http.MethodPost, target: "/api/shorten", contentType: "application/json", body: `{"url": "https://b.example"}`, wantCode: http.StatusCreated, wantBody: `"result"`},
+		{name: "json error is not compressed", method: http.MethodPost, target: "/api/shorten", contentType: "application/json", acceptEncoding: "gzip", body: `{`, wantCode: http.StatusBadRequest},
+		{name: "text route", method: http.MethodPost, target: "/", contentType: "text/plain", acceptEncoding: "gzip", body: "https://c.example", wantCode: http.StatusCreated, wantBody: "http://localhost:8080/"},
+		// text/plain в ответе не сжимается, даже если запрос пришёл как JSON
+		{name: "text route with json request", method: http.MethodPost, target: "/", contentType: "application/json", acceptEncoding: "gzip", body: "https://d.example", wantCode: http.StatusCreated, wantBody: "http://localhost:8080/"},
+		// GET без тела: сжатие решается по Content-Type ответа, а не запроса
+		{name: "json get route", method: http.MethodGet, target: "/api/user/urls", acceptEncoding: "gzip", wantCode: http.StatusOK, wantGzip: true, wantBody: `"original_url":"https://a.example"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+			if test.contentType != "" {
+				request.Header.Set("Content-Type", test.contentType)
+			}
+			if test.acceptEncoding != "" {
+				request.Header.Set("Accept-Encoding", test.acceptEncoding)
+			}
+			for _, cookie := range cookies {
+				request.AddCookie(cookie)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+			if cookies == nil {
+				cookies = res.Cookies()
+			}
+
+			assert.Equal(t, test.wantCode, res.StatusCode)
+			assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+			if test.wantGzip {
+				assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+			} else {
+				assert.Empty(t, res.Header.Get("Content-Encoding"))
+			}
+			body := readBody(t, res)
+			assert.Contains(t, body, test.wantBody)
+			// к несжатому ответу не должен дописываться пустой gzip-поток
+			assert.NotContains(t, body, "\x1f\x8b")
+		})
+	}
+}
+
+func TestRouterReservedAliases(t *testing.T) {
+	r := newTestRouter(t)
+	shorten := func(alias string) *http.Response {
+		request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url": "https://example.com/`+alias+`", "alias": "`+alias+`"}`))
+		request.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, request)
+		return w.Result()
+	}
+	tests := []struct {
+		name     string
+		alias    string
+		wantBody string
+	}{
+		{name: "api", alias: "api", wantBody: "is reserved"},
+		{name: "api in upper case", alias: "API", wantBody: "is reserved"},
+		{name: "ping", alias: "ping", wantBody: "is reserved"},
+		{name: "healthz", alias: "healthz", wantBody: "is reserved"},
+		{name: "readyz", alias: "readyz", wantBody: "is reserved"},
+		{name: "metrics", alias: "metrics", wantBody: "is reserved"},
+		// код с плюсом на конце совпал бы с путём предпросмотра {id}+
+		{name: "preview", alias: "link+", wantBody: "is not allowed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := shorten(test.alias)
+			defer res.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+			assert.Contains(t, readBody(t, res), test.wantBody)
+		})
+	}
+
+	// сама ссылка доступна и по коду, и по пути предпросмотра
+	res := shorten("link")
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	for target, wantCode := range map[string]int{"/link": http.StatusTemporaryRedirect, "/link+": http.StatusOK} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, wantCode, w.Code, target)
+	}
+}
+
+func TestReserveRouteAliases(t *testing.T) {
+	r := chi.NewRouter()
+	r.Get("/custom-route/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	r.Get("/{code}", func(w http.ResponseWriter, r *http.Request) {})
+	require.NoError(t, codegen.ValidateAlias("custom-route"))
+	reserveRouteAliases(r)
+	assert.ErrorIs(t, codegen.ValidateAlias("custom-route"), codegen.ErrInvalidAlias)
+	// параметры путей не резервируются
+	assert.NoError(t, codegen.ValidateAlias("code"))
+}
@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hessayon/ya_practicum_go/internal/metrics"
+)
+
+// Cache — кэш соответствий короткий код → исходная ссылка.
+// Кроме LRUCache в процессе сюда можно подключить внешний кэш
+type Cache interface {
+	Get(key string) (value string, ok bool)
+	Set(key, value string, ttl time.Duration)
+	Delete(keys ...string)
+}
+
+// cacheGenerations — число счётчиков поколений; коды делят их по хешу
+const cacheGenerations = 256
+
+// CachedStorage — обёртка над хранилищем, которая отвечает на GetOriginalURL и GetURLData из кэша.
+// Save, SaveBatch и DeleteURLs сбрасывают затронутые коды, а срок жизни записи в кэше
+// не превышает срока жизни самой ссылки.
+// Чтение мимо кэша могло начаться до записи и закончиться после сброса, поэтому каждая запись
+// увеличивает поколение своих кодов до и после изменения, а прочитанное значение кладётся
+// в кэш, только если поколение за время чтения не поменялось
+type CachedStorage struct {
+	URLStorage
+	cache       Cache
+	ttl         time.Duration
+	generations [cacheGenerations]atomic.Uint64
+}
+
+func NewCachedStorage(s URLStorage, cache Cache, ttl time.Duration) *CachedStorage {
+	return &CachedStorage{URLStorage: s, cache: cache, ttl: ttl}
+}
+
+func (s *CachedStorage) GetOriginalURL(ctx context.Context, shortURL string) (string, error) {
+	if originalURL, ok := s.cache.Get(shortURL); ok {
+		metrics.CacheRequests.Inc("hit")
+		return originalURL, nil
+	}
+	metrics.CacheRequests.Inc("miss")
+	generation := s.generation(shortURL).Load()
+	urlData, err := s.URLStorage.GetURLData(ctx, shortURL)
+	if err != nil {
+		// отсутствующие, удалённые и истёкшие ссылки не кэшируются
+		return "", err
+	}
+	s.store(urlData, generation)
+	return urlData.OriginalURL, nil
+}
+
+func (s *CachedStorage) GetURLData(ctx context.Context, shortURL string) (*URLData, error) {
+	// полная запись нужна редко, поэтому берётся из хранилища, но заодно прогревает кэш
+	generation := s.generation(shortURL).Load()
+	urlData, err := s.URLStorage.GetURLData(ctx, shortURL)
+	if err != nil {
+		return urlData, err
+	}
+	s.store(urlData, generation)
+	return urlData, nil
+}
+
+func (s *CachedStorage) generation(shortURL string) *atomic.Uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(shortURL))
+	return &s.generations[h.Sum32()%cacheGenerations]
+}
+
+// invalidate начинает изменение кодов: поколения растут сразу, а возвращённая функция
+// после изменения увеличивает их ещё раз и сбрасывает коды из кэша
+func (s *CachedStorage) invalidate(keys ...string) func() {
+	for _, key := range keys {
+		s.generation(key).Add(1)
+	}
+	return func() {
+		for _, key := range keys {
+			s.generation(key).Add(1)
+		}
+		s.cache.Delete(keys...)
+	}
+}
+
+// store кладёт прочитанную ссылку в кэш, если её код не менялся с начала чтения.
+// Изменение могло начаться уже после проверки, поэтому она повторяется и после записи в кэш
+func (s *CachedStorage) store(urlData *URLData, generation uint64) {
+	counter := s.generation(urlData.ShortURL)
+	if counter.Load() != generation {
+		return
+	}
+	ttl := s.ttl
+	if urlData.ExpiresAt != nil {
+		if untilExpiry := time.Until(*urlData.ExpiresAt); untilExpiry < ttl {
+			ttl = untilExpiry
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+	s.cache.Set(urlData.ShortURL, urlData.OriginalURL, ttl)
+	if counter.Load() != generation {
+		s.cache.Delete(urlData.ShortURL)
+	}
+}
+
+func (s *CachedStorage) Save(ctx context.Context, urlData *URLData) error {
+	// код мог освободиться и заняться заново, старое значение в кэше больше неверно
+	defer s.invalidate(urlData.ShortURL)()
+	return s.URLStorage.Save(ctx, urlData)
+}
+
+func (s *CachedStorage) SaveBatch(ctx context.Context, urlsBatch []*URLData) error {
+	keys := make([]string, 0, len(urlsBatch))
+	for _, urlData := range urlsBatch {
+		keys = append(keys, urlData.ShortURL)
+	}
+	defer s.invalidate(keys...)()
+	return s.URLStorage.SaveBatch(ctx, urlsBatch)
+}
+
+func (s *CachedStorage) DeleteURLs(ctx context.Context, tasks []DeleteTask) error {
+	keys := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		keys = append(keys, task.ShortURL)
+	}
+	// сбрасываем и при ошибке: часть ссылок могла успеть удалиться
+	defer s.invalidate(keys...)()
+	return s.URLStorage.DeleteURLs(ctx, tasks)
+}
+
+//--------------------------------------------------------------------
+
+type lruEntry struct {
+	key       string
+	value     string
+	expiresAt time.Time
+}
+
+// LRUCache — потокобезопасный кэш в памяти процесса с ограничением на число записей:
+// при переполнении вытесняется запись, к которой дольше всего не обращались
+type LRUCache struct {
+	capacity int
+	mu       sync.Mutex
+	order    *list.List // от недавно использованных к давно использованным
+	items    map[string]*list.Element
+	now      func() time.Time
+}
+
+func NewLRUCache(capacity int) *LRUCache {
+	return &LRUCache{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element, capacity),
+		now:      time.Now,
+	}
+}
+
+func (c *LRUCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*lruEntry)
+	if !entry.expiresAt.After(c.now()) {
+		c.removeElement(elem)
+		return "", false
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (c *LRUCache) Set(key, value string, ttl time.Duration) {
+	if c.capacity <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expiresAt := c.now().Add(ttl)
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*lruEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *LRUCache) Delete(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if elem, ok := c.items[key]; ok {
+			c.removeElement(elem)
+		}
+	}
+}
+
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRUCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*lruEntry).key)
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hessayon/ya_practicum_go/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewLRUCache(2)
+	cache.now = func() time.Time { return now }
+
+	cache.Set("a", "https://a", time.Minute)
+	cache.Set("b", "https://b", time.Minute)
+	// обращение к a делает вытесняемой запись b
+	_, ok := cache.Get("a")
+	require.True(t, ok)
+	cache.Set("c", "https://c", time.Minute)
+
+	_, ok = cache.Get("b")
+	assert.False(t, ok, "least recently used entry must be evicted")
+	value, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "https://a", value)
+	assert.Equal(t, 2, cache.Len())
+
+	cache.Delete("a")
+	_, ok = cache.Get("a")
+	assert.False(t, ok)
+
+	now = now.Add(2 * time.Minute)
+	_, ok = cache.Get("c")
+	assert.False(t, ok, "expired entry must not be returned")
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestCachedStorage(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewURLStorage("")
+	require.NoError(t, err)
+	s := NewCachedStorage(backend, NewLRUCache(16), time.Minute)
+
+	require.NoError(t, s.Save(ctx, &URLData{ShortURL: "abc", OriginalURL: "https://a", UserID: "u1"}))
+
+	hits, misses := metrics.CacheRequests.Value("hit"), metrics.CacheRequests.Value("miss")
+	for i := 0; i < 3; i++ {
+		originalURL, err := s.GetOriginalURL(ctx, "abc")
+		require.NoError(t, err)
+		assert.Equal(t, "https://a", originalURL)
+	}
+	assert.Equal(t, float64(2), metrics.CacheRequests.Value("hit")-hits)
+	assert.Equal(t, float64(1), metrics.CacheRequests.Value("miss")-misses)
+
+	_, err = s.GetOriginalURL(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.GetOriginalURL(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound, "missing urls must not be cached")
+
+	require.NoError(t, s.DeleteURLs(ctx, []DeleteTask{{UserID: "u1", ShortURL: "abc"}}))
+	_, err = s.GetOriginalURL(ctx, "abc")
+	assert.ErrorIs(t, err, ErrDeleted, "delete must invalidate cached url")
+
+	// запись в кэше не переживает срок жизни самой ссылки
+	expiresAt := time.Now().Add(50 * time.Millisecond)
+	require.NoError(t, s.SaveBatch(ctx, []*URLData{{ShortURL: "exp", OriginalURL: "https://exp", ExpiresAt: &expiresAt}}))
+	_, err = s.GetOriginalURL(ctx, "exp")
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	_, err = s.GetOriginalURL(ctx, "exp")
+	assert.ErrorIs(t, err, ErrExpired)
+}
+
+func TestCachedStorageInvalidatesOnSave(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewURLStorage("")
+	require.NoError(t, err)
+	s := NewCachedStorage(backend, NewLRUCache(16), time.Minute)
+
+	expiresAt := time.Now().Add(time.Hour)
+	require.NoError(t, s.Save(ctx, &URLData{ShortURL: "code", OriginalURL: "https://old", ExpiresAt: &expiresAt}))
+	_, err = s.GetOriginalURL(ctx, "code")
+	require.NoError(t, err)
+
+	// ссылку вычистили из хранилища в обход кэша, и код занял другой адрес
+	backend.(*LocalURLStorage).removeIf("code", func(*localURL) bool { return true })
+	require.NoError(t, s.Save(ctx, &URLData{ShortURL: "code", OriginalURL: "https://new"}))
+
+	originalURL, err := s.GetOriginalURL(ctx, "code")
+	require.NoError(t, err)
+	assert.Equal(t, "https://new", originalURL)
+}
+
+// pausedStorage задерживает GetURLData после чтения из хранилища, пока тест не отпустит release
+type pausedStorage struct {
+	URLStorage
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (s *pausedStorage) GetURLData(ctx context.Context, shortURL string) (*URLData, error) {
+	urlData, err := s.URLStorage.GetURLData(ctx, shortURL)
+	s.read <- struct{}{}
+	<-s.release
+	return urlData, err
+}
+
+func TestCachedStorageReadRacingDelete(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewURLStorage("")
+	require.NoError(t, err)
+	require.NoError(t, backend.Save(ctx, &URLData{ShortURL: "abc", OriginalURL: "https://a", UserID: "u1"}))
+	paused := &pausedStorage{URLStorage: backend, read: make(chan struct{}), release: make(chan struct{})}
+	s := NewCachedStorage(paused, NewLRUCache(16), time.Minute)
+
+	// чтение успело взять ссылку до удаления, а в кэш положить её пытается уже после
+	done := make(chan error)
+	go func() {
+		_, err := s.GetOriginalURL(ctx, "abc")
+		done <- err
+	}()
+	<-paused.read
+	require.NoError(t, s.DeleteURLs(ctx, []DeleteTask{{UserID: "u1", ShortURL: "abc"}}))
+	close(paused.release)
+	require.NoError(t, <-done)
+
+	go func() { <-paused.read }()
+	_, err = s.GetOriginalURL(ctx, "abc")
+	assert.ErrorIs(t, err, ErrDeleted, "deleted url must not stay in cache")
+}
+
+// TestCachedStorageConcurrentDeletes читает ссылки параллельно с их удалением; запускать с -race
+func TestCachedStorageConcurrentDeletes(t *testing.T) {
+	const links = 200
+	ctx := context.Background()
+	backend, err := NewURLStorage("")
+	require.NoError(t, err)
+	s := NewCachedStorage(backend, NewLRUCache(links), time.Minute)
+	for i := 0; i < links; i++ {
+		shortURL := fmt.Sprintf("code%d", i)
+		require.NoError(t, s.Save(ctx, &URLData{ShortURL: shortURL, OriginalURL: "https://" + shortURL, UserID: "u1"}))
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for i := 0; i < links; i++ {
+					_, err := s.GetOriginalURL(ctx, fmt.Sprintf("code%d", i))
+					if err != nil && !errors.Is(err, ErrDeleted) {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	for i := 0; i < links; i++ {
+		require.NoError(t, s.DeleteURLs(ctx, []DeleteTask{{UserID: "u1", ShortURL: fmt.Sprintf("code%d", i)}}))
+	}
+	close(stop)
+	wg.Wait()
+
+	for i := 0; i < links; i++ {
+		_, err := s.GetOriginalURL(ctx, fmt.Sprintf("code%d", i))
+		assert.ErrorIs(t, err, ErrDeleted)
+	}
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// DBOptions — настройки хранилища в Postgres
+type DBOptions struct {
+	// BatchChunkSize — сколько строк батча вставляется одним запросом; 1 — по одной подготовленным запросом
+	BatchChunkSize int
+}
+
+// SaveBatch вставляет батч в одной транзакции частями по BatchChunkSize строк.
+// Многострочный INSERT ... ON CONFLICT DO NOTHING не прерывает транзакцию на конфликтах,
+// поэтому за один проход находятся все записи, чьи ссылки уже сохранены, и их коды
+// возвращаются в BatchError.Existing, а записи с занятыми кодами — в BatchError.Taken;
+// сама транзакция при этом откатывается
+func (storage *URLDBStorage) SaveBatch(ctx context.Context, urlsBatch []*URLData) error {
+	if storage.BatchChunkSize <= 1 {
+		return storage.saveBatchByRow(ctx, urlsBatch)
+	}
+	if len(urlsBatch) == 0 {
+		return nil
+	}
+	tx, err := storage.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := freeStaleOriginals(ctx, tx, urlsBatch); err != nil {
+		return err
+	}
+	inserted := make(map[string]bool, len(urlsBatch))
+	for start := 0; start < len(urlsBatch); start += storage.BatchChunkSize {
+		end := min(start+storage.BatchChunkSize, len(urlsBatch))
+		if err := insertChunk(ctx, tx, urlsBatch[start:end], inserted); err != nil {
+			return err
+		}
+	}
+	if len(inserted) == len(urlsBatch) {
+		return tx.Commit()
+	}
+	return findBatchConflicts(ctx, tx, urlsBatch, inserted)
+}
+
+// saveBatchByRow вставляет строки по одной; первая же ошибка прерывает транзакцию
+func (storage *URLDBStorage) saveBatchByRow(ctx context.Context, urlsBatch []*URLData) error {
+	query := "INSERT INTO urls (short_url, full_url, user_id, expires_at) VALUES ($1, $2, $3, $4);"
+	tx, err := storage.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := freeStaleOriginals(ctx, tx, urlsBatch); err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i, data := range urlsBatch {
+		_, err := stmt.ExecContext(ctx, data.ShortURL, data.OriginalURL, data.UserID, data.ExpiresAt)
+		if err != nil {
+			// после ошибки транзакция прервана, отложенный Rollback отменяет всё сделанное в ней
+			return &BatchError{Index: i, Err: convertInsertError(err)}
+		}
+	}
+	return tx.Commit()
+}
+
+// batchKey — ключ вставленной строки; по одному короткому коду строки не различить,
+// если он повторяется внутри батча
+func batchKey(shortURL, originalURL string) string {
+	return shortURL + "\x00" + originalURL
+}
+
+// buildInsertQuery собирает многострочный INSERT, который пропускает конфликтующие строки
+// и возвращает вставленные
+func buildInsertQuery(chunk []*URLData) (string, []any) {
+	var query strings.Builder
+	query.WriteString("INSERT INTO urls (short_url, full_url, user_id, expires_at) VALUES ")
+	args := make([]any, 0, len(chunk)*4)
+	for i, data := range chunk {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		n := len(args)
+		fmt.Fprintf(&query, "($%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4)
+		args = append(args, data.ShortURL, data.OriginalURL, data.UserID, data.ExpiresAt)
+	}
+	query.WriteString(" ON CONFLICT DO NOTHING RETURNING short_url, full_url")
+	return query.String(), args
+}
+
+func insertChunk(ctx context.Context, tx *sql.Tx, chunk []*URLData, inserted map[string]bool) error {
+	query, args := buildInsertQuery(chunk)
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var shortURL, originalURL string
+		if err := rows.Scan(&shortURL, &originalURL); err != nil {
+			return err
+		}
+		inserted[batchKey(shortURL, originalURL)] = true
+	}
+	return rows.Err()
+}
+
+// findBatchConflicts выясняет, почему часть строк не вставилась: исходная ссылка уже
+// сохранена — ErrConflict, иначе занят короткий код — ErrShortURLTaken
+func findBatchConflicts(ctx context.Context, tx *sql.Tx, urlsBatch []*URLData, inserted map[string]bool) error {
+	var missing []int
+	var originals []string
+	seen := make(map[string]bool, len(inserted))
+	for i, data := range urlsBatch {
+		key := batchKey(data.ShortURL, data.OriginalURL)
+		if inserted[key] && !seen[key] {
+			seen[key] = true
+			continue
+		}
+		missing = append(missing, i)
+		originals = append(originals, data.OriginalURL)
+	}
+
+	existing := make(map[string]string, len(missing))
+	rows, err := tx.QueryContext(ctx, "SELECT full_url, short_url FROM urls WHERE full_url = ANY($1::varchar[])", originals)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var originalURL, shortURL string
+		if err := rows.Scan(&originalURL, &shortURL); err != nil {
+			return err
+		}
+		existing[originalURL] = shortURL
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	batchErr := &BatchError{Index: missing[0], Err: ErrShortURLTaken}
+	for n, i := range missing {
+		data := urlsBatch[i]
+		shortURL, ok := existing[data.OriginalURL]
+		if !ok {
+			batchErr.Taken = append(batchErr.Taken, i)
+			continue
+		}
+		if n == 0 {
+			batchErr.Err = ErrConflict
+		}
+		// строка, вставленная этим же батчем, исчезнет при откате, её код не сообщается
+		if inserted[batchKey(shortURL, data.OriginalURL)] {
+			continue
+		}
+		if batchErr.Existing == nil {
+			batchErr.Existing = make(map[int]string)
+		}
+		batchErr.Existing[i] = shortURL
+	}
+	return batchErr
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildInsertQuery(t *testing.T) {
+	expiresAt := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+	query, args := buildInsertQuery([]*URLData{
+		{ShortURL: "a1", OriginalURL: "https://a", UserID: "u1"},
+		{ShortURL: "b2", OriginalURL: "https://b", ExpiresAt: &expiresAt},
+	})
+	assert.Equal(t, "INSERT INTO urls (short_url, full_url, user_id, expires_at) VALUES ($1, $2, $3, $4), ($5, $6, $7, $8)"+
+		" ON CONFLICT DO NOTHING RETURNING short_url, full_url", query)
+	assert.Equal(t, []any{"a1", "https://a", "u1", (*time.Time)(nil), "b2", "https://b", "", &expiresAt}, args)
+}
+
+// newTestDBStorage подключается к базе из TEST_DATABASE_DSN; без неё тест пропускается
+func newTestDBStorage(tb testing.TB, chunkSize int) *URLDBStorage {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		tb.Skip("TEST_DATABASE_DSN is not set")
+	}
+	s, err := NewDBURLStorage(dsn, DBOptions{BatchChunkSize: chunkSize})
+	require.NoError(tb, err)
+	storage := s.(*URLDBStorage)
+	tb.Cleanup(func() {
+		storage.DB.Exec("DELETE FROM urls WHERE short_url LIKE 'test-%'")
+		storage.Close()
+	})
+	return storage
+}
+
+func TestDBSaveBatch(t *testing.T) {
+	for _, chunkSize := range []int{1, 2, 1000} {
+		t.Run(fmt.Sprintf("chunk %d", chunkSize), func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestDBStorage(t, chunkSize)
+			require.NoError(t, s.Save(ctx, &URLData{ShortURL: "test-a1", OriginalURL: "https://test/a"}))
+			require.NoError(t, s.Save(ctx, &URLData{ShortURL: "test-c3", OriginalURL: "https://test/c"}))
+
+			err := s.SaveBatch(ctx, []*URLData{
+				{ShortURL: "test-b2", OriginalURL: "https://test/b"},
+				{ShortURL: "test-x1", OriginalURL: "https://test/a"},
+				{ShortURL: "test-d4", OriginalURL: "https://test/d"},
+				{ShortURL: "test-x3", OriginalURL: "https://test/c"},
+			})
+			assert.ErrorIs(t, err, ErrConflict)
+			var batchErr *BatchError
+			require.ErrorAs(t, err, &batchErr)
+			assert.Equal(t, 1, batchErr.Index)
+			if chunkSize > 1 {
+				// многострочная вставка находит все конфликты за один проход
+				assert.Equal(t, map[int]string{1: "test-a1", 3: "test-c3"}, batchErr.Existing)
+			}
+			// батч откатился целиком
+			_, err = s.GetOriginalURL(ctx, "test-b2")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			err = s.SaveBatch(ctx, []*URLData{
+				{ShortURL: "test-b2", OriginalURL: "https://test/b"},
+				{ShortURL: "test-a1", OriginalURL: "https://test/e"},
+			})
+			assert.ErrorIs(t, err, ErrShortURLTaken)
+			require.ErrorAs(t, err, &batchErr)
+			assert.Equal(t, 1, batchErr.Index)
+			if chunkSize > 1 {
+				assert.Equal(t, []int{1}, batchErr.Taken)
+			}
+
+			require.NoError(t, s.SaveBatch(ctx, []*URLData{
+				{ShortURL: "test-b2", OriginalURL: "https://test/b"},
+				{ShortURL: "test-d4", OriginalURL: "https://test/d"},
+			}))
+			originalURL, err := s.GetOriginalURL(ctx, "test-d4")
+			require.NoError(t, err)
+			assert.Equal(t, "https://test/d", originalURL)
+		})
+	}
+}
+
+func TestDBStaleOriginals(t *testing.T) {
+	ctx := context.Background()
+	s := newTestDBStorage(t, 1000)
+	expired := time.Now().Add(-time.Minute)
+	require.NoError(t, s.Save(ctx, &URLData{ShortURL: "test-e1", OriginalURL: "https://test/e", ExpiresAt: &expired}))
+	require.NoError(t, s.Save(ctx, &URLData{ShortURL: "test-f1", OriginalURL: "https://test/f", UserID: "u1"}))
+	require.NoError(t, s.DeleteURLs(ctx, []DeleteTask{{UserID: "u1", ShortURL: "test-f1"}}))
+
+	// ни истёкшая, до которой ещё не дошёл сборщик, ни удалённая ссылка не находятся по исходной
+	for _, originalURL := range []string{"https://test/e", "https://test/f"} {
+		_, err := s.GetShortURL(ctx, originalURL)
+		assert.ErrorIs(t, err, ErrNotFound, originalURL)
+	}
+	require.NoError(t, s.Save(ctx, &URLData{ShortURL: "test-e2", OriginalURL: "https://test/e"}))
+	require.NoError(t, s.SaveBatch(ctx, []*URLData{{ShortURL: "test-f2", OriginalURL: "https://test/f"}}))
+	shortURL, err := s.GetShortURL(ctx, "https://test/e")
+	require.NoError(t, err)
+	assert.Equal(t, "test-e2", shortURL)
+	_, err = s.GetOriginalURL(ctx, "test-e1")
+	assert.ErrorIs(t, err, ErrExpired)
+}
+
+// BenchmarkDBSaveBatch сравнивает построчную вставку с многострочной:
+// TEST_DATABASE_DSN=... go test -run xxx -bench DBSaveBatch ./internal/storage/
+func BenchmarkDBSaveBatch(b *testing.B) {
+	const batchSize = 10000
+	for _, chunkSize := range []int{1, 100, 1000, 5000} {
+		b.Run(fmt.Sprintf("chunk %d", chunkSize), func(b *testing.B) {
+			ctx := context.Background()
+			s := newTestDBStorage(b, chunkSize)
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				batch := make([]*URLData, 0, batchSize)
+				for i := 0; i < batchSize; i++ {
+					batch = append(batch, &URLData{
+						ShortURL:    fmt.Sprintf("test-%d-%d-%d", chunkSize, n, i),
+						OriginalURL: fmt.Sprintf("https://test/%d/%d/%d", chunkSize, n, i),
+						UserID:      "bench",
+					})
+				}
+				b.StartTimer()
+				if err := s.SaveBatch(ctx, batch); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
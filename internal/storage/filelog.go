@@ -0,0 +1,506 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// политики сброса журнала файлового хранилища на диск
+const (
+	// SyncAlways — fsync после каждой записи: ничего не теряется, но каждая запись дороже
+	SyncAlways = "always"
+	// SyncInterval — fsync раз в SyncInterval: при сбое теряются записи за последний интервал
+	SyncInterval = "interval"
+	// SyncNever — fsync только при закрытии, остальное на усмотрение ОС
+	SyncNever = "never"
+)
+
+// maxLogBatch — сколько ожидающих запросов журнал объединяет в одну запись на диск
+const maxLogBatch = 128
+
+// reservedSuffix — суффикс файла с кодами ссылок, убранных из журнала при сжатии
+const reservedSuffix = ".reserved"
+
+// ErrStorageClosed возвращается при записи в уже закрытое хранилище
+var ErrStorageClosed = errors.New("storage is closed")
+
+// FileOptions — настройки журнала файлового хранилища
+type FileOptions struct {
+	SyncPolicy   string
+	SyncInterval time.Duration
+	// CompactInterval — период перезаписи журнала без удалённых и истёкших ссылок; 0 — не сжимать
+	CompactInterval time.Duration
+}
+
+type URLStorageFileSaver struct {
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+func newStorageSaver(filename string) (*URLStorageFileSaver, error) {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriter(file)
+	return &URLStorageFileSaver{
+		file:    file,
+		writer:  writer,
+		encoder: json.NewEncoder(writer),
+	}, nil
+}
+
+func (saver *URLStorageFileSaver) close() error {
+	err := saver.writer.Flush()
+	if syncErr := saver.file.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := saver.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// logRequest — запрос к журналу: дописать записи или выполнить операцию над файлом
+type logRequest struct {
+	records []*URLData
+	fn      func(saver *URLStorageFileSaver) (*URLStorageFileSaver, error)
+	done    chan error
+}
+
+// logWriter — единственная горутина, которая пишет в файл журнала.
+// Запросы, пришедшие одновременно, записываются одним блоком и с одним fsync
+type logWriter struct {
+	requests chan logRequest
+	closed   chan struct{}
+	done     chan struct{}
+	options  FileOptions
+}
+
+func newLogWriter(saver *URLStorageFileSaver, options FileOptions) *logWriter {
+	w := &logWriter{
+		requests: make(chan logRequest),
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+		options:  options,
+	}
+	go w.run(saver)
+	return w
+}
+
+func (w *logWriter) do(req logRequest) error {
+	req.done = make(chan error, 1)
+	select {
+	case w.requests <- req:
+	case <-w.closed:
+		return ErrStorageClosed
+	}
+	return <-req.done
+}
+
+// write дописывает записи в журнал и ждёт, пока они окажутся в файле; без файла ничего не делает
+func (w *logWriter) write(records ...*URLData) error {
+	if w == nil || len(records) == 0 {
+		return nil
+	}
+	return w.do(logRequest{records: records})
+}
+
+func (w *logWriter) check() error {
+	if w == nil {
+		return nil
+	}
+	return w.do(logRequest{fn: func(saver *URLStorageFileSaver) (*URLStorageFileSaver, error) {
+		_, err := saver.file.Stat()
+		return saver, err
+	}})
+}
+
+func (w *logWriter) close() {
+	if w == nil {
+		return
+	}
+	close(w.closed)
+	<-w.done
+}
+
+func (w *logWriter) run(saver *URLStorageFileSaver) {
+	defer close(w.done)
+	var syncTick <-chan time.Time
+	if w.options.SyncPolicy == SyncInterval && w.options.SyncInterval > 0 {
+		ticker := time.NewTicker(w.options.SyncInterval)
+		defer ticker.Stop()
+		syncTick = ticker.C
+	}
+	dirty := false
+	for {
+		select {
+		case <-w.closed:
+			if err := saver.close(); err != nil {
+				log.Printf("Error in closing storage file: %s", err.Error())
+			}
+			return
+		case <-syncTick:
+			if !dirty {
+				continue
+			}
+			if err := saver.file.Sync(); err != nil {
+				log.Printf("Error in syncing storage file: %s", err.Error())
+			}
+			dirty = false
+		case req := <-w.requests:
+			batch := []logRequest{req}
+		collect:
+			for len(batch) < maxLogBatch {
+				select {
+				case req := <-w.requests:
+					batch = append(batch, req)
+				default:
+					break collect
+				}
+			}
+			saver = w.process(saver, batch)
+			dirty = true
+		}
+	}
+}
+
+// process выполняет пачку запросов по порядку и отвечает на каждый
+func (w *logWriter) process(saver *URLStorageFileSaver, batch []logRequest) *URLStorageFileSaver {
+	var pending []logRequest
+	var pendingErrs []error
+	// flush сбрасывает накопленные записи в файл и отвечает их отправителям
+	flush := func() {
+		err := saver.writer.Flush()
+		if err == nil && w.options.SyncPolicy == SyncAlways {
+			err = saver.file.Sync()
+		}
+		for i, req := range pending {
+			if pendingErrs[i] != nil {
+				req.done <- pendingErrs[i]
+			} else {
+				req.done <- err
+			}
+		}
+		pending, pendingErrs = pending[:0], pendingErrs[:0]
+	}
+	for _, req := range batch {
+		if req.fn != nil {
+			flush()
+			var err error
+			saver, err = req.fn(saver)
+			req.done <- err
+			continue
+		}
+		var err error
+		for _, urlData := range req.records {
+			if err = saver.encoder.Encode(urlData); err != nil {
+				break
+			}
+		}
+		pending = append(pending, req)
+		pendingErrs = append(pendingErrs, err)
+	}
+	flush()
+	return saver
+}
+
+// recover загружает журнал и восстанавливает по нему индексы в обе стороны.
+// Недописанный хвост после сбоя (строка без перевода строки или нечитаемые строки в самом конце)
+// обрезается; повреждённая запись, за которой идут целые, считается ошибкой
+func (storage *LocalURLStorage) recover() error {
+	// резерв читается первым: ссылки с этими кодами, оставшиеся в журнале после
+	// прерванного сжатия, уже удалены или истекли и не восстанавливаются
+	if err := storage.loadReservations(); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(storage.filename, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	now := time.Now()
+	var offset, validEnd int64
+	var lineNum, badLineNum int
+	var badLineErr error
+	var tombstones []string
+	always := func(link *localURL) bool { return true }
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if len(line) == 0 || line[len(line)-1] != '\n' {
+			// строка без перевода строки могла остаться только от прерванной записи
+			break
+		}
+		lineNum++
+		offset += int64(len(line))
+		var urlData URLData
+		if err := json.Unmarshal(bytes.TrimSpace(line), &urlData); err != nil {
+			if badLineErr == nil {
+				badLineNum, badLineErr = lineNum, err
+			}
+			continue
+		}
+		if badLineErr != nil {
+			return fmt.Errorf("storage file %s is corrupted at line %d: %w", storage.filename, badLineNum, badLineErr)
+		}
+		validEnd = offset
+		if uuid, err := strconv.ParseUint(urlData.UUID, 10, 64); err == nil && uuid > storage.lastUUID.Load() {
+			storage.lastUUID.Store(uuid)
+		}
+		if urlData.IsDeleted {
+			// удаление освобождает исходную ссылку, и следующие записи могут сократить её заново,
+			// поэтому оно применяется сразу; запись об удалении, обогнавшая в журнале саму ссылку,
+			// применяется после загрузки всех ссылок
+			if _, ok := storage.markDeleted(urlData.ShortURL, always); !ok {
+				tombstones = append(tombstones, urlData.ShortURL)
+			}
+			continue
+		}
+		if urlData.IsExpired(now) {
+			// истёкшая ссылка восстанавливается надгробием, чтобы её код не выдали заново
+			urlData.OriginalURL = ""
+		}
+		storage.restore(&urlData)
+	}
+	for _, shortURL := range tombstones {
+		storage.markDeleted(shortURL, always)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == validEnd {
+		return nil
+	}
+	log.Printf("Truncating torn tail of storage file %s: %d bytes", storage.filename, info.Size()-validEnd)
+	if err := file.Truncate(validEnd); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// restore добавляет ссылку из журнала; более поздняя запись вытесняет прежнюю с тем же кодом или адресом
+func (storage *LocalURLStorage) restore(urlData *URLData) {
+	if storage.isReserved(urlData.ShortURL) {
+		return
+	}
+	always := func(link *localURL) bool { return true }
+	storage.removeIf(urlData.ShortURL, always)
+	if urlData.OriginalURL == "" {
+		storage.insert(urlData)
+		return
+	}
+	// загрузка идёт до запуска сервиса, поэтому индекс читается напрямую, без контекста запроса
+	originals := storage.originals.shard(urlData.OriginalURL)
+	originals.mu.RLock()
+	shortURL, found := originals.values[urlData.OriginalURL]
+	originals.mu.RUnlock()
+	if found {
+		storage.removeIf(shortURL, always)
+	}
+	storage.insert(urlData)
+}
+
+// startCompaction запускает периодическое сжатие журнала, если оно включено
+func (storage *LocalURLStorage) startCompaction() {
+	if storage.options.CompactInterval <= 0 {
+		return
+	}
+	storage.stop = make(chan struct{})
+	storage.done = make(chan struct{})
+	go func() {
+		defer close(storage.done)
+		ticker := time.NewTicker(storage.options.CompactInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-storage.stop:
+				return
+			case <-ticker.C:
+				if err := storage.Compact(); err != nil {
+					log.Printf("Error in compacting storage file: %s", err.Error())
+				}
+			}
+		}
+	}()
+}
+
+// Compact перезаписывает журнал, оставляя только действующие ссылки, и убирает удалённые
+// и истёкшие ссылки из памяти. Их коды остаются занятыми: они сохраняются в файл резерва
+// раньше, чем подменяется журнал. Новый журнал пишется во временный файл и атомарно
+// подменяет старый, так что сбой в процессе оставляет на диске прежний журнал.
+func (storage *LocalURLStorage) Compact() error {
+	if storage.log == nil {
+		return nil
+	}
+	// пока идёт сжатие, изменения ждут, иначе они попали бы в заменяемый файл
+	storage.logMu.Lock()
+	defer storage.logMu.Unlock()
+
+	now := time.Now()
+	stale := func(link *localURL) bool { return link.deleted || link.isExpired(now) }
+	var records []*URLData
+	var dropped []*reservedCode
+	for i := range storage.links.shards {
+		links := &storage.links.shards[i]
+		links.mu.RLock()
+		for shortURL, link := range links.values {
+			if !stale(link) {
+				records = append(records, link.toURLData(shortURL))
+				continue
+			}
+			code := &reservedCode{ShortURL: shortURL}
+			if !link.deleted {
+				expiresAt := *link.expiresAt
+				code.ExpiresAt = &expiresAt
+			}
+			dropped = append(dropped, code)
+		}
+		links.mu.RUnlock()
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ShortURL < records[j].ShortURL })
+	for i, urlData := range records {
+		urlData.UUID = strconv.Itoa(i + 1)
+	}
+
+	reservations := append(storage.reservations(), dropped...)
+	if err := writeReservations(storage.filename+reservedSuffix, reservations); err != nil {
+		return err
+	}
+	err := storage.log.do(logRequest{fn: func(saver *URLStorageFileSaver) (*URLStorageFileSaver, error) {
+		return rewriteLog(storage.filename, saver, records)
+	}})
+	if err != nil {
+		return err
+	}
+	for _, code := range dropped {
+		storage.reserve(code)
+		storage.removeIf(code.ShortURL, stale)
+	}
+	storage.lastUUID.Store(uint64(len(records)))
+	return nil
+}
+
+// writeReservations атомарно перезаписывает файл резерва кодов
+func writeReservations(filename string, codes []*reservedCode) error {
+	sort.Slice(codes, func(i, j int) bool { return codes[i].ShortURL < codes[j].ShortURL })
+	tmpName := filename + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, code := range codes {
+		if err = encoder.Encode(code); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, filename)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	syncDir(filepath.Dir(filename))
+	return nil
+}
+
+// loadReservations читает файл резерва кодов; файла нет, пока журнал ни разу не сжимался
+func (storage *LocalURLStorage) loadReservations() error {
+	file, err := os.Open(storage.filename + reservedSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	for {
+		var code reservedCode
+		err := decoder.Decode(&code)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reserved codes file %s is corrupted: %w", file.Name(), err)
+		}
+		storage.reserve(&code)
+	}
+}
+
+// rewriteLog записывает новый журнал рядом со старым и подменяет его переименованием.
+// Дописывать дальше нужно в новый файл, поэтому его дескриптор открывается до переименования
+// и переезжает вместе с файлом. При ошибке до переименования возвращается прежний saver,
+// и журнал продолжает дописываться в старый файл; после переименования ошибок уже нет
+func rewriteLog(filename string, saver *URLStorageFileSaver, records []*URLData) (*URLStorageFileSaver, error) {
+	tmpName := filename + ".compact"
+	// файл мог остаться от прерванного сжатия, а дописывать в него нельзя
+	if err := os.Remove(tmpName); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return saver, err
+	}
+	newSaver, err := newStorageSaver(tmpName)
+	if err != nil {
+		return saver, err
+	}
+	for _, urlData := range records {
+		if err = newSaver.encoder.Encode(urlData); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = newSaver.writer.Flush()
+	}
+	if err == nil {
+		err = newSaver.file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpName, filename)
+	}
+	if err != nil {
+		newSaver.file.Close()
+		os.Remove(tmpName)
+		return saver, err
+	}
+	syncDir(filepath.Dir(filename))
+	saver.file.Close()
+	return newSaver, nil
+}
+
+// syncDir сбрасывает на диск каталог, чтобы переименование файла пережило сбой питания
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	d.Sync()
+}
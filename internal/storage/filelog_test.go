@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStorageRecovery(t *testing.T) {
+	const validLog = `{"uuid":"1","short_url":"a1","original_url":"https://a","user_id":"u1","is_deleted":false}
+{"uuid":"2","short_url":"b2","original_url":"https://b","user_id":"u1","is_deleted":false}
+{"uuid":"","short_url":"b2","original_url":"https://b","user_id":"u1","is_deleted":true}
+{"uuid":"3","short_url":"b3","original_url":"https://b","user_id":"u1","is_deleted":false}
+`
+	tests := []struct {
+		name     string
+		content  string
+		wantErr  string
+		wantSize int
+	}{
+		{
+			name:     "clean log",
+			content:  validLog,
+			wantSize: len(validLog),
+		},
+		{
+			name:     "torn last record",
+			content:  validLog + `{"uuid":"3","short_url":"c3","orig`,
+			wantSize: len(validLog),
+		},
+		{
+			name:     "garbage lines at the end",
+			content:  validLog + "\x00\x00\x00\n{\"uuid\":\n",
+			wantSize: len(validLog),
+		},
+		{
+			name:    "corrupted record in the middle",
+			content: "not json\n" + validLog,
+			wantErr: "corrupted at line 1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			filename := filepath.Join(t.TempDir(), "urls.json")
+			require.NoError(t, os.WriteFile(filename, []byte(test.content), 0o600))
+
+			s, err := NewFileURLStorage(filename, FileOptions{SyncPolicy: SyncAlways})
+			if test.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			defer s.Close()
+
+			info, err := os.Stat(filename)
+			require.NoError(t, err)
+			assert.Equal(t, int64(test.wantSize), info.Size())
+
+			// индекс исходных ссылок восстановлен, поэтому повторное сокращение — конфликт
+			assert.ErrorIs(t, s.Save(ctx, &URLData{ShortURL: "zz", OriginalURL: "https://a"}), ErrConflict)
+			_, err = s.GetOriginalURL(ctx, "b2")
+			assert.ErrorIs(t, err, ErrDeleted)
+			// удалённая ссылка освободила исходную, и её сократили заново другим кодом
+			shortURL, err := s.GetShortURL(ctx, "https://b")
+			require.NoError(t, err)
+			assert.Equal(t, "b3", shortURL)
+			require.NoError(t, s.Save(ctx, &URLData{ShortURL: "c3", OriginalURL: "https://c"}))
+		})
+	}
+}
+
+func TestFileStorageCompact(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "urls.json")
+	s, err := NewFileURLStorage(filename, FileOptions{SyncPolicy: SyncNever})
+	require.NoError(t, err)
+
+	expired := time.Now().Add(-time.Minute)
+	later := time.Now().Add(time.Hour).Truncate(time.Second)
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, s.SaveBatch(ctx, []*URLData{
+		{ShortURL: "a1", OriginalURL: "https://a", UserID: "u1", CreatedAt: &created},
+		{ShortURL: "b2", OriginalURL: "https://b", UserID: "u1"},
+		{ShortURL: "c3", OriginalURL: "https://c", ExpiresAt: &later},
+		{ShortURL: "d4", OriginalURL: "https://d", ExpiresAt: &expired},
+	}))
+	require.NoError(t, s.DeleteURLs(ctx, []DeleteTask{{UserID: "u1", ShortURL: "b2"}}))
+	before, err := os.Stat(filename)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Compact())
+	after, err := os.Stat(filename)
+	require.NoError(t, err)
+	assert.Less(t, after.Size(), before.Size())
+	content, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"short_url":"a1"`)
+	assert.Contains(t, lines[1], `"short_url":"c3"`)
+	// коды удалённой и истёкшей ссылок остаются занятыми, но без самих записей
+	reserved, err := os.ReadFile(filename + reservedSuffix)
+	require.NoError(t, err)
+	lines = strings.Split(strings.TrimSpace(string(reserved)), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, `{"short_url":"b2"}`, lines[0])
+	assert.Contains(t, lines[1], `"short_url":"d4"`)
+	assert.NotContains(t, string(reserved), "https://")
+	_, err = os.Stat(filename + ".compact")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// после сжатия журнал продолжает дописываться в новый файл
+	require.NoError(t, s.Save(ctx, &URLData{ShortURL: "e5", OriginalURL: "https://e"}))
+	s.Close()
+
+	s, err = NewFileURLStorage(filename, FileOptions{SyncPolicy: SyncNever})
+	require.NoError(t, err)
+	defer s.Close()
+	for shortURL, originalURL := range map[string]string{"a1": "https://a", "c3": "https://c", "e5": "https://e"} {
+		got, err := s.GetOriginalURL(ctx, shortURL)
+		require.NoError(t, err, shortURL)
+		assert.Equal(t, originalURL, got)
+	}
+	urlData, err := s.GetURLData(ctx, "c3")
+	require.NoError(t, err)
+	assert.True(t, later.Equal(*urlData.ExpiresAt))
+	// время создания, не заданное явно, хранилище проставило само
+	require.NotNil(t, urlData.CreatedAt)
+	assert.WithinDuration(t, time.Now(), *urlData.CreatedAt, time.Minute)
+	urlData, err = s.GetURLData(ctx, "a1")
+	require.NoError(t, err)
+	assert.True(t, created.Equal(*urlData.CreatedAt))
+	urlsData, err := s.GetURLsByUser(ctx, "u1")
+	require.NoError(t, err)
+	assert.Len(t, urlsData, 1)
+
+	_, err = s.GetOriginalURL(ctx, "b2")
+	assert.ErrorIs(t, err, ErrDeleted)
+	require.NoError(t, s.Save(ctx, &URLData{ShortURL: "b3", OriginalURL: "https://b"}))
+	_, err = s.GetOriginalURL(ctx, "d4")
+	assert.ErrorIs(t, err, ErrExpired)
+	assert.ErrorIs(t, s.Save(ctx, &URLData{ShortURL: "d4", OriginalURL: "https://f"}), ErrShortURLTaken)
+	require.NoError(t, s.Save(ctx, &URLData{ShortURL: "f6", OriginalURL: "https://d"}))
+}
+
+func TestFileStorageCompactFailure(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "urls.json")
+	s, err := NewFileURLStorage(filename, FileOptions{SyncPolicy: SyncNever})
+	require.NoError(t, err)
+	require.NoError(t, s.Save(ctx, &URLData{ShortURL: "a1", OriginalURL: "https://a"}))
+
+	// временный файл сжатия не удаётся создать
+	require.NoError(t, os.MkdirAll(filepath.Join(filename+".compact", "busy"), 0o755))
+	require.Error(t, s.Compact())
+
+	// журнал продолжает дописываться в тот же файл и переживает перезапуск
+	require.NoError(t, s.Save(ctx, &URLData{ShortURL: "b2", OriginalURL: "https://b"}))
+	s.Close()
+	s, err = NewFileURLStorage(filename, FileOptions{SyncPolicy: SyncNever})
+	require.NoError(t, err)
+	defer s.Close()
+	for _, shortURL := range []string{"a1", "b2"} {
+		_, err := s.GetOriginalURL(ctx, shortURL)
+		assert.NoError(t, err, shortURL)
+	}
+}
+
+func TestFileStorageBackgroundCompaction(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "urls.json")
+	s, err := NewFileURLStorage(filename, FileOptions{
+		SyncPolicy:      SyncInterval,
+		SyncInterval:    10 * time.Millisecond,
+		CompactInterval: 20 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Save(ctx, &URLData{ShortURL: "a1", OriginalURL: "https://a", UserID: "u1"}))
+	require.NoError(t, s.DeleteURLs(ctx, []DeleteTask{{UserID: "u1", ShortURL: "a1"}}))
+
+	assert.Eventually(t, func() bool {
+		content, err := os.ReadFile(filename)
+		return err == nil && len(content) == 0
+	}, time.Second, 10*time.Millisecond)
+	s.Close()
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hessayon/ya_practicum_go/internal/metrics"
+)
+
+// InstrumentedStorage — обёртка над хранилищем, которая замеряет длительность операций
+// и считает сбои в метриках с меткой backend
+type InstrumentedStorage struct {
+	URLStorage
+	backend string
+}
+
+func NewInstrumentedStorage(s URLStorage, backend string) *InstrumentedStorage {
+	return &InstrumentedStorage{URLStorage: s, backend: backend}
+}
+
+// observe записывает метрики операции; ожидаемые ошибки (не найдено, конфликт и т.п.) сбоями не считаются
+func (s *InstrumentedStorage) observe(operation string, start time.Time, err error) {
+	metrics.StorageOperationDuration.Observe(time.Since(start).Seconds(), s.backend, operation)
+	if err != nil && !isExpectedError(err) {
+		metrics.StorageErrors.Inc(s.backend, operation)
+	}
+}
+
+func isExpectedError(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrConflict) || errors.Is(err, ErrShortURLTaken) ||
+		errors.Is(err, ErrDeleted) || errors.Is(err, ErrExpired)
+}
+
+func (s *InstrumentedStorage) Save(ctx context.Context, urlData *URLData) (err error) {
+	defer func(start time.Time) { s.observe("save", start, err) }(time.Now())
+	return s.URLStorage.Save(ctx, urlData)
+}
+
+func (s *InstrumentedStorage) SaveBatch(ctx context.Context, urlsBatch []*URLData) (err error) {
+	defer func(start time.Time) { s.observe("save_batch", start, err) }(time.Now())
+	return s.URLStorage.SaveBatch(ctx, urlsBatch)
+}
+
+func (s *InstrumentedStorage) GetOriginalURL(ctx context.Context, shortURL string) (value string, err error) {
+	defer func(start time.Time) { s.observe("get_original_url", start, err) }(time.Now())
+	return s.URLStorage.GetOriginalURL(ctx, shortURL)
+}
+
+func (s *InstrumentedStorage) GetURLData(ctx context.Context, shortURL string) (urlData *URLData, err error) {
+	defer func(start time.Time) { s.observe("get_url_data", start, err) }(time.Now())
+	return s.URLStorage.GetURLData(ctx, shortURL)
+}
+
+func (s *InstrumentedStorage) GetShortURL(ctx context.Context, originalURL string) (value string, err error) {
+	defer func(start time.Time) { s.observe("get_short_url", start, err) }(time.Now())
+	return s.URLStorage.GetShortURL(ctx, originalURL)
+}
+
+func (s *InstrumentedStorage) GetURLsByUser(ctx context.Context, userID string) (urlsData []*URLData, err error) {
+	defer func(start time.Time) { s.observe("get_urls_by_user", start, err) }(time.Now())
+	return s.URLStorage.GetURLsByUser(ctx, userID)
+}
+
+func (s *InstrumentedStorage) DeleteURLs(ctx context.Context, tasks []DeleteTask) (err error) {
+	defer func(start time.Time) { s.observe("delete_urls", start, err) }(time.Now())
+	return s.URLStorage.DeleteURLs(ctx, tasks)
+}
+
+func (s *InstrumentedStorage) PurgeExpired(ctx context.Context, retention time.Duration) (purged int, freed []string, err error) {
+	defer func(start time.Time) { s.observe("purge_expired", start, err) }(time.Now())
+	return s.URLStorage.PurgeExpired(ctx, retention)
+}
@@ -0,0 +1,397 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// бакеты файла хранилища: сама запись по короткому коду и индексы по ней
+var (
+	urlsBucket      = []byte("urls")      // короткий код → URLData в JSON
+	originalsBucket = []byte("originals") // исходная ссылка → короткий код
+	ownersBucket    = []byte("owners")    // userID \x00 короткий код → пусто
+	expiresBucket   = []byte("expires")   // момент истечения (unix nano, big endian) + короткий код → пусто
+	// tombstonesBucket — те же ключи для истёкших ссылок, у которых уже стёрта исходная ссылка
+	tombstonesBucket = []byte("tombstones")
+)
+
+// URLKVStorage — хранилище ссылок во встроенном B+-дереве bbolt.
+// Данные не загружаются в память при старте, поэтому запуск не зависит от размера файла.
+// Сам bbolt контекст не принимает, поэтому отменённый контекст проверяется перед транзакцией
+// и между записями батча; ошибка из транзакции откатывает её целиком
+type URLKVStorage struct {
+	db *bolt.DB
+}
+
+func NewKVURLStorage(filename string) (URLStorage, error) {
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{urlsBucket, originalsBucket, ownersBucket, expiresBucket, tombstonesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &URLKVStorage{db: db}, nil
+}
+
+func ownerKey(userID, shortURL string) []byte {
+	return []byte(userID + "\x00" + shortURL)
+}
+
+func expiresKey(expiresAt time.Time, shortURL string) []byte {
+	key := make([]byte, 8, 8+len(shortURL))
+	binary.BigEndian.PutUint64(key, uint64(expiresAt.UnixNano()))
+	return append(key, shortURL...)
+}
+
+func getKVURLData(tx *bolt.Tx, shortURL string) (*URLData, error) {
+	value := tx.Bucket(urlsBucket).Get([]byte(shortURL))
+	if value == nil {
+		return nil, ErrNotFound
+	}
+	var urlData URLData
+	if err := json.Unmarshal(value, &urlData); err != nil {
+		return nil, err
+	}
+	return &urlData, nil
+}
+
+func putKVURLData(tx *bolt.Tx, urlData *URLData) error {
+	value, err := json.Marshal(urlData)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(urlsBucket).Put([]byte(urlData.ShortURL), value)
+}
+
+func saveKV(tx *bolt.Tx, urlData *URLData) error {
+	urls := tx.Bucket(urlsBucket)
+	originals := tx.Bucket(originalsBucket)
+	if existing := originals.Get([]byte(urlData.OriginalURL)); existing != nil {
+		// индекс может указывать на истёкшую ссылку, до которой ещё не дошёл сборщик, или,
+		// в файлах, записанных до того, как удаление стало освобождать исходную ссылку, на удалённую
+		stale, err := getKVURLData(tx, string(existing))
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if err == nil && !stale.IsDeleted && !stale.IsExpired(time.Now()) {
+			return ErrConflict
+		}
+	}
+	if urls.Get([]byte(urlData.ShortURL)) != nil {
+		return ErrShortURLTaken
+	}
+	if urlData.UUID == "" {
+		id, err := urls.NextSequence()
+		if err != nil {
+			return err
+		}
+		urlData.UUID = strconv.FormatUint(id, 10)
+	}
+	if err := putKVURLData(tx, urlData); err != nil {
+		return err
+	}
+	if err := originals.Put([]byte(urlData.OriginalURL), []byte(urlData.ShortURL)); err != nil {
+		return err
+	}
+	if urlData.UserID != "" {
+		if err := tx.Bucket(ownersBucket).Put(ownerKey(urlData.UserID, urlData.ShortURL), nil); err != nil {
+			return err
+		}
+	}
+	if urlData.ExpiresAt != nil {
+		if err := tx.Bucket(expiresBucket).Put(expiresKey(*urlData.ExpiresAt, urlData.ShortURL), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (storage *URLKVStorage) Save(ctx context.Context, urlData *URLData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	stampCreatedAt([]*URLData{urlData}, time.Now())
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		return saveKV(tx, urlData)
+	})
+}
+
+// SaveBatch сохраняет пачку в одной транзакции: при ошибке не сохраняется ничего.
+// Конфликт записи не прерывает проход, и ошибка сообщает конфликты всех записей сразу;
+// saveKV ничего не пишет до проверок уникальности, поэтому продолжать после конфликта безопасно
+func (storage *URLKVStorage) SaveBatch(ctx context.Context, urlsBatch []*URLData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	stampCreatedAt(urlsBatch, time.Now())
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		savedOriginals := make(map[string]bool, len(urlsBatch))
+		var batchErr *BatchError
+		for i, urlData := range urlsBatch {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			err := saveKV(tx, urlData)
+			switch {
+			case err == nil:
+				savedOriginals[urlData.OriginalURL] = true
+			case isBatchConflict(err):
+				// ссылка, сохранённая этим же батчем, исчезнет при откате, её код не сообщается
+				var existing string
+				if errors.Is(err, ErrConflict) && !savedOriginals[urlData.OriginalURL] {
+					existing = string(tx.Bucket(originalsBucket).Get([]byte(urlData.OriginalURL)))
+				}
+				batchErr = addBatchConflict(batchErr, i, err, existing)
+			default:
+				return &BatchError{Index: i, Err: err}
+			}
+		}
+		if batchErr != nil {
+			return batchErr
+		}
+		return nil
+	})
+}
+
+func (storage *URLKVStorage) GetOriginalURL(ctx context.Context, shortURL string) (string, error) {
+	urlData, err := storage.GetURLData(ctx, shortURL)
+	if err != nil {
+		return "", err
+	}
+	return urlData.OriginalURL, nil
+}
+
+func (storage *URLKVStorage) GetURLData(ctx context.Context, shortURL string) (*URLData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var urlData *URLData
+	err := storage.db.View(func(tx *bolt.Tx) error {
+		var err error
+		urlData, err = getKVURLData(tx, shortURL)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if urlData.IsDeleted {
+		return urlData, ErrDeleted
+	}
+	if urlData.IsExpired(time.Now()) {
+		return urlData, ErrExpired
+	}
+	return urlData, nil
+}
+
+// GetShortURL ищет только действующую ссылку: код удалённой или истёкшей ответил бы 410
+func (storage *URLKVStorage) GetShortURL(ctx context.Context, originalURL string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	var shortURL string
+	err := storage.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(originalsBucket).Get([]byte(originalURL))
+		if value == nil {
+			return ErrNotFound
+		}
+		urlData, err := getKVURLData(tx, string(value))
+		if err != nil {
+			return err
+		}
+		if urlData.IsDeleted || urlData.IsExpired(time.Now()) {
+			return ErrNotFound
+		}
+		shortURL = urlData.ShortURL
+		return nil
+	})
+	return shortURL, err
+}
+
+func (storage *URLKVStorage) GetURLsByUser(ctx context.Context, userID string) ([]*URLData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var urlsData []*URLData
+	now := time.Now()
+	prefix := ownerKey(userID, "")
+	err := storage.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(ownersBucket).Cursor()
+		for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+			urlData, err := getKVURLData(tx, string(key[len(prefix):]))
+			if err != nil {
+				return err
+			}
+			if urlData.IsDeleted || urlData.IsExpired(now) {
+				continue
+			}
+			urlsData = append(urlsData, urlData)
+		}
+		return nil
+	})
+	return urlsData, err
+}
+
+func (storage *URLKVStorage) DeleteURLs(ctx context.Context, tasks []DeleteTask) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		for _, task := range tasks {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			urlData, err := getKVURLData(tx, task.ShortURL)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			// удалить ссылку может только её владелец
+			if urlData.UserID != task.UserID || urlData.IsDeleted {
+				continue
+			}
+			urlData.IsDeleted = true
+			if err := putKVURLData(tx, urlData); err != nil {
+				return err
+			}
+			// исходную ссылку удалённой ссылки можно сократить заново
+			if err := freeKVOriginal(tx, urlData); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PurgeExpired проходит индексы сроков жизни от самых ранних: надгробия старше retention
+// удаляются, а истёкшие ссылки становятся надгробиями и переезжают в tombstonesBucket
+func (storage *URLKVStorage) PurgeExpired(ctx context.Context, retention time.Duration) (int, []string, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+	purged := 0
+	var freed []string
+	now := time.Now()
+	err := storage.db.Update(func(tx *bolt.Tx) error {
+		limit := expiresKey(now.Add(-retention), "")
+		for _, bucket := range [][]byte{tombstonesBucket, expiresBucket} {
+			cursor := tx.Bucket(bucket).Cursor()
+			for key, _ := cursor.First(); key != nil && bytes.Compare(key[:8], limit) <= 0; key, _ = cursor.First() {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				shortURL := string(key[8:])
+				if err := cursor.Delete(); err != nil {
+					return err
+				}
+				urlData, err := getKVURLData(tx, shortURL)
+				if errors.Is(err, ErrNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if err := removeKV(tx, urlData); err != nil {
+					return err
+				}
+				purged++
+				freed = append(freed, shortURL)
+			}
+		}
+
+		limit = expiresKey(now, "")
+		cursor := tx.Bucket(expiresBucket).Cursor()
+		for key, _ := cursor.First(); key != nil && bytes.Compare(key[:8], limit) <= 0; key, _ = cursor.First() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			tombstoneKey := append([]byte(nil), key...)
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+			if err := tx.Bucket(tombstonesBucket).Put(tombstoneKey, nil); err != nil {
+				return err
+			}
+			urlData, err := getKVURLData(tx, string(tombstoneKey[8:]))
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := dropKVDestination(tx, urlData); err != nil {
+				return err
+			}
+			purged++
+		}
+		return nil
+	})
+	if err != nil {
+		// транзакция откатилась, ни один код не освобождён
+		return 0, nil, err
+	}
+	return purged, freed, nil
+}
+
+// dropKVDestination стирает исходную ссылку надгробия, код при этом остаётся занятым
+func dropKVDestination(tx *bolt.Tx, urlData *URLData) error {
+	if err := freeKVOriginal(tx, urlData); err != nil {
+		return err
+	}
+	urlData.OriginalURL = ""
+	return putKVURLData(tx, urlData)
+}
+
+// freeKVOriginal убирает исходную ссылку из индекса, если она всё ещё указывает на этот код
+func freeKVOriginal(tx *bolt.Tx, urlData *URLData) error {
+	originals := tx.Bucket(originalsBucket)
+	if string(originals.Get([]byte(urlData.OriginalURL))) != urlData.ShortURL {
+		return nil
+	}
+	return originals.Delete([]byte(urlData.OriginalURL))
+}
+
+func removeKV(tx *bolt.Tx, urlData *URLData) error {
+	if err := tx.Bucket(urlsBucket).Delete([]byte(urlData.ShortURL)); err != nil {
+		return err
+	}
+	if err := freeKVOriginal(tx, urlData); err != nil {
+		return err
+	}
+	if urlData.UserID != "" {
+		return tx.Bucket(ownersBucket).Delete(ownerKey(urlData.UserID, urlData.ShortURL))
+	}
+	return nil
+}
+
+// Check проверяет, что файл хранилища открыт и читается
+func (storage *URLKVStorage) Check(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return storage.db.View(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+func (storage *URLKVStorage) Close() {
+	storage.db.Close()
+}
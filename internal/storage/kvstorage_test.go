@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVURLStorage(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "urls.db")
+	s, err := NewKVURLStorage(filename)
+	require.NoError(t, err)
+
+	expired := time.Now().Add(-time.Minute)
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, s.Save(ctx, &URLData{ShortURL: "a1", OriginalURL: "https://a", UserID: "u1"}))
+	require.NoError(t, s.SaveBatch(ctx, []*URLData{
+		{ShortURL: "b2", OriginalURL: "https://b", UserID: "u1", ExpiresAt: &later},
+		{ShortURL: "c3", OriginalURL: "https://c", UserID: "u2", ExpiresAt: &expired},
+	}))
+
+	assert.ErrorIs(t, s.Save(ctx, &URLData{ShortURL: "zz", OriginalURL: "https://a"}), ErrConflict)
+	assert.ErrorIs(t, s.Save(ctx, &URLData{ShortURL: "a1", OriginalURL: "https://z"}), ErrShortURLTaken)
+	// пачка с конфликтом не сохраняется целиком
+	err = s.SaveBatch(ctx, []*URLData{
+		{ShortURL: "d4", OriginalURL: "https://d"},
+		{ShortURL: "a1", OriginalURL: "https://e"},
+	})
+	assert.ErrorIs(t, err, ErrShortURLTaken)
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, 1, batchErr.Index)
+	assert.Equal(t, []int{1}, batchErr.Taken)
+	_, err = s.GetOriginalURL(ctx, "d4")
+	assert.ErrorIs(t, err, ErrNotFound)
+	// конфликты всех записей сообщаются разом
+	err = s.SaveBatch(ctx, []*URLData{
+		{ShortURL: "d4", OriginalURL: "https://d"},
+		{ShortURL: "e5", OriginalURL: "https://a"},
+		{ShortURL: "a1", OriginalURL: "https://f"},
+		{ShortURL: "g7", OriginalURL: "https://b"},
+	})
+	assert.ErrorIs(t, err, ErrConflict)
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, 1, batchErr.Index)
+	assert.Equal(t, map[int]string{1: "a1", 3: "b2"}, batchErr.Existing)
+	assert.Equal(t, []int{2}, batchErr.Taken)
+
+	originalURL, err := s.GetOriginalURL(ctx, "a1")
+	require.NoError(t, err)
+	assert.Equal(t, "https://a", originalURL)
+	shortURL, err := s.GetShortURL(ctx, "https://b")
+	require.NoError(t, err)
+	assert.Equal(t, "b2", shortURL)
+	_, err = s.GetOriginalURL(ctx, "c3")
+	assert.ErrorIs(t, err, ErrExpired)
+	// до сборщика истёкшая ссылка уже не находится по исходной
+	_, err = s.GetShortURL(ctx, "https://c")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	urlsData, err := s.GetURLsByUser(ctx, "u1")
+	require.NoError(t, err)
+	require.Len(t, urlsData, 2)
+	assert.Equal(t, "a1", urlsData[0].ShortURL)
+	assert.Equal(t, "b2", urlsData[1].ShortURL)
+
+	// чужую ссылку удалить нельзя
+	require.NoError(t, s.DeleteURLs(ctx, []DeleteTask{{UserID: "u2", ShortURL: "a1"}, {UserID: "u1", ShortURL: "b2"}}))
+	_, err = s.GetOriginalURL(ctx, "a1")
+	assert.NoError(t, err)
+	_, err = s.GetOriginalURL(ctx, "b2")
+	assert.ErrorIs(t, err, ErrDeleted)
+	// удаление освобождает исходную ссылку, но не код
+	_, err = s.GetShortURL(ctx, "https://b")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, s.Save(ctx, &URLData{ShortURL: "b2", OriginalURL: "https://z"}), ErrShortURLTaken)
+	require.NoError(t, s.Save(ctx, &URLData{ShortURL: "b3", OriginalURL: "https://b", UserID: "u1"}))
+
+	// истёкшая ссылка становится надгробием: код занят, а исходную ссылку можно сократить заново
+	purged, freed, err := s.PurgeExpired(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.Empty(t, freed)
+	_, err = s.GetOriginalURL(ctx, "c3")
+	assert.ErrorIs(t, err, ErrExpired)
+	_, err = s.GetShortURL(ctx, "https://c")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, s.Save(ctx, &URLData{ShortURL: "c3", OriginalURL: "https://z"}), ErrShortURLTaken)
+	require.NoError(t, s.Save(ctx, &URLData{ShortURL: "c4", OriginalURL: "https://c"}))
+	purged, _, err = s.PurgeExpired(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+
+	// по истечении срока хранения надгробие удаляется окончательно
+	purged, freed, err = s.PurgeExpired(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	// освобождённый код возвращается, чтобы удалить и его статистику
+	assert.Equal(t, []string{"c3"}, freed)
+	_, err = s.GetOriginalURL(ctx, "c3")
+	assert.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, s.Check(ctx))
+	s.Close()
+	assert.Error(t, s.Check(ctx))
+
+	// после переоткрытия файла данные на месте
+	s, err = NewKVURLStorage(filename)
+	require.NoError(t, err)
+	defer s.Close()
+	originalURL, err = s.GetOriginalURL(ctx, "a1")
+	require.NoError(t, err)
+	assert.Equal(t, "https://a", originalURL)
+	_, err = s.GetOriginalURL(ctx, "b2")
+	assert.ErrorIs(t, err, ErrDeleted)
+}
+
+func TestKVURLStorageCanceled(t *testing.T) {
+	s, err := NewKVURLStorage(filepath.Join(t.TempDir(), "urls.db"))
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Save(context.Background(), &URLData{ShortURL: "a1", OriginalURL: "https://a", UserID: "u1"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, s.Save(ctx, &URLData{ShortURL: "b2", OriginalURL: "https://b"}), context.Canceled)
+	assert.ErrorIs(t, s.SaveBatch(ctx, []*URLData{{ShortURL: "c3", OriginalURL: "https://c"}}), context.Canceled)
+	assert.ErrorIs(t, s.DeleteURLs(ctx, []DeleteTask{{UserID: "u1", ShortURL: "a1"}}), context.Canceled)
+	_, err = s.GetOriginalURL(ctx, "a1")
+	assert.ErrorIs(t, err, context.Canceled)
+	_, _, err = s.PurgeExpired(ctx, time.Hour)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// отменённые операции ничего не изменили
+	originalURL, err := s.GetOriginalURL(context.Background(), "a1")
+	require.NoError(t, err)
+	assert.Equal(t, "https://a", originalURL)
+	_, err = s.GetOriginalURL(context.Background(), "b2")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.GetOriginalURL(context.Background(), "c3")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,556 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shardCount — число сегментов каждой карты; запросы к разным сегментам не блокируют друг друга
+const shardCount = 32
+
+type shard[T any] struct {
+	mu     sync.RWMutex
+	values map[string]T
+}
+
+// shardedMap — карта, разбитая на сегменты со своими блокировками по хешу ключа
+type shardedMap[T any] struct {
+	shards [shardCount]shard[T]
+}
+
+func newShardedMap[T any]() *shardedMap[T] {
+	m := &shardedMap[T]{}
+	for i := range m.shards {
+		m.shards[i].values = make(map[string]T)
+	}
+	return m
+}
+
+func (m *shardedMap[T]) shard(key string) *shard[T] {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &m.shards[h.Sum32()%shardCount]
+}
+
+// localURL — ссылка в памяти; поля меняются только под блокировкой сегмента links
+type localURL struct {
+	originalURL string
+	userID      string
+	expiresAt   *time.Time
+	createdAt   *time.Time
+	deleted     bool
+}
+
+func (link *localURL) toURLData(shortURL string) *URLData {
+	urlData := &URLData{
+		ShortURL:    shortURL,
+		OriginalURL: link.originalURL,
+		UserID:      link.userID,
+		IsDeleted:   link.deleted,
+	}
+	if link.expiresAt != nil {
+		expiresAt := *link.expiresAt
+		urlData.ExpiresAt = &expiresAt
+	}
+	if link.createdAt != nil {
+		createdAt := *link.createdAt
+		urlData.CreatedAt = &createdAt
+	}
+	return urlData
+}
+
+func (link *localURL) isExpired(now time.Time) bool {
+	return link.expiresAt != nil && !link.expiresAt.After(now)
+}
+
+// reservedCode — код удалённой или истёкшей ссылки, которую сжатие убрало из журнала и памяти.
+// Сама ссылка больше не хранится, но код остаётся занятым и отвечает как удалённый или истёкший
+type reservedCode struct {
+	ShortURL string `json:"short_url"`
+	// ExpiresAt — момент истечения ссылки; nil — ссылка удалена
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (code *reservedCode) lookup() (*URLData, error) {
+	urlData := &URLData{ShortURL: code.ShortURL, IsDeleted: code.ExpiresAt == nil, ExpiresAt: code.ExpiresAt}
+	if urlData.IsDeleted {
+		return urlData, ErrDeleted
+	}
+	return urlData, ErrExpired
+}
+
+// LocalURLStorage — хранилище в памяти с необязательным журналом в файле.
+// Блокировки берутся в порядке originals → links, чтобы Save и удаление не взаимоблокировались
+type LocalURLStorage struct {
+	links     *shardedMap[*localURL] // короткий код → ссылка
+	originals *shardedMap[string]    // исходная ссылка → короткий код
+	owners    *shardedMap[[]string]  // userID → короткие коды
+	reserved  *shardedMap[*reservedCode]
+	lastUUID  atomic.Uint64
+	filename  string
+	options   FileOptions
+	// logMu на чтение держат изменения вместе с записью в журнал, на запись — сжатие журнала,
+	// которому нужен согласованный снимок памяти и файла
+	logMu sync.RWMutex
+	log   *logWriter
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func newLocalURLStorage(filename string, options FileOptions) *LocalURLStorage {
+	return &LocalURLStorage{
+		links:     newShardedMap[*localURL](),
+		originals: newShardedMap[string](),
+		owners:    newShardedMap[[]string](),
+		reserved:  newShardedMap[*reservedCode](),
+		filename:  filename,
+		options:   options,
+	}
+}
+
+// NewURLStorage создаёт хранилище в памяти, если filename пустой, иначе файловое хранилище,
+// которое сбрасывает журнал на диск только при закрытии
+func NewURLStorage(filename string) (URLStorage, error) {
+	storage, err := NewFileURLStorage(filename, FileOptions{SyncPolicy: SyncNever})
+	if err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
+
+func NewFileURLStorage(filename string, options FileOptions) (*LocalURLStorage, error) {
+	storage := newLocalURLStorage(filename, options)
+	if filename == "" {
+		// значит опция сохранения в файл отключена
+		return storage, nil
+	}
+	if err := storage.recover(); err != nil {
+		return nil, err
+	}
+	saver, err := newStorageSaver(filename)
+	if err != nil {
+		return nil, err
+	}
+	storage.log = newLogWriter(saver, options)
+	storage.startCompaction()
+	return storage, nil
+}
+
+// insert добавляет ссылку в индексы, проверяя уникальность исходной ссылки и кода.
+// У надгробия истёкшей ссылки исходной ссылки нет, и в индекс исходных оно не попадает
+func (storage *LocalURLStorage) insert(urlData *URLData) error {
+	originals := storage.originals.shard(urlData.OriginalURL)
+	originals.mu.Lock()
+	defer originals.mu.Unlock()
+	if shortURL, ok := originals.values[urlData.OriginalURL]; ok && urlData.OriginalURL != "" {
+		if !storage.isExpired(shortURL, time.Now()) {
+			return ErrConflict
+		}
+		// сборщик ещё не дошёл до истёкшей ссылки, исходная ссылка переходит к новой
+		delete(originals.values, urlData.OriginalURL)
+	}
+	links := storage.links.shard(urlData.ShortURL)
+	links.mu.Lock()
+	if _, ok := links.values[urlData.ShortURL]; ok || storage.isReserved(urlData.ShortURL) {
+		links.mu.Unlock()
+		return ErrShortURLTaken
+	}
+	link := &localURL{originalURL: urlData.OriginalURL, userID: urlData.UserID, deleted: urlData.IsDeleted}
+	if urlData.ExpiresAt != nil {
+		expiresAt := *urlData.ExpiresAt
+		link.expiresAt = &expiresAt
+	}
+	if urlData.CreatedAt != nil {
+		createdAt := *urlData.CreatedAt
+		link.createdAt = &createdAt
+	}
+	links.values[urlData.ShortURL] = link
+	links.mu.Unlock()
+	if urlData.OriginalURL != "" {
+		originals.values[urlData.OriginalURL] = urlData.ShortURL
+	}
+
+	if urlData.UserID != "" {
+		owners := storage.owners.shard(urlData.UserID)
+		owners.mu.Lock()
+		owners.values[urlData.UserID] = append(owners.values[urlData.UserID], urlData.ShortURL)
+		owners.mu.Unlock()
+	}
+	return nil
+}
+
+// removeIf удаляет ссылку из всех индексов, если она есть и удовлетворяет условию
+func (storage *LocalURLStorage) removeIf(shortURL string, cond func(link *localURL) bool) bool {
+	links := storage.links.shard(shortURL)
+	links.mu.RLock()
+	link, ok := links.values[shortURL]
+	var originalURL string
+	if ok {
+		originalURL = link.originalURL
+	}
+	links.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	originals := storage.originals.shard(originalURL)
+	originals.mu.Lock()
+	links.mu.Lock()
+	// пока блокировки не были взяты, ссылку могли удалить и создать заново
+	if links.values[shortURL] != link || !cond(link) {
+		links.mu.Unlock()
+		originals.mu.Unlock()
+		return false
+	}
+	delete(links.values, shortURL)
+	links.mu.Unlock()
+	if originals.values[originalURL] == shortURL {
+		delete(originals.values, originalURL)
+	}
+	originals.mu.Unlock()
+
+	if link.userID != "" {
+		owners := storage.owners.shard(link.userID)
+		owners.mu.Lock()
+		userURLs := owners.values[link.userID]
+		for i, userURL := range userURLs {
+			if userURL == shortURL {
+				userURLs = append(userURLs[:i:i], userURLs[i+1:]...)
+				break
+			}
+		}
+		if len(userURLs) == 0 {
+			delete(owners.values, link.userID)
+		} else {
+			owners.values[link.userID] = userURLs
+		}
+		owners.mu.Unlock()
+	}
+	return true
+}
+
+func (storage *LocalURLStorage) Save(ctx context.Context, urlData *URLData) error {
+	err := storage.SaveBatch(ctx, []*URLData{urlData})
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) {
+		return batchErr.Err
+	}
+	return err
+}
+
+// SaveBatch вставляет ссылки по очереди. Конфликт записи не прерывает проход: батч всё равно
+// откатывается, но ошибка сообщает конфликты всех записей, и повтор без них нужен только один.
+// Конкурентные чтения могут успеть увидеть ссылки батча, который затем будет отменён
+func (storage *LocalURLStorage) SaveBatch(ctx context.Context, urlsBatch []*URLData) error {
+	stampCreatedAt(urlsBatch, time.Now())
+	storage.logMu.RLock()
+	defer storage.logMu.RUnlock()
+	inserted := make([]*URLData, 0, len(urlsBatch))
+	insertedOriginals := make(map[string]bool, len(urlsBatch))
+	var batchErr *BatchError
+	for i, urlData := range urlsBatch {
+		err := storage.insert(urlData)
+		switch {
+		case err == nil:
+			inserted = append(inserted, urlData)
+			insertedOriginals[urlData.OriginalURL] = true
+		case isBatchConflict(err):
+			// ссылка, вставленная этим же батчем, исчезнет при откате, её код не сообщается
+			var existing string
+			if errors.Is(err, ErrConflict) && !insertedOriginals[urlData.OriginalURL] {
+				existing = storage.savedShortURL(urlData.OriginalURL)
+			}
+			batchErr = addBatchConflict(batchErr, i, err, existing)
+		default:
+			storage.rollback(inserted)
+			return &BatchError{Index: i, Err: err}
+		}
+	}
+	if batchErr != nil {
+		storage.rollback(inserted)
+		return batchErr
+	}
+	for _, urlData := range urlsBatch {
+		if urlData.UUID == "" {
+			// порядковый номер записи в файле хранилища
+			urlData.UUID = strconv.FormatUint(storage.lastUUID.Add(1), 10)
+		}
+	}
+	if err := storage.log.write(urlsBatch...); err != nil {
+		// то, чего нет в журнале, не должно остаться и в памяти
+		storage.rollback(urlsBatch)
+		return err
+	}
+	return nil
+}
+
+// savedShortURL возвращает код, под которым исходная ссылка уже сохранена
+func (storage *LocalURLStorage) savedShortURL(originalURL string) string {
+	originals := storage.originals.shard(originalURL)
+	originals.mu.RLock()
+	defer originals.mu.RUnlock()
+	return originals.values[originalURL]
+}
+
+// rollback убирает вставленные, но не сохранённые ссылки
+func (storage *LocalURLStorage) rollback(urlsBatch []*URLData) {
+	for _, urlData := range urlsBatch {
+		originalURL := urlData.OriginalURL
+		storage.removeIf(urlData.ShortURL, func(link *localURL) bool { return link.originalURL == originalURL })
+	}
+}
+
+func (storage *LocalURLStorage) GetOriginalURL(ctx context.Context, shortURL string) (string, error) {
+	urlData, err := storage.GetURLData(ctx, shortURL)
+	if err != nil {
+		return "", err
+	}
+	return urlData.OriginalURL, nil
+}
+
+func (storage *LocalURLStorage) GetURLData(ctx context.Context, shortURL string) (*URLData, error) {
+	links := storage.links.shard(shortURL)
+	links.mu.RLock()
+	defer links.mu.RUnlock()
+	link, found := links.values[shortURL]
+	if !found {
+		reserved := storage.reserved.shard(shortURL)
+		reserved.mu.RLock()
+		defer reserved.mu.RUnlock()
+		if code, ok := reserved.values[shortURL]; ok {
+			return code.lookup()
+		}
+		return nil, ErrNotFound
+	}
+	if link.deleted {
+		return link.toURLData(shortURL), ErrDeleted
+	}
+	if link.isExpired(time.Now()) {
+		return link.toURLData(shortURL), ErrExpired
+	}
+	return link.toURLData(shortURL), nil
+}
+
+// GetShortURL ищет только действующую ссылку: код истёкшей ответил бы 410
+func (storage *LocalURLStorage) GetShortURL(ctx context.Context, originalURL string) (string, error) {
+	originals := storage.originals.shard(originalURL)
+	originals.mu.RLock()
+	defer originals.mu.RUnlock()
+	shortURL, found := originals.values[originalURL]
+	if !found || storage.isExpired(shortURL, time.Now()) {
+		return "", ErrNotFound
+	}
+	return shortURL, nil
+}
+
+// isExpired сообщает, истекла ли ссылка с этим кодом; вызывается под блокировкой сегмента originals
+func (storage *LocalURLStorage) isExpired(shortURL string, now time.Time) bool {
+	links := storage.links.shard(shortURL)
+	links.mu.RLock()
+	defer links.mu.RUnlock()
+	link, ok := links.values[shortURL]
+	return ok && link.isExpired(now)
+}
+
+func (storage *LocalURLStorage) GetURLsByUser(ctx context.Context, userID string) ([]*URLData, error) {
+	owners := storage.owners.shard(userID)
+	owners.mu.RLock()
+	shortURLs := append([]string(nil), owners.values[userID]...)
+	owners.mu.RUnlock()
+
+	urlsData := make([]*URLData, 0, len(shortURLs))
+	now := time.Now()
+	for _, shortURL := range shortURLs {
+		links := storage.links.shard(shortURL)
+		links.mu.RLock()
+		link, ok := links.values[shortURL]
+		if ok && !link.deleted && !link.isExpired(now) {
+			urlsData = append(urlsData, link.toURLData(shortURL))
+		}
+		links.mu.RUnlock()
+	}
+	return urlsData, nil
+}
+
+func (storage *LocalURLStorage) DeleteURLs(ctx context.Context, tasks []DeleteTask) error {
+	storage.logMu.RLock()
+	defer storage.logMu.RUnlock()
+	tombstones := make([]*URLData, 0, len(tasks))
+	for _, task := range tasks {
+		userID := task.UserID
+		// удалить ссылку может только её владелец
+		originalURL, ok := storage.markDeleted(task.ShortURL, func(link *localURL) bool { return link.userID == userID })
+		if ok {
+			tombstones = append(tombstones, &URLData{
+				ShortURL:    task.ShortURL,
+				OriginalURL: originalURL,
+				UserID:      task.UserID,
+				IsDeleted:   true,
+			})
+		}
+	}
+	return storage.log.write(tombstones...)
+}
+
+// markDeleted помечает ссылку удалённой, если она ещё не удалена и удовлетворяет условию,
+// и освобождает её исходную ссылку, чтобы её можно было сократить заново. Код остаётся занятым
+func (storage *LocalURLStorage) markDeleted(shortURL string, cond func(link *localURL) bool) (string, bool) {
+	links := storage.links.shard(shortURL)
+	links.mu.RLock()
+	link, ok := links.values[shortURL]
+	var originalURL string
+	if ok {
+		originalURL = link.originalURL
+	}
+	links.mu.RUnlock()
+	if !ok {
+		return "", false
+	}
+
+	originals := storage.originals.shard(originalURL)
+	originals.mu.Lock()
+	defer originals.mu.Unlock()
+	links.mu.Lock()
+	defer links.mu.Unlock()
+	// пока блокировки не были взяты, ссылку могли удалить, создать заново или сделать надгробием
+	if links.values[shortURL] != link || link.originalURL != originalURL || link.deleted || !cond(link) {
+		return "", false
+	}
+	link.deleted = true
+	if originalURL != "" && originals.values[originalURL] == shortURL {
+		delete(originals.values, originalURL)
+	}
+	return originalURL, true
+}
+
+// PurgeExpired превращает истёкшие ссылки в надгробия и убирает из памяти надгробия старше
+// retention. В журнал ничего не пишется: срок жизни хранится в самой записи, и при загрузке
+// истёкшая ссылка сразу восстанавливается надгробием
+func (storage *LocalURLStorage) PurgeExpired(ctx context.Context, retention time.Duration) (int, []string, error) {
+	now := time.Now()
+	outdated := func(link *localURL) bool { return link.isExpired(now.Add(-retention)) }
+	purged := 0
+	var freed []string
+	for _, shortURL := range storage.collect(outdated) {
+		if storage.removeIf(shortURL, outdated) {
+			purged++
+			freed = append(freed, shortURL)
+		}
+	}
+	// коды истёкших ссылок, убранных сжатием, освобождаются по тому же сроку хранения
+	for i := range storage.reserved.shards {
+		reserved := &storage.reserved.shards[i]
+		reserved.mu.Lock()
+		for shortURL, code := range reserved.values {
+			if code.ExpiresAt != nil && !code.ExpiresAt.After(now.Add(-retention)) {
+				delete(reserved.values, shortURL)
+				purged++
+				freed = append(freed, shortURL)
+			}
+		}
+		reserved.mu.Unlock()
+	}
+	expired := func(link *localURL) bool { return link.originalURL != "" && link.isExpired(now) }
+	for _, shortURL := range storage.collect(expired) {
+		if storage.dropDestination(shortURL, now) {
+			purged++
+		}
+	}
+	return purged, freed, nil
+}
+
+// dropDestination стирает исходную ссылку у истёкшей ссылки: код остаётся занятым,
+// а исходную ссылку снова можно сократить
+func (storage *LocalURLStorage) dropDestination(shortURL string, now time.Time) bool {
+	links := storage.links.shard(shortURL)
+	links.mu.RLock()
+	link, ok := links.values[shortURL]
+	var originalURL string
+	if ok {
+		originalURL = link.originalURL
+	}
+	links.mu.RUnlock()
+	if !ok || originalURL == "" {
+		return false
+	}
+
+	originals := storage.originals.shard(originalURL)
+	originals.mu.Lock()
+	defer originals.mu.Unlock()
+	links.mu.Lock()
+	defer links.mu.Unlock()
+	if links.values[shortURL] != link || link.originalURL != originalURL || !link.isExpired(now) {
+		return false
+	}
+	link.originalURL = ""
+	if originals.values[originalURL] == shortURL {
+		delete(originals.values, originalURL)
+	}
+	return true
+}
+
+func (storage *LocalURLStorage) isReserved(shortURL string) bool {
+	reserved := storage.reserved.shard(shortURL)
+	reserved.mu.RLock()
+	defer reserved.mu.RUnlock()
+	_, ok := reserved.values[shortURL]
+	return ok
+}
+
+// reserve занимает код ссылки, которая убирается из памяти; резерв добавляется раньше,
+// чем ссылка удаляется, чтобы код ни на миг не оказался свободным
+func (storage *LocalURLStorage) reserve(code *reservedCode) {
+	reserved := storage.reserved.shard(code.ShortURL)
+	reserved.mu.Lock()
+	reserved.values[code.ShortURL] = code
+	reserved.mu.Unlock()
+}
+
+// reservations возвращает все зарезервированные коды
+func (storage *LocalURLStorage) reservations() []*reservedCode {
+	var codes []*reservedCode
+	for i := range storage.reserved.shards {
+		reserved := &storage.reserved.shards[i]
+		reserved.mu.RLock()
+		for _, code := range reserved.values {
+			codes = append(codes, code)
+		}
+		reserved.mu.RUnlock()
+	}
+	return codes
+}
+
+// collect возвращает коды ссылок, удовлетворяющих условию, обходя сегменты по одному
+func (storage *LocalURLStorage) collect(cond func(link *localURL) bool) []string {
+	var shortURLs []string
+	for i := range storage.links.shards {
+		links := &storage.links.shards[i]
+		links.mu.RLock()
+		for shortURL, link := range links.values {
+			if cond(link) {
+				shortURLs = append(shortURLs, shortURL)
+			}
+		}
+		links.mu.RUnlock()
+	}
+	return shortURLs
+}
+
+// Check проверяет, что файл хранилища всё ещё доступен; хранилище в памяти доступно всегда
+func (storage *LocalURLStorage) Check(ctx context.Context) error {
+	return storage.log.check()
+}
+
+func (storage *LocalURLStorage) Close() {
+	if storage.stop != nil {
+		// фоновое сжатие останавливается до закрытия журнала
+		close(storage.stop)
+		<-storage.done
+	}
+	storage.log.close()
+}
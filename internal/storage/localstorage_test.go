@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLocalStorageConcurrency нагружает хранилище параллельными записями, чтениями и удалениями;
+// запускать с -race
+func TestLocalStorageConcurrency(t *testing.T) {
+	const (
+		workers   = 8
+		perWorker = 100
+		batchSize = 5
+	)
+	tests := []struct {
+		name    string
+		file    bool
+		compact bool
+	}{
+		{name: "memory"},
+		{name: "file", file: true},
+		{name: "file with compaction", file: true, compact: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			var filename string
+			if test.file {
+				filename = filepath.Join(t.TempDir(), "urls.json")
+			}
+			s, err := NewFileURLStorage(filename, FileOptions{SyncPolicy: SyncNever})
+			require.NoError(t, err)
+
+			stop := make(chan struct{})
+			var background sync.WaitGroup
+			if test.compact {
+				background.Add(1)
+				go func() {
+					defer background.Done()
+					ticker := time.NewTicker(10 * time.Millisecond)
+					defer ticker.Stop()
+					for {
+						select {
+						case <-stop:
+							return
+						case <-ticker.C:
+							assert.NoError(t, s.Compact())
+						}
+					}
+				}()
+			}
+			// читатели запрашивают коды, которые ещё могут не существовать
+			for r := 0; r < 4; r++ {
+				background.Add(1)
+				go func(r int) {
+					defer background.Done()
+					for i := 0; ; i++ {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						shortURL := fmt.Sprintf("w%d-%d", i%workers, i%perWorker)
+						_, err := s.GetOriginalURL(ctx, shortURL)
+						if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrDeleted) {
+							assert.NoError(t, err)
+						}
+						_, err = s.GetURLsByUser(ctx, fmt.Sprintf("user%d", r))
+						assert.NoError(t, err)
+					}
+				}(r)
+			}
+
+			// все пишущие одновременно пытаются сохранить один и тот же адрес — выиграть должен один
+			var sharedWinners atomic.Int32
+			var writers sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				writers.Add(1)
+				go func(w int) {
+					defer writers.Done()
+					userID := fmt.Sprintf("user%d", w)
+					err := s.Save(ctx, &URLData{ShortURL: fmt.Sprintf("shared-%d", w), OriginalURL: "https://shared", UserID: userID})
+					if err == nil {
+						sharedWinners.Add(1)
+					} else {
+						assert.ErrorIs(t, err, ErrConflict)
+					}
+					for i := 0; i < perWorker; i += batchSize {
+						batch := make([]*URLData, 0, batchSize)
+						for j := i; j < i+batchSize; j++ {
+							batch = append(batch, &URLData{
+								ShortURL:    fmt.Sprintf("w%d-%d", w, j),
+								OriginalURL: fmt.Sprintf("https://example.com/%d/%d", w, j),
+								UserID:      userID,
+							})
+						}
+						if i%2 == 0 {
+							require.NoError(t, s.SaveBatch(ctx, batch))
+						} else {
+							for _, urlData := range batch {
+								require.NoError(t, s.Save(ctx, urlData))
+							}
+						}
+						for _, urlData := range batch {
+							originalURL, err := s.GetOriginalURL(ctx, urlData.ShortURL)
+							require.NoError(t, err)
+							assert.Equal(t, urlData.OriginalURL, originalURL)
+							shortURL, err := s.GetShortURL(ctx, urlData.OriginalURL)
+							require.NoError(t, err)
+							assert.Equal(t, urlData.ShortURL, shortURL)
+						}
+						// первую ссылку каждого батча пользователь удаляет
+						require.NoError(t, s.DeleteURLs(ctx, []DeleteTask{{UserID: userID, ShortURL: batch[0].ShortURL}}))
+					}
+				}(w)
+			}
+			writers.Wait()
+			close(stop)
+			background.Wait()
+			assert.Equal(t, int32(1), sharedWinners.Load())
+
+			check := func(s URLStorage) {
+				for w := 0; w < workers; w++ {
+					urls, err := s.GetURLsByUser(ctx, fmt.Sprintf("user%d", w))
+					require.NoError(t, err)
+					live := 0
+					for _, urlData := range urls {
+						if urlData.OriginalURL != "https://shared" {
+							live++
+						}
+					}
+					assert.Equal(t, perWorker-perWorker/batchSize, live)
+					for i := 0; i < perWorker; i++ {
+						_, err := s.GetOriginalURL(ctx, fmt.Sprintf("w%d-%d", w, i))
+						if i%batchSize == 0 {
+							// удалённые ссылки сжатие убирает совсем
+							if !test.compact || !errors.Is(err, ErrNotFound) {
+								assert.ErrorIs(t, err, ErrDeleted)
+							}
+						} else {
+							assert.NoError(t, err)
+						}
+					}
+				}
+			}
+			check(s)
+			s.Close()
+			if !test.file {
+				return
+			}
+			// после перезапуска журнал должен дать то же состояние
+			reopened, err := NewFileURLStorage(filename, FileOptions{SyncPolicy: SyncNever})
+			require.NoError(t, err)
+			defer reopened.Close()
+			check(reopened)
+		})
+	}
+}
+
+func TestLocalStorageSaveBatchIsAtomic(t *testing.T) {
+	tests := []struct {
+		name      string
+		batch     []*URLData
+		wantErr   error
+		wantIndex int
+		// конфликты всех записей сообщаются разом
+		wantExisting map[int]string
+		wantTaken    []int
+	}{
+		{
+			name: "conflict with stored url",
+			batch: []*URLData{
+				{ShortURL: "b2", OriginalURL: "https://b"},
+				{ShortURL: "c3", OriginalURL: "https://a"},
+			},
+			wantErr:      ErrConflict,
+			wantIndex:    1,
+			wantExisting: map[int]string{1: "a1"},
+		},
+		{
+			name: "short url taken inside batch",
+			batch: []*URLData{
+				{ShortURL: "b2", OriginalURL: "https://b"},
+				{ShortURL: "c3", OriginalURL: "https://c"},
+				{ShortURL: "b2", OriginalURL: "https://d"},
+			},
+			wantErr:   ErrShortURLTaken,
+			wantIndex: 2,
+			wantTaken: []int{2},
+		},
+		{
+			name: "same url twice inside batch",
+			batch: []*URLData{
+				{ShortURL: "b2", OriginalURL: "https://b"},
+				{ShortURL: "c3", OriginalURL: "https://b"},
+			},
+			wantErr:   ErrConflict,
+			wantIndex: 1,
+		},
+		{
+			name: "several conflicts",
+			batch: []*URLData{
+				{ShortURL: "b2", OriginalURL: "https://b"},
+				{ShortURL: "c3", OriginalURL: "https://a"},
+				{ShortURL: "b2", OriginalURL: "https://d"},
+				{ShortURL: "e5", OriginalURL: "https://e"},
+			},
+			wantErr:      ErrConflict,
+			wantIndex:    1,
+			wantExisting: map[int]string{1: "a1"},
+			wantTaken:    []int{2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			filename := filepath.Join(t.TempDir(), "urls.json")
+			s, err := NewFileURLStorage(filename, FileOptions{SyncPolicy: SyncAlways})
+			require.NoError(t, err)
+			require.NoError(t, s.Save(ctx, &URLData{ShortURL: "a1", OriginalURL: "https://a", UserID: "u1"}))
+
+			err = s.SaveBatch(ctx, test.batch)
+			require.ErrorIs(t, err, test.wantErr)
+			var batchErr *BatchError
+			require.ErrorAs(t, err, &batchErr)
+			assert.Equal(t, test.wantIndex, batchErr.Index)
+			assert.Equal(t, test.wantExisting, batchErr.Existing)
+			assert.Equal(t, test.wantTaken, batchErr.Taken)
+
+			// ни одна запись батча не должна остаться ни в памяти, ни в файле
+			check := func(s *LocalURLStorage) {
+				for _, urlData := range test.batch {
+					_, err := s.GetOriginalURL(ctx, urlData.ShortURL)
+					assert.ErrorIs(t, err, ErrNotFound)
+				}
+				urls, err := s.GetURLsByUser(ctx, "u1")
+				require.NoError(t, err)
+				assert.Len(t, urls, 1)
+			}
+			check(s)
+			s.Close()
+			s, err = NewFileURLStorage(filename, FileOptions{SyncPolicy: SyncAlways})
+			require.NoError(t, err)
+			defer s.Close()
+			check(s)
+		})
+	}
+}
+
+func BenchmarkLocalStorageParallel(b *testing.B) {
+	ctx := context.Background()
+	s, err := NewURLStorage("")
+	require.NoError(b, err)
+	defer s.Close()
+	var counter atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := counter.Add(1)
+			shortURL := fmt.Sprintf("b%d", n)
+			if err := s.Save(ctx, &URLData{ShortURL: shortURL, OriginalURL: "https://example.com/" + shortURL}); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := s.GetOriginalURL(ctx, fmt.Sprintf("b%d", n/2+1)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestLocalStoragePurgeExpired(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "urls.json")
+	s, err := NewFileURLStorage(filename, FileOptions{SyncPolicy: SyncAlways})
+	require.NoError(t, err)
+
+	expired := time.Now().Add(-time.Minute)
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, s.SaveBatch(ctx, []*URLData{
+		{ShortURL: "a1", OriginalURL: "https://a", ExpiresAt: &later},
+		{ShortURL: "b2", OriginalURL: "https://b", ExpiresAt: &expired},
+		{ShortURL: "d4", OriginalURL: "https://d", ExpiresAt: &expired},
+	}))
+
+	// до сборщика истёкшая ссылка уже не находится по исходной, и исходную можно сократить заново
+	_, err = s.GetShortURL(ctx, "https://d")
+	assert.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, s.Save(ctx, &URLData{ShortURL: "d5", OriginalURL: "https://d"}))
+	shortURL, err := s.GetShortURL(ctx, "https://d")
+	require.NoError(t, err)
+	assert.Equal(t, "d5", shortURL)
+
+	// истёкшая ссылка становится надгробием: код занят, а исходную ссылку можно сократить заново
+	purged, freed, err := s.PurgeExpired(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	assert.Empty(t, freed)
+	check := func(s *LocalURLStorage) {
+		_, err := s.GetOriginalURL(ctx, "b2")
+		assert.ErrorIs(t, err, ErrExpired)
+		_, err = s.GetShortURL(ctx, "https://b")
+		assert.ErrorIs(t, err, ErrNotFound)
+		got, err := s.GetOriginalURL(ctx, "a1")
+		require.NoError(t, err)
+		assert.Equal(t, "https://a", got)
+	}
+	check(s)
+	assert.ErrorIs(t, s.Save(ctx, &URLData{ShortURL: "b2", OriginalURL: "https://z"}), ErrShortURLTaken)
+	purged, _, err = s.PurgeExpired(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+
+	// надгробие переживает перезапуск и сжатие журнала
+	require.NoError(t, s.Compact())
+	s.Close()
+	s, err = NewFileURLStorage(filename, FileOptions{SyncPolicy: SyncAlways})
+	require.NoError(t, err)
+	defer s.Close()
+	check(s)
+	require.NoError(t, s.Save(ctx, &URLData{ShortURL: "c3", OriginalURL: "https://b"}))
+
+	// по истечении срока хранения надгробие удаляется окончательно
+	purged, freed, err = s.PurgeExpired(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	assert.ElementsMatch(t, []string{"b2", "d4"}, freed)
+	_, err = s.GetOriginalURL(ctx, "b2")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
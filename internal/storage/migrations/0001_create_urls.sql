@@ -0,0 +1,6 @@
+-- исходная таблица, которую раньше лениво создавал URLDBStorage
+CREATE TABLE IF NOT EXISTS urls (
+	short_url varchar NOT NULL,
+	full_url varchar NOT NULL,
+	CONSTRAINT urls_pk PRIMARY KEY (full_url)
+);
@@ -0,0 +1,6 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS id bigserial;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now();
+-- первичный ключ переезжает на id, уникальность full_url сохраняется отдельным ограничением
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_pk;
+ALTER TABLE urls ADD CONSTRAINT urls_pk PRIMARY KEY (id);
+ALTER TABLE urls ADD CONSTRAINT urls_full_url_key UNIQUE (full_url);
@@ -0,0 +1,16 @@
+-- старый код не проверял уникальность short_url, и у разных ссылок мог оказаться один код.
+-- Код остаётся за самой ранней ссылкой: её и находил поиск по коду, а остальные получают
+-- суффикс -<id>, чтобы не потерять ссылки пользователей
+UPDATE urls SET short_url = urls.short_url || '-' || urls.id
+FROM (
+	SELECT id, row_number() OVER (PARTITION BY short_url ORDER BY id) AS n FROM urls
+) AS numbered
+WHERE urls.id = numbered.id AND numbered.n > 1;
+-- новый код может совпасть с существующим, такие повторы придётся разобрать вручную
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM urls GROUP BY short_url HAVING count(*) > 1) THEN
+		RAISE EXCEPTION 'urls.short_url still has duplicates after renaming them to <short_url>-<id>, rename them manually and restart the service';
+	END IF;
+END $$;
+CREATE UNIQUE INDEX IF NOT EXISTS urls_short_url_key ON urls (short_url);
@@ -0,0 +1,2 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS user_id varchar;
+CREATE INDEX IF NOT EXISTS urls_user_id_idx ON urls (user_id);
@@ -0,0 +1,10 @@
+-- события переходов, которые пишет analytics.DBSink
+CREATE TABLE IF NOT EXISTS clicks (
+	id bigserial PRIMARY KEY,
+	short_url varchar NOT NULL,
+	clicked_at timestamptz NOT NULL,
+	referrer varchar NOT NULL DEFAULT '',
+	ip_hash varchar NOT NULL,
+	ua_class varchar NOT NULL
+);
+CREATE INDEX IF NOT EXISTS clicks_short_url_idx ON clicks (short_url, clicked_at);
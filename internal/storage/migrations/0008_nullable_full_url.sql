@@ -0,0 +1,2 @@
+-- истёкшие ссылки остаются надгробиями с пустым full_url, чтобы их коды не выдавались заново
+ALTER TABLE urls ALTER COLUMN full_url DROP NOT NULL;
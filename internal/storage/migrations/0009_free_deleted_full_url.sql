@@ -0,0 +1,2 @@
+-- удалённые ссылки больше не держат full_url, и его можно сократить заново
+UPDATE urls SET full_url = NULL WHERE is_deleted AND full_url IS NOT NULL;
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.version, m.name)
+	}
+}
+
+// TestMigrateDuplicateShortURLs — база со старыми повторами кодов доходит до последней версии
+func TestMigrateDuplicateShortURLs(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+	// отдельная схема на единственном соединении не трогает рабочие таблицы
+	db.SetMaxOpenConns(1)
+	schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+	_, err = db.ExecContext(ctx, "CREATE SCHEMA "+schema)
+	require.NoError(t, err)
+	defer db.ExecContext(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+	_, err = db.ExecContext(ctx, "SET search_path TO "+schema)
+	require.NoError(t, err)
+
+	// база в состоянии версии 2
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, `CREATE TABLE schema_migrations (
+		version integer PRIMARY KEY,
+		name varchar NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	require.NoError(t, err)
+	for _, m := range migrations[:2] {
+		_, err = db.ExecContext(ctx, m.query)
+		require.NoError(t, err)
+		_, err = db.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.version, m.name)
+		require.NoError(t, err)
+	}
+	_, err = db.ExecContext(ctx, `INSERT INTO urls (short_url, full_url) VALUES
+		('a1', 'https://a'), ('b2', 'https://b'), ('a1', 'https://c'), ('a1', 'https://d')`)
+	require.NoError(t, err)
+
+	require.NoError(t, migrate(ctx, db))
+	rows, err := db.QueryContext(ctx, "SELECT id, short_url, full_url FROM urls ORDER BY id")
+	require.NoError(t, err)
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var id int64
+		var shortURL, fullURL string
+		require.NoError(t, rows.Scan(&id, &shortURL, &fullURL))
+		got = append(got, fmt.Sprintf("%s=%s", shortURL, fullURL))
+		if fullURL == "https://c" || fullURL == "https://d" {
+			assert.Equal(t, fmt.Sprintf("a1-%d", id), shortURL)
+		}
+	}
+	require.NoError(t, rows.Err())
+	// код остаётся за первой ссылкой, остальные переименованы
+	assert.Len(t, got, 4)
+	assert.Equal(t, "a1=https://a", got[0])
+	assert.Equal(t, "b2=https://b", got[1])
+}
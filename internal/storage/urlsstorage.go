@@ -1,267 +1,359 @@
 package storage
 
 import (
-	"bufio"
 	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
-	"os"
+	"time"
 
+	"github.com/hessayon/ya_practicum_go/internal/health"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
+// URLStorage — хранилище сокращённых ссылок.
+// Методы возвращают ErrNotFound, ErrConflict или ErrDeleted для ожидаемых ситуаций,
+// любая другая ошибка означает сбой самого хранилища.
 type URLStorage interface {
-	Save(urlData *URLData) (err error)
-	SaveBatch(urlsBatch []*URLData) (err error) 
-	GetOriginalURL(shortURL string) (value string, ok bool)
-	GetShortURL(originalURL string) (value string, ok bool)
+	Save(ctx context.Context, urlData *URLData) (err error)
+	// SaveBatch сохраняет батч целиком или не сохраняет ничего; ошибка из-за конкретной записи
+	// возвращается как *BatchError с её номером
+	SaveBatch(ctx context.Context, urlsBatch []*URLData) (err error)
+	GetOriginalURL(ctx context.Context, shortURL string) (value string, err error)
+	// GetURLData возвращает запись о ссылке целиком, ошибки те же, что у GetOriginalURL.
+	// Вместе с ErrDeleted и ErrExpired запись тоже возвращается, например чтобы проверить владельца
+	GetURLData(ctx context.Context, shortURL string) (urlData *URLData, err error)
+	GetShortURL(ctx context.Context, originalURL string) (value string, err error)
+	GetURLsByUser(ctx context.Context, userID string) (urlsData []*URLData, err error)
+	DeleteURLs(ctx context.Context, tasks []DeleteTask) (err error)
+	// PurgeExpired превращает истёкшие ссылки в надгробия: код остаётся занятым и отвечает
+	// ErrExpired, а исходная ссылка стирается, чтобы её можно было сократить заново.
+	// Окончательно удаляются только ссылки, истёкшие больше retention назад; их коды снова
+	// свободны и возвращаются в freed, чтобы вместе с ними удалить и статистику переходов
+	PurgeExpired(ctx context.Context, retention time.Duration) (purged int, freed []string, err error)
+	// Check сообщает, доступно ли хранилище; используется проверками готовности сервиса
+	health.Checker
 	Close()
-
 }
 
 type URLData struct {
 	UUID        string `json:"uuid"`
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
+	UserID      string `json:"user_id"`
+	IsDeleted   bool   `json:"is_deleted"`
+	// ExpiresAt — момент, после которого ссылка перестаёт работать; nil — бессрочная
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// CreatedAt — момент создания; хранилище проставляет его само, если поле не задано.
+	// nil у ссылок, сохранённых до появления поля
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// stampCreatedAt проставляет время создания ссылкам, у которых его ещё нет
+func stampCreatedAt(urlsBatch []*URLData, now time.Time) {
+	for _, urlData := range urlsBatch {
+		if urlData.CreatedAt == nil {
+			createdAt := now
+			urlData.CreatedAt = &createdAt
+		}
+	}
 }
-//--------------------------------------------------------------------
 
-type URLStorageFileSaver struct {
-	file    *os.File
-	encoder *json.Encoder
+func (urlData *URLData) IsExpired(now time.Time) bool {
+	return urlData.ExpiresAt != nil && !urlData.ExpiresAt.After(now)
 }
 
-type LocalURLStorage struct {
-	ShortToOrig    map[string]string
-	OrigToShort    map[string]string
-	filename string
-	saver    *URLStorageFileSaver
+// DeleteTask — запрос пользователя на удаление одной сокращённой ссылки
+type DeleteTask struct {
+	UserID   string
+	ShortURL string
 }
 //--------------------------------------------------------------------
 
 type URLDBStorage struct {
 	DB *sql.DB
+	// BatchChunkSize — см. DBOptions
+	BatchChunkSize int
 }
 //--------------------------------------------------------------------
 
-var ErrConflict = errors.New("data conflict")
-//--------------------------------------------------------------------
-
-func newStorageSaver(filename string) (*URLStorageFileSaver, error) {
-
-	if filename == "" {
-		return nil, nil
-	}
-	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
-	if err != nil {
-		return nil, err
-	}
+var (
+	ErrConflict = errors.New("data conflict")
+	ErrNotFound = errors.New("data not found")
+	ErrDeleted  = errors.New("data deleted")
+	ErrExpired  = errors.New("data expired")
+	// ErrShortURLTaken — код уже занят другой ссылкой
+	ErrShortURLTaken = errors.New("short url is already taken")
+)
 
-	return &URLStorageFileSaver{
-		file:    file,
-		encoder: json.NewEncoder(file),
-	}, nil
+// BatchError — ошибка сохранения одной из записей батча
+type BatchError struct {
+	Index int
+	Err   error
+	// Existing — коды уже сохранённых ссылок для записей батча с теми же исходными ссылками;
+	// заполняется хранилищами, которые находят такие записи разом, иначе nil
+	Existing map[int]string
+	// Taken — номера записей, чьи короткие коды уже заняты; заполняется вместе с Existing
+	Taken []int
 }
 
-func (storage *LocalURLStorage) Save(urlData *URLData) error {
-	if _, ok := storage.OrigToShort[urlData.OriginalURL]; ok {
-		return ErrConflict
+// addBatchConflict добавляет в ошибку батча конфликт записи i. Первый конфликт задаёт Index и Err,
+// код уже сохранённой ссылки попадает в Existing, если он известен, занятый код — в Taken
+func addBatchConflict(batchErr *BatchError, i int, err error, existing string) *BatchError {
+	if batchErr == nil {
+		batchErr = &BatchError{Index: i, Err: err}
 	}
-	storage.ShortToOrig[urlData.ShortURL] = urlData.OriginalURL
-	storage.OrigToShort[urlData.OriginalURL] = urlData.ShortURL
-	if(storage.saver != nil){
-		return storage.saver.encoder.Encode(urlData)
-	}
-	return nil
-}
-
-func (storage *LocalURLStorage) SaveBatch(urlsBatch []*URLData) error {
-	for _, data := range urlsBatch {
-		err := storage.Save(data)
-		if err != nil {
-			return err
+	switch {
+	case errors.Is(err, ErrConflict) && existing != "":
+		if batchErr.Existing == nil {
+			batchErr.Existing = make(map[int]string)
 		}
+		batchErr.Existing[i] = existing
+	case errors.Is(err, ErrShortURLTaken):
+		batchErr.Taken = append(batchErr.Taken, i)
 	}
-	return nil
+	return batchErr
 }
 
-
-func (storage *LocalURLStorage) GetOriginalURL(shortURL string) (string, bool) {
- 	fullURL, found := storage.ShortToOrig[shortURL]
-	return fullURL, found
+// isBatchConflict отличает конфликт записи, после которого можно проверять следующие, от ошибки хранилища
+func isBatchConflict(err error) bool {
+	return errors.Is(err, ErrConflict) || errors.Is(err, ErrShortURLTaken)
 }
 
-
-func (storage *LocalURLStorage) GetShortURL(originalURL string) (string, bool) {
-	shortURL, found := storage.OrigToShort[originalURL]
- return shortURL, found
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch item %d: %s", e.Index, e.Err.Error())
 }
 
-
-func (storage *LocalURLStorage) Close() {
-	if(storage.saver != nil){
-		storage.saver.file.Close()
-	}
+func (e *BatchError) Unwrap() error {
+	return e.Err
 }
 //--------------------------------------------------------------------
 
 
-func (storage *URLDBStorage) createTable() error {
-	query := `
-	CREATE TABLE urls (
-		short_url varchar NOT NULL,
-		full_url varchar NOT NULL,
-		CONSTRAINT urls_pk PRIMARY KEY (full_url)
-	);`
-	_, err := storage.DB.ExecContext(context.Background(), query)
+// ограничения уникальности из migrations/0002 и migrations/0003
+const (
+	fullURLConstraint  = "urls_full_url_key"
+	shortURLConstraint = "urls_short_url_key"
+)
+
+// convertInsertError переводит нарушения уникальности в ошибки хранилища
+func convertInsertError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		switch pgErr.ConstraintName {
+		case fullURLConstraint:
+			return ErrConflict
+		case shortURLConstraint:
+			return ErrShortURLTaken
+		}
+	}
 	return err
 }
 
 
-func (storage *URLDBStorage) Save(urlData *URLData) error {
-	query := "INSERT INTO urls VALUES ($1, $2);"
-	_, err := storage.DB.ExecContext(context.Background(), query, urlData.ShortURL, urlData.OriginalURL)
-	if err != nil {
-		var pgErr *pgconn.PgError
-		// если не найдена такая таблица, то пробуем создать таблицу
-		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UndefinedTable {
-			err = storage.createTable()
-			if err != nil {
-				return err
-			}
-			_, err := storage.DB.ExecContext(context.Background(), query, urlData.ShortURL, urlData.OriginalURL)
-			return err
-		} else if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
-			err = ErrConflict
-			return err
-		} else {
-			log.Printf("unexpected error: %v", err)
-			return err
-		}
+// freeStaleOriginals стирает full_url у удалённых и истёкших строк с теми же исходными ссылками,
+// что и у вставляемых: сборщик мог ещё не дойти до истёкших, а их full_url держит ограничение уникальности
+func freeStaleOriginals(ctx context.Context, tx *sql.Tx, urlsBatch []*URLData) error {
+	originals := make([]string, 0, len(urlsBatch))
+	for _, urlData := range urlsBatch {
+		originals = append(originals, urlData.OriginalURL)
 	}
-	return nil
+	query := `
+	UPDATE urls SET full_url = NULL
+	WHERE full_url = ANY($1::varchar[]) AND (is_deleted OR expires_at <= now())`
+	_, err := tx.ExecContext(ctx, query, originals)
+	return err
 }
 
-
-func (storage *URLDBStorage) SaveBatch(urlsBatch []*URLData) error {
-	query := "INSERT INTO urls VALUES ($1, $2);"
-	tx, err := storage.DB.Begin()
+func (storage *URLDBStorage) Save(ctx context.Context, urlData *URLData) error {
+	tx, err := storage.DB.BeginTx(ctx, nil)
 	if err != nil {
-			return err
+		return err
 	}
 	defer tx.Rollback()
-	ctx := context.Background()
-	stmt, err := tx.PrepareContext(ctx, query)
-	if err != nil {
+	if err := freeStaleOriginals(ctx, tx, []*URLData{urlData}); err != nil {
 		return err
 	}
-	defer stmt.Close()
-	for _, data := range urlsBatch {
-		_, err := stmt.ExecContext(ctx, data.ShortURL, data.OriginalURL)
-		if err != nil {
-			var pgErr *pgconn.PgError
-			// если не найдена такая таблица, то пробуем создать таблицу
-			if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UndefinedTable {
-				err = storage.createTable()
-				if err != nil {
-					return err
-				}
-				_, err := stmt.ExecContext(ctx, data.ShortURL, data.OriginalURL)
-				if err != nil {
-					return err
-				}
-			} else {
-				return err
-			}			
+	query := "INSERT INTO urls (short_url, full_url, user_id, expires_at) VALUES ($1, $2, $3, $4);"
+	_, err = tx.ExecContext(ctx, query, urlData.ShortURL, urlData.OriginalURL, urlData.UserID, urlData.ExpiresAt)
+	if err != nil {
+		err = convertInsertError(err)
+		if !errors.Is(err, ErrConflict) && !errors.Is(err, ErrShortURLTaken) {
+			log.Printf("unexpected error: %v", err)
 		}
+		return err
 	}
 	return tx.Commit()
 }
 
 
-func (storage *URLDBStorage) GetOriginalURL(shortURL string) (string, bool) {
-	query := "SELECT full_url FROM urls WHERE short_url = $1 LIMIT 1"
-	row := storage.DB.QueryRowContext(context.Background(), query, shortURL)
-	var fullURL string
-	err := row.Scan(&fullURL)
+func (storage *URLDBStorage) GetOriginalURL(ctx context.Context, shortURL string) (string, error) {
+	urlData, err := storage.GetURLData(ctx, shortURL)
+	if err != nil {
+		return "", err
+	}
+	return urlData.OriginalURL, nil
+}
+
+func (storage *URLDBStorage) GetURLData(ctx context.Context, shortURL string) (*URLData, error) {
+	query := "SELECT full_url, user_id, is_deleted, expires_at, created_at FROM urls WHERE short_url = $1 LIMIT 1"
+	row := storage.DB.QueryRowContext(ctx, query, shortURL)
+	// у удалённой ссылки и надгробия истёкшей full_url пустой
+	var fullURL sql.NullString
+	var userID sql.NullString
+	var isDeleted bool
+	var expiresAt sql.NullTime
+	var createdAt time.Time
+	err := row.Scan(&fullURL, &userID, &isDeleted, &expiresAt, &createdAt)
 	if err != nil{
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
 		log.Printf("Error in Scan: %s", err.Error())
-		return "", false
+		return nil, err
+	}
+	urlData := &URLData{
+		ShortURL:    shortURL,
+		OriginalURL: fullURL.String,
+		UserID:      userID.String,
+		IsDeleted:   isDeleted,
+		CreatedAt:   &createdAt,
+	}
+	if expiresAt.Valid {
+		urlData.ExpiresAt = &expiresAt.Time
+	}
+	if isDeleted {
+		return urlData, ErrDeleted
+	}
+	if urlData.IsExpired(time.Now()) {
+		return urlData, ErrExpired
 	}
-	return fullURL, true
+	return urlData, nil
 }
 
-func (storage *URLDBStorage) GetShortURL(originalURL string) (string, bool) {
-	query := "SELECT short_url FROM urls WHERE full_url = $1 LIMIT 1"
-	row := storage.DB.QueryRowContext(context.Background(), query, originalURL)
+// GetShortURL ищет только действующую ссылку: код удалённой или истёкшей ответил бы 410
+func (storage *URLDBStorage) GetShortURL(ctx context.Context, originalURL string) (string, error) {
+	query := `
+	SELECT short_url FROM urls
+	WHERE full_url = $1 AND NOT is_deleted AND (expires_at IS NULL OR expires_at > now())
+	LIMIT 1`
+	row := storage.DB.QueryRowContext(ctx, query, originalURL)
 	var shortURL string
 	err := row.Scan(&shortURL)
 	if err != nil{
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNotFound
+		}
 		log.Printf("Error in Scan: %s", err.Error())
-		return "", false
+		return "", err
 	}
-	return shortURL, true
-}
-
-func (storage *URLDBStorage) Close() {
-	storage.DB.Close()
+	return shortURL, nil
 }
 
-func NewDBURLStorage(dsn string) (URLStorage, error) {
-	db, err := sql.Open("pgx", dsn)
+func (storage *URLDBStorage) GetURLsByUser(ctx context.Context, userID string) ([]*URLData, error) {
+	query := `
+	SELECT short_url, full_url, expires_at FROM urls
+	WHERE user_id = $1 AND NOT is_deleted AND (expires_at IS NULL OR expires_at > now())`
+	rows, err := storage.DB.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+	urlsData := make([]*URLData, 0)
+	for rows.Next() {
+		urlData := &URLData{UserID: userID}
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&urlData.ShortURL, &urlData.OriginalURL, &expiresAt); err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			urlData.ExpiresAt = &expiresAt.Time
+		}
+		urlsData = append(urlsData, urlData)
+	}
+	return urlsData, rows.Err()
+}
 
-	err = db.Ping()
+// DeleteURLs помечает ссылки удалёнными одним UPDATE на весь батч и стирает у них full_url,
+// чтобы исходную ссылку можно было сократить заново
+func (storage *URLDBStorage) DeleteURLs(ctx context.Context, tasks []DeleteTask) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	userIDs := make([]string, 0, len(tasks))
+	shortURLs := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		userIDs = append(userIDs, task.UserID)
+		shortURLs = append(shortURLs, task.ShortURL)
+	}
+	query := `
+	UPDATE urls SET is_deleted = true, full_url = NULL
+	FROM (SELECT unnest($1::varchar[]) AS user_id, unnest($2::varchar[]) AS short_url) AS tasks
+	WHERE urls.user_id = tasks.user_id AND urls.short_url = tasks.short_url;`
+	_, err := storage.DB.ExecContext(ctx, query, userIDs, shortURLs)
+	return err
+}
+
+func (storage *URLDBStorage) PurgeExpired(ctx context.Context, retention time.Duration) (int, []string, error) {
+	rows, err := storage.DB.QueryContext(ctx, "DELETE FROM urls WHERE expires_at <= $1 RETURNING short_url", time.Now().Add(-retention))
 	if err != nil {
-		return nil, err
+		return 0, nil, err
+	}
+	defer rows.Close()
+	var freed []string
+	for rows.Next() {
+		var shortURL string
+		if err := rows.Scan(&shortURL); err != nil {
+			return 0, nil, err
+		}
+		freed = append(freed, shortURL)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
 	}
 
-	return &URLDBStorage{
-		DB: db,
-	}, nil
+	result, err := storage.DB.ExecContext(ctx, "UPDATE urls SET full_url = NULL WHERE expires_at <= now() AND full_url IS NOT NULL")
+	if err != nil {
+		return len(freed), freed, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return len(freed), freed, err
+	}
+	return len(freed) + int(affected), freed, nil
 }
 
+func (storage *URLDBStorage) Check(ctx context.Context) error {
+	return storage.DB.PingContext(ctx)
+}
 
+func (storage *URLDBStorage) Close() {
+	storage.DB.Close()
+}
 
-func NewURLStorage(filename string) (URLStorage, error) {
-
-	storage := &LocalURLStorage{
-		ShortToOrig:    make(map[string]string),
-		OrigToShort: make(map[string]string),
-		filename: filename,
-		saver: nil,
-	}
-	
-	if filename == "" {
-		// значит опция сохранения в файл отключена
-		return storage, nil
-	}
-	file, err := os.OpenFile(filename, os.O_RDONLY|os.O_CREATE, 0666)
+func NewDBURLStorage(dsn string, options DBOptions) (URLStorage, error) {
+	db, err := sql.Open("pgx", dsn)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		var urlData URLData
-		err := json.Unmarshal(scanner.Bytes(), &urlData)
-		if err != nil {
-			return nil, err
-		}
-		storage.ShortToOrig[urlData.ShortURL] = urlData.OriginalURL
-	}
-	if err := scanner.Err(); err != nil {
+
+	err = db.Ping()
+	if err != nil {
 		return nil, err
 	}
-	storageSaver, err := newStorageSaver(filename)
+
+	// схема приводится к актуальной версии до начала обслуживания запросов
+	err = migrate(context.Background(), db)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
-	storage.saver = storageSaver
-	return storage, nil
+
+	return &URLDBStorage{
+		DB:             db,
+		BatchChunkSize: options.BatchChunkSize,
+	}, nil
 }
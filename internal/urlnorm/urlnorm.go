@@ -0,0 +1,104 @@
+// Package urlnorm проверяет ссылки перед сокращением и приводит их к каноническому виду,
+// чтобы равнозначные ссылки получали один короткий код
+package urlnorm
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// MaxLength — наибольшая длина ссылки до и после нормализации
+const MaxLength = 2048
+
+var ErrInvalidURL = errors.New("invalid url")
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Normalize принимает только абсолютные http- и https-ссылки и возвращает их в каноническом
+// виде: хост в нижнем регистре и в IDNA (punycode), без порта по умолчанию и без фрагмента
+func Normalize(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("%w: url is empty", ErrInvalidURL)
+	case len(raw) > MaxLength:
+		return "", fmt.Errorf("%w: url is longer than %d characters", ErrInvalidURL, MaxLength)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return "", fmt.Errorf("%w: %s", ErrInvalidURL, err.Error())
+	}
+	switch {
+	case u.Scheme == "":
+		return "", fmt.Errorf("%w: url must be absolute", ErrInvalidURL)
+	case defaultPorts[u.Scheme] == "":
+		return "", fmt.Errorf("%w: scheme %q is not allowed, only http and https", ErrInvalidURL, u.Scheme)
+	case u.Opaque != "":
+		return "", fmt.Errorf("%w: url must be absolute", ErrInvalidURL)
+	case u.User != nil:
+		// https://bank.com@evil.com — приём фишинга, такие ссылки не сокращаем
+		return "", fmt.Errorf("%w: credentials in url are not allowed", ErrInvalidURL)
+	}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidURL, err.Error())
+	}
+	port := u.Port()
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("%w: invalid port %q", ErrInvalidURL, port)
+		}
+	}
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	u.Host = host
+	if port != "" {
+		u.Host += ":" + port
+	}
+	u.Fragment, u.RawFragment = "", ""
+	u.ForceQuery = false
+
+	normalized := u.String()
+	if len(normalized) > MaxLength {
+		return "", fmt.Errorf("%w: url is longer than %d characters", ErrInvalidURL, MaxLength)
+	}
+	return normalized, nil
+}
+
+// normalizeHost возвращает хост в виде для URL: IP-адреса как есть (IPv6 в скобках),
+// доменные имена — в ASCII и нижнем регистре, без завершающей точки
+func normalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", errors.New("url must have a host")
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if addr.Zone() != "" {
+			return "", errors.New("ip address with zone is not allowed")
+		}
+		if addr.Is6() && !addr.Is4In6() {
+			return "[" + addr.String() + "]", nil
+		}
+		return addr.Unmap().String(), nil
+	}
+	host = strings.TrimSuffix(host, ".")
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil || ascii == "" {
+		return "", fmt.Errorf("invalid host %q", host)
+	}
+	return ascii, nil
+}
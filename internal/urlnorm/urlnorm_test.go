@@ -0,0 +1,65 @@
+package urlnorm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{name: "already canonical", raw: "https://practicum.yandex.ru/learn?x=1", want: "https://practicum.yandex.ru/learn?x=1"},
+		{name: "surrounding whitespace", raw: "  https://example.com/a\n", want: "https://example.com/a"},
+		{name: "uppercase scheme and host", raw: "HTTPS://Example.COM/Path", want: "https://example.com/Path"},
+		{name: "default http port", raw: "http://example.com:80/a", want: "http://example.com/a"},
+		{name: "default https port", raw: "https://example.com:443", want: "https://example.com"},
+		{name: "custom port is kept", raw: "https://example.com:8443/a", want: "https://example.com:8443/a"},
+		{name: "fragment", raw: "https://example.com/a?b=c#section", want: "https://example.com/a?b=c"},
+		{name: "empty query", raw: "https://example.com/a?", want: "https://example.com/a"},
+		{name: "trailing dot in host", raw: "https://example.com./a", want: "https://example.com/a"},
+		{name: "idna", raw: "https://Пример.рф/путь", want: "https://xn--e1afmkfd.xn--p1ai/%D0%BF%D1%83%D1%82%D1%8C"},
+		{name: "ipv4", raw: "http://127.0.0.1:8080/", want: "http://127.0.0.1:8080/"},
+		{name: "ipv6", raw: "http://[2001:DB8::1]:80/", want: "http://[2001:db8::1]/"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			normalized, err := Normalize(test.raw)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, normalized)
+		})
+	}
+}
+
+func TestNormalizeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr string
+	}{
+		{name: "empty", raw: "   ", wantErr: "url is empty"},
+		{name: "too long", raw: "https://example.com/" + strings.Repeat("a", MaxLength), wantErr: "longer than"},
+		{name: "javascript", raw: "javascript:alert(1)", wantErr: `scheme "javascript" is not allowed`},
+		{name: "opaque http", raw: "http:example.com", wantErr: "url must be absolute"},
+		{name: "relative path", raw: "/some/path", wantErr: "url must be absolute"},
+		{name: "scheme-relative", raw: "//example.com/a", wantErr: "url must be absolute"},
+		{name: "ftp", raw: "ftp://example.com/file", wantErr: `scheme "ftp" is not allowed`},
+		{name: "credentials", raw: "https://bank.com@evil.com/", wantErr: "credentials"},
+		{name: "no host", raw: "https:///path", wantErr: "url must have a host"},
+		{name: "bad port", raw: "https://example.com:99999/", wantErr: "invalid port"},
+		{name: "bad host", raw: "https://exa_mple..com/", wantErr: "invalid host"},
+		{name: "control characters", raw: "https://example.com/\x00", wantErr: "invalid control character"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Normalize(test.raw)
+			require.ErrorIs(t, err, ErrInvalidURL)
+			assert.Contains(t, err.Error(), test.wantErr)
+		})
+	}
+}